@@ -0,0 +1,254 @@
+// Package api holds the JSON shapes of the text service's responses and request bodies. The server
+// encodes them, the client package decodes them, and /openapi.json is generated from them.
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Versions struct {
+	Texts          string `json:"texts"`
+	Textcatalog    string `json:"textcatalog,omitempty"`
+	Citedata       string `json:"citedata,omitempty"`
+	Citecatalog    string `json:"citecatalog,omitempty"`
+	Citerelations  string `json:"citerelations,omitempty"`
+	Citeextensions string `json:"citeextensions,omitempty"`
+	DSE            string `json:"dse,omitempty"`
+	ORCA           string `json:"orca,omitempty"`
+}
+
+type CITEResponse struct {
+	Status   string   `json:"status" enum:"Success,Exception"`
+	Service  string   `json:"service"`
+	Versions Versions `json:"versions"`
+}
+
+type VersionResponse struct {
+	Status  string `json:"status" enum:"Success,Exception"`
+	Service string `json:"service"`
+	Version string `json:"version"`
+}
+
+type Node struct {
+	URN      []string `json:"urn"`
+	Text     []string `json:"text,omitempty"`
+	Previous []string `json:"previous,omitempty"`
+	Next     []string `json:"next,omitempty"`
+	Sequence int      `json:"sequence"`
+	Complete bool     `json:"complete"`
+}
+
+type NodeResponse struct {
+	RequestUrn []string `json:"requestUrn"`
+	Status     string   `json:"status" enum:"Success,Exception"`
+	Service    string   `json:"service"`
+	Message    string   `json:"message,omitempty"`
+	Code       string   `json:"code,omitempty"` // error code of a failed batch item, as in problems
+	URN        []string `json:"urns,omitempty"`
+	Nodes      []Node   `json:"nodes,omitempty"`
+	Total      int      `json:"total,omitempty"`   // nodes in the whole result, when it is paged
+	Next       string   `json:"next,omitempty"`    // URL of the next page, if any
+	Version    string   `json:"version,omitempty"` // corpus version the response was served from
+}
+
+type URNResponse struct {
+	RequestUrn []string `json:"requestUrn"`
+	Status     string   `json:"status" enum:"Success,Exception"`
+	Service    string   `json:"service"`
+	Message    string   `json:"message,omitempty"`
+	URN        []string `json:"urns,omitempty"`
+	Total      int      `json:"total,omitempty"` // URNs in the whole result, when it is paged
+	Next       string   `json:"next,omitempty"`  // URL of the next page, if any
+	Version    string   `json:"version,omitempty"`
+}
+
+// BatchItem is one entry of a POST /texts/batch body: a URN with the text filters of GET
+// /texts/{URN}. In JSON it is an object or just the URN as a string.
+type BatchItem struct {
+	URN       string `json:"urn"`
+	Substring string `json:"substring,omitempty"`
+	Clip      *bool  `json:"clip,omitempty"`
+	Context   *int   `json:"context,omitempty"`
+	MaxChars  int    `json:"maxChars,omitempty"`
+	Tail      bool   `json:"tail,omitempty"`
+}
+
+func (b *BatchItem) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*b = BatchItem{}
+		return json.Unmarshal(data, &b.URN)
+	}
+	type plain BatchItem
+	return json.Unmarshal(data, (*plain)(b))
+}
+
+// BatchResponse holds one NodeResponse per item, in request order, each with its own status.
+type BatchResponse struct {
+	Status  string         `json:"status" enum:"Success,Exception"`
+	Service string         `json:"service"`
+	Message string         `json:"message,omitempty"`
+	Results []NodeResponse `json:"results"`
+	Version string         `json:"version,omitempty"`
+}
+
+// SearchHit is one search hit. Anchor is an anchored URN that resolves back to the same hit.
+type SearchHit struct {
+	URN      string `json:"urn"`
+	Anchor   string `json:"anchor"`
+	Text     string `json:"text"` // the hit with some context either side
+	Sequence int    `json:"sequence"`
+}
+
+type SearchResponse struct {
+	Pattern string      `json:"pattern"`
+	Status  string      `json:"status" enum:"Success,Exception"`
+	Service string      `json:"service"`
+	Message string      `json:"message,omitempty"`
+	Hits    []SearchHit `json:"hits,omitempty"`
+	Total   int         `json:"total,omitempty"` // hits in the whole result, when it is paged
+	Next    string      `json:"next,omitempty"`  // URL of the next page, if any
+	Version string      `json:"version,omitempty"`
+}
+
+type CatalogEntry struct {
+	URN            string `json:"urn"`
+	CitationScheme string `json:"citationScheme"`
+	GroupName      string `json:"groupName"`
+	WorkTitle      string `json:"workTitle"`
+	VersionLabel   string `json:"versionLabel,omitempty"`
+	ExemplarLabel  string `json:"exemplarLabel,omitempty"`
+	Online         bool   `json:"online"`
+	Lang           string `json:"lang,omitempty"` // language code, e.g. grc
+}
+
+type CatalogResponse struct {
+	Status  string         `json:"status" enum:"Success,Exception"`
+	Service string         `json:"service"`
+	Entries []CatalogEntry `json:"entries"`
+	Message string         `json:"message,omitempty"`
+	Version string         `json:"version,omitempty"`
+}
+
+type IIIFImage struct {
+	Passage string `json:"passage"`
+	Image   string `json:"image"`
+	Region  string `json:"region"`
+	Service string `json:"service"`
+	URL     string `json:"url"`
+}
+
+type ImageResponse struct {
+	RequestUrn []string    `json:"requestUrn"`
+	Status     string      `json:"status" enum:"Success,Exception"`
+	Service    string      `json:"service"`
+	Message    string      `json:"message,omitempty"`
+	Images     []IIIFImage `json:"images,omitempty"`
+}
+
+type ORCARecord struct {
+	URN         string `json:"urn"`
+	Label       string `json:"label,omitempty"`
+	Passage     string `json:"passage"`
+	Analysis    string `json:"analysis"`
+	Deformation string `json:"deformation,omitempty"`
+	Nodes       []Node `json:"nodes,omitempty"`   // analysed span, resolved like /texts/{URN}
+	Message     string `json:"message,omitempty"` // why the span could not be resolved
+}
+
+type ORCAResponse struct {
+	RequestUrn []string     `json:"requestUrn"`
+	Status     string       `json:"status" enum:"Success,Exception"`
+	Service    string       `json:"service"`
+	Message    string       `json:"message,omitempty"`
+	Alignments []ORCARecord `json:"alignments,omitempty"`
+}
+
+type Diagnostic struct {
+	Source   string `json:"source,omitempty"` // member file, for virtual corpora
+	Line     int    `json:"line"`             // 1-based; 0 for problems with the file as a whole
+	Column   int    `json:"column"`           // 1-based rune column
+	Severity string `json:"severity" enum:"error,warning"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+type ValidationResponse struct {
+	Status      string       `json:"status" enum:"Success,Exception"`
+	Service     string       `json:"service"`
+	Source      string       `json:"source"`
+	Message     string       `json:"message,omitempty"`
+	Valid       bool         `json:"valid"`
+	Errors      int          `json:"errors"`
+	Warnings    int          `json:"warnings"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type AdminResponse struct {
+	Status  string `json:"status" enum:"Success,Exception"`
+	Service string `json:"service"`
+	Message string `json:"message,omitempty"`
+	Ref     string `json:"ref,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+}
+
+type WriteResponse struct {
+	Status      string       `json:"status" enum:"Success,Exception"`
+	Service     string       `json:"service"`
+	Message     string       `json:"message,omitempty"`
+	Corpus      string       `json:"corpus,omitempty"`
+	URN         string       `json:"urn,omitempty"`
+	Version     string       `json:"version,omitempty"` // version now served
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+type ChangeRecord struct {
+	ID       int       `json:"id"` // per corpus, increasing
+	Time     time.Time `json:"time"`
+	Corpus   string    `json:"corpus"`
+	URN      string    `json:"urn"`
+	Action   string    `json:"action" enum:"add,edit,remove,revert"`
+	Author   string    `json:"author"`
+	Reason   string    `json:"reason,omitempty"`
+	Previous *string   `json:"previous"`          // null when the passage was added
+	Text     *string   `json:"text"`              // null when the passage was removed
+	Version  string    `json:"version,omitempty"` // corpus version after the change
+	Reverts  int       `json:"reverts,omitempty"` // the change undone by a revert
+	After    *string   `json:"after,omitempty"`   // for a removal, the kept passage it followed ("" at the start)
+}
+
+type HistoryResponse struct {
+	RequestUrn []string       `json:"requestUrn"`
+	Status     string         `json:"status" enum:"Success,Exception"`
+	Service    string         `json:"service"`
+	Message    string         `json:"message,omitempty"`
+	Changes    []ChangeRecord `json:"changes"`
+}
+
+type ErrorResponse struct {
+	Status  string `json:"status" enum:"Exception"`
+	Service string `json:"service"`
+	Message string `json:"message"`
+}
+
+// Problem is an error response in the RFC 7807 format (application/problem+json). Code is a stable,
+// machine-readable error code and Type the URI naming it. A problem also carries the fields of the
+// endpoint's own response type that apply to the failure, such as requestUrn.
+type Problem struct {
+	Type       string   `json:"type"`
+	Title      string   `json:"title"`
+	Status     int      `json:"status"`
+	Detail     string   `json:"detail,omitempty"`
+	Instance   string   `json:"instance,omitempty"` // the request path
+	Code       string   `json:"code"`
+	RequestUrn []string `json:"requestUrn,omitempty"`
+}
+
+type HealthResponse struct {
+	Status      string         `json:"status" enum:"ok,degraded,unhealthy"`
+	Source      string         `json:"source"`
+	Message     string         `json:"message,omitempty"`
+	Ref         string         `json:"ref,omitempty"` // git sources only
+	Commit      string         `json:"commit,omitempty"`
+	SkippedRows map[string]int `json:"skippedRows,omitempty"` // per loaded source, malformed rows left out of the corpus
+}
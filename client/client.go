@@ -0,0 +1,350 @@
+// Package client calls the text service over HTTP and decodes its responses into the types of
+// package api. Problem responses and Exception envelopes become *Error values; requests that fail
+// on the network or with 429, 502, 503 or 504 are retried with exponential backoff.
+//
+//	c, err := client.New("https://texts.example.org", client.WithCorpus("million"))
+//	res, err := c.Passage(ctx, "urn:cts:greekLit:tlg0016.tlg001.eng:1.1", nil)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GhentCDH/annophis-text-service/api"
+)
+
+// Client is safe for concurrent use. Corpus and Version return adjusted copies.
+type Client struct {
+	base    *url.URL
+	corpus  string // {CEX}, optionally name@version; "" for the service default
+	version string // ?version=
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client (default: one with a 30s timeout).
+func WithHTTPClient(h *http.Client) Option { return func(c *Client) { c.http = h } }
+
+// WithCorpus selects a corpus under a directory base, as in /{CEX}/texts/...
+func WithCorpus(name string) Option { return func(c *Client) { c.corpus = name } }
+
+// WithRetries sets how often a failed request is retried (default 2) and the first delay (default
+// 200ms), doubled on every further attempt.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = n, backoff }
+}
+
+// New returns a client for the service at baseURL, e.g. "http://localhost:8080".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: base URL %q is not http(s)", baseURL)
+	}
+	c := &Client{
+		base:    u,
+		http:    &http.Client{Timeout: 30 * time.Second},
+		retries: 2,
+		backoff: 200 * time.Millisecond,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c, nil
+}
+
+// Corpus returns a copy of c reading from the named corpus ("" for the default).
+func (c *Client) Corpus(name string) *Client {
+	cc := *c
+	cc.corpus = name
+	return &cc
+}
+
+// Version returns a copy of c pinned to a kept corpus version ("" for the current one).
+func (c *Client) Version(v string) *Client {
+	cc := *c
+	cc.version = v
+	return &cc
+}
+
+// TextOptions are the optional text filters of Passage; the zero value returns whole passages.
+type TextOptions struct {
+	Substring string
+	Clip      *bool // nil leaves the server default (clipped for anchored URNs)
+	Context   *int  // runes around the match; nil leaves the server default
+	MaxChars  int   // hard cap on the text length
+	Tail      bool  // anchored URNs: from the match to the end of the passage
+	AsOf      string
+	Limit     int    // page size; the response then has Total and Next
+	Offset    int    // passages to skip
+	Cursor    string // continue a paged result (the cursor parameter of a Next link)
+}
+
+func (o *TextOptions) values() url.Values {
+	q := url.Values{}
+	if o == nil {
+		return q
+	}
+	if o.Substring != "" {
+		q.Set("substring", o.Substring)
+	}
+	if o.Clip != nil {
+		q.Set("clip", strconv.FormatBool(*o.Clip))
+	}
+	if o.Context != nil {
+		q.Set("context", strconv.Itoa(*o.Context))
+	}
+	if o.MaxChars > 0 {
+		q.Set("maxChars", strconv.Itoa(o.MaxChars))
+	}
+	if o.Tail {
+		q.Set("tail", "true")
+	}
+	if o.AsOf != "" {
+		q.Set("asOf", o.AsOf)
+	}
+	setPage(q, o.Limit, o.Offset, o.Cursor)
+	return q
+}
+
+func setPage(q url.Values, limit, offset int, cursor string) {
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		q.Set("offset", strconv.Itoa(offset))
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+}
+
+// Passage resolves a passage, prefix, range or anchored URN.
+func (c *Client) Passage(ctx context.Context, urn string, opts *TextOptions) (*api.NodeResponse, error) {
+	return fetch[api.NodeResponse](ctx, c, "/texts/"+url.PathEscape(urn), opts.values())
+}
+
+// URNs expands a URN or range to the concrete passage URNs.
+func (c *Client) URNs(ctx context.Context, urn string) (*api.URNResponse, error) {
+	return fetch[api.URNResponse](ctx, c, "/texts/urns/"+url.PathEscape(urn), nil)
+}
+
+// URNPage is URNs for one page of at most limit URNs, starting at cursor ("" for the first page).
+// The following page is at the cursor parameter of the response's Next link; ask for it through
+// c.Version(resp.Version) to stay on the same corpus version.
+func (c *Client) URNPage(ctx context.Context, urn string, limit int, cursor string) (*api.URNResponse, error) {
+	q := url.Values{}
+	setPage(q, limit, 0, cursor)
+	return fetch[api.URNResponse](ctx, c, "/texts/urns/"+url.PathEscape(urn), q)
+}
+
+// First returns the first passage of the work urn belongs to.
+func (c *Client) First(ctx context.Context, urn string) (*api.NodeResponse, error) {
+	return c.node(ctx, "/texts/first/", urn)
+}
+
+// Last returns the last passage of the work urn belongs to.
+func (c *Client) Last(ctx context.Context, urn string) (*api.NodeResponse, error) {
+	return c.node(ctx, "/texts/last/", urn)
+}
+
+// Prev returns the passage before urn; its Nodes are empty at the start of a work.
+func (c *Client) Prev(ctx context.Context, urn string) (*api.NodeResponse, error) {
+	return c.node(ctx, "/texts/previous/", urn)
+}
+
+// Next returns the passage after urn; its Nodes are empty at the end of a work.
+func (c *Client) Next(ctx context.Context, urn string) (*api.NodeResponse, error) {
+	return c.node(ctx, "/texts/next/", urn)
+}
+
+func (c *Client) node(ctx context.Context, path, urn string) (*api.NodeResponse, error) {
+	return fetch[api.NodeResponse](ctx, c, path+url.PathEscape(urn), nil)
+}
+
+// Catalog returns the #!ctscatalog entries.
+func (c *Client) Catalog(ctx context.Context) (*api.CatalogResponse, error) {
+	return fetch[api.CatalogResponse](ctx, c, "/texts/catalog", nil)
+}
+
+// WorkURNs lists the work stems of the corpus.
+func (c *Client) WorkURNs(ctx context.Context) (*api.URNResponse, error) {
+	return fetch[api.URNResponse](ctx, c, "/texts", nil)
+}
+
+// Batch resolves many URNs against one corpus version in a single request. Results are in the
+// order of items; each has its own Status, so one unknown URN does not fail the others.
+func (c *Client) Batch(ctx context.Context, items []api.BatchItem) (*api.BatchResponse, error) {
+	body, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var res api.BatchResponse
+	if err := c.call(ctx, http.MethodPost, "/texts/batch", nil, body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// fetch decodes a successful response into a new T.
+func fetch[T any](ctx context.Context, c *Client, path string, q url.Values) (*T, error) {
+	var res T
+	if err := c.call(ctx, http.MethodGet, path, q, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ---- errors ----
+
+var (
+	ErrBadRequest  = errors.New("bad request")
+	ErrNotFound    = errors.New("not found")
+	ErrUnavailable = errors.New("service or source unavailable")
+)
+
+// Error is an Exception response, or an HTTP error whose body is not a response envelope. Use
+// errors.Is with ErrBadRequest, ErrNotFound or ErrUnavailable to classify it.
+type Error struct {
+	StatusCode int      // HTTP status
+	Service    string   // e.g. "/texts"; the request path for problem responses
+	RequestURN []string // as echoed by the service
+	Code       string   // machine-readable error code, e.g. "invalid_anchor" (problem responses only)
+	Message    string
+}
+
+func (e *Error) Error() string {
+	svc := e.Service
+	if svc == "" {
+		svc = "request"
+	}
+	return fmt.Sprintf("text service %s (%d): %s", svc, e.StatusCode, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnavailable:
+		return retryable(e.StatusCode)
+	}
+	return false
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// ---- transport ----
+
+// call requests path (relative to the corpus base) and decodes the envelope into out, retrying
+// network failures and retryable statuses. Only reads go through it, so POSTs are retried too.
+func (c *Client) call(ctx context.Context, method, path string, q url.Values, body []byte, out any) error {
+	u := c.base.String()
+	if c.corpus != "" {
+		u += "/" + url.PathEscape(c.corpus)
+	}
+	u += path
+	if c.version != "" {
+		if q == nil {
+			q = url.Values{}
+		}
+		q.Set("version", c.version)
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	var (
+		status int
+		resp   []byte
+		err    error
+	)
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		status, resp, err = c.do(ctx, method, u, body)
+		if err == nil && !retryable(status) || attempt >= c.retries || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	if err != nil {
+		return err
+	}
+
+	if status >= 400 {
+		var p api.Problem
+		if json.Unmarshal(resp, &p) == nil && p.Type != "" {
+			return &Error{StatusCode: status, Service: p.Instance, RequestURN: p.RequestUrn, Code: p.Code, Message: p.Detail}
+		}
+	}
+	var env struct {
+		RequestUrn []string `json:"requestUrn"`
+		Status     string   `json:"status"`
+		Service    string   `json:"service"`
+		Message    string   `json:"message"`
+	}
+	if json.Unmarshal(resp, &env) != nil || env.Status == "" {
+		msg := strings.TrimSpace(string(resp))
+		if len(msg) > 200 {
+			msg = msg[:200]
+		}
+		if msg == "" {
+			msg = http.StatusText(status)
+		}
+		return &Error{StatusCode: status, Message: msg}
+	}
+	if env.Status != "Success" || status >= 400 {
+		return &Error{StatusCode: status, Service: env.Service, RequestURN: env.RequestUrn, Message: env.Message}
+	}
+	return json.Unmarshal(resp, out)
+}
+
+func (c *Client) do(ctx context.Context, method, u string, body []byte) (int, []byte, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("API-Version", "2") // problems with their statuses, also from servers with legacy_errors
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "annophis-text-service-client/1.0")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GhentCDH/annophis-text-service/api"
+	srv "github.com/GhentCDH/annophis-text-service/internal/server"
+)
+
+const hdt = "urn:cts:greekLit:tlg0016.tlg001.eng:"
+
+// newService serves the server's test corpus in-process; wrap may put a handler in front of it.
+func newService(t *testing.T, wrap func(http.Handler) http.Handler) *Client {
+	t.Helper()
+	h, err := srv.BuildRouter(srv.NewServer(srv.ServerConfig{
+		Source:     "../internal/server/testdata/",
+		TestSource: "../internal/server/testdata/demo.cex",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrap != nil {
+		h = wrap(h)
+	}
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	c, err := New(ts.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPassage(t *testing.T) {
+	c := newService(t, nil)
+	ctx := context.Background()
+
+	res, err := c.Passage(ctx, hdt+"1.2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Nodes) != 1 || res.Nodes[0].Text[0] != "So Io was carried off to Egypt; the Persians say that this is the first wrong." {
+		t.Errorf("Passage(1.2) = %+v", res.Nodes)
+	}
+
+	none, clip := 0, true
+	res, err = c.Passage(ctx, hdt+"1.2", &TextOptions{Substring: "egypt", Clip: &clip, Context: &none})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Nodes[0].Text[0]; got != "Egypt" {
+		t.Errorf("Passage with context 0 = %q, want %q", got, "Egypt")
+	}
+
+	res, err = c.Corpus("demo").Passage(ctx, hdt, &TextOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Nodes) != 2 || res.Total != 4 || res.Next == "" {
+		t.Errorf("paged Passage: %d nodes, total %d, next %q", len(res.Nodes), res.Total, res.Next)
+	}
+}
+
+func TestURNsAndNavigation(t *testing.T) {
+	c := newService(t, nil)
+	ctx := context.Background()
+
+	urns, err := c.URNs(ctx, hdt+"1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{hdt + "1.0", hdt + "1.1", hdt + "1.2"}; !slices.Equal(urns.URN, want) {
+		t.Errorf("URNs = %v, want %v", urns.URN, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		call func(context.Context, string) (*api.NodeResponse, error)
+		urn  string
+		want string
+	}{
+		{"First", c.First, hdt, hdt + "1.0"},
+		{"Last", c.Last, hdt, hdt + "2.1"},
+		{"Prev", c.Prev, hdt + "1.2", hdt + "1.1"},
+		{"Next", c.Next, hdt + "1.2", hdt + "2.1"},
+	} {
+		res, err := tc.call(ctx, tc.urn)
+		if err != nil {
+			t.Errorf("%s(%s): %v", tc.name, tc.urn, err)
+			continue
+		}
+		if len(res.Nodes) != 1 || res.Nodes[0].URN[0] != tc.want {
+			t.Errorf("%s(%s) = %+v, want %s", tc.name, tc.urn, res.Nodes, tc.want)
+		}
+	}
+}
+
+func TestCatalogAndWorkURNs(t *testing.T) {
+	c := newService(t, nil)
+	ctx := context.Background()
+
+	cat, err := c.Catalog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cat.Entries) != 2 || cat.Entries[0].WorkTitle != "Histories" {
+		t.Errorf("Catalog = %+v", cat.Entries)
+	}
+	works, err := c.WorkURNs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{hdt, "urn:cts:greekLit:tlg0012.tlg001.grc:"}; !slices.Equal(works.URN, want) {
+		t.Errorf("WorkURNs = %v, want %v", works.URN, want)
+	}
+}
+
+// flaky answers the first n requests with status before passing requests on, counting them all.
+func flaky(n int32, status int, calls *atomic.Int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= n {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable} {
+		var calls atomic.Int32
+		c := newService(t, flaky(2, status, &calls))
+		if _, err := c.Catalog(context.Background()); err != nil {
+			t.Errorf("%d twice, then success: %v", status, err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("%d twice: %d requests, want 3", status, calls.Load())
+		}
+
+		calls.Store(0)
+		c = newService(t, flaky(3, status, &calls))
+		_, err := c.Catalog(context.Background())
+		var e *Error
+		if !errors.As(err, &e) || e.StatusCode != status || !errors.Is(err, ErrUnavailable) {
+			t.Errorf("%d three times: got %v, want an unavailable *Error", status, err)
+		}
+	}
+}
+
+func TestProblemErrors(t *testing.T) {
+	var calls atomic.Int32
+	c := newService(t, flaky(0, 0, &calls))
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		urn    string
+		kind   error
+		status int
+		code   string
+	}{
+		{hdt + "9.9", ErrNotFound, http.StatusNotFound, "not_found"},
+		{hdt + "1.1@nowhere[1]", ErrNotFound, http.StatusNotFound, "not_found"},
+		{"not-a-urn", ErrBadRequest, http.StatusBadRequest, "invalid_urn"},
+		{hdt + "1.1@/(/", ErrBadRequest, http.StatusBadRequest, "invalid_regex"},
+	} {
+		calls.Store(0)
+		_, err := c.Passage(ctx, tc.urn, nil)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("Passage(%s): got %v, want an *Error", tc.urn, err)
+			continue
+		}
+		if !errors.Is(err, tc.kind) || e.StatusCode != tc.status || e.Code != tc.code || e.Message == "" {
+			t.Errorf("Passage(%s): got %+v, want status %d, code %s", tc.urn, e, tc.status, tc.code)
+		}
+		if errors.Is(err, ErrUnavailable) || calls.Load() != 1 {
+			t.Errorf("Passage(%s): %d requests, want 1 (not retried)", tc.urn, calls.Load())
+		}
+	}
+
+	_, err := c.Corpus("nosuchcorpus").Catalog(ctx)
+	var e *Error
+	if !errors.As(err, &e) || !errors.Is(err, ErrNotFound) || e.Code != "corpus_not_found" {
+		t.Errorf("unknown corpus: got %v", err)
+	}
+}
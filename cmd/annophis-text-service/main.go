@@ -1,69 +1,49 @@
 package main
 
 import (
-	"context"
-	"errors"
-	"log"
-	"net/http"
+	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
-
-	srv "github.com/GhentCDH/annophis-text-service/internal/server"
 )
 
-func main() {
-	cfgPath := os.Getenv("CONFIG")
-	if cfgPath == "" {
-		cfgPath = "./config.json"
-	}
+const usage = `usage: annophis-text-service [command] [flags] [args]
 
-	cfg, err := srv.LoadConfiguration(cfgPath)
-	if err != nil {
-		log.Fatalf("config error: %v", err)
-	}
+Commands:
+  serve      run the HTTP service (default; configured by $CONFIG, else ./config.json)
+  get        resolve passage, range or anchored URNs
+  urns       expand a URN or range to passage URNs
+  catalog    print the #!ctscatalog
+  search     find a substring or /regex/ in the passages
+  export     write URNs as tei, cex, csv, epub or print HTML
+  validate   check CEX sources
 
-	s := srv.NewServer(cfg)
-	router := srv.BuildRouter(s)
-
-	addr := cfg.Port
-	if addr == "" {
-		addr = ":8080"
-	}
-	if addr[0] != ':' && !containsColon(addr) {
-		addr = ":" + addr
-	}
+The commands other than serve and validate read one local CEX file (or URL) given by -cex and answer
+exactly as the service's endpoints would. Run "annophis-text-service <command> -h" for its flags.
+`
 
-	httpSrv := &http.Server{
-		Addr:    addr,
-		Handler: router,
-	}
-
-	// graceful shutdown
-	idle := make(chan struct{})
-	go func() {
-		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-		<-sigCh
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		_ = httpSrv.Shutdown(ctx)
-		close(idle)
-	}()
-
-	log.Printf("Listening on %s ...", addr)
-	if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("server error: %v", err)
+func main() {
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		cmd, args = args[0], args[1:]
 	}
-	<-idle
-}
-
-func containsColon(s string) bool {
-	for _, r := range s {
-		if r == ':' {
-			return true
-		}
+	switch cmd {
+	case "serve":
+		os.Exit(runServe(args))
+	case "get":
+		os.Exit(runGet(args))
+	case "urns":
+		os.Exit(runURNs(args))
+	case "catalog":
+		os.Exit(runCatalog(args))
+	case "search":
+		os.Exit(runSearch(args))
+	case "export":
+		os.Exit(runExport(args))
+	case "validate":
+		os.Exit(runValidate(args))
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
 	}
-	return false
 }
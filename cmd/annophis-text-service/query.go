@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/api"
+	srv "github.com/GhentCDH/annophis-text-service/internal/server"
+)
+
+// The offline commands answer through the service's own handlers (srv.Local), so a URN resolves,
+// filters and renders exactly as it would over HTTP. They exit 1 when a URN is invalid or not
+// found and 2 on usage errors or when the source can't be read.
+
+// corpusFlags are the source flags shared by the offline commands.
+type corpusFlags struct {
+	cex, delimiter, encoding *string
+}
+
+func addCorpusFlags(fs *flag.FlagSet) *corpusFlags {
+	return &corpusFlags{
+		cex:       fs.String("cex", os.Getenv("CEX"), "CEX file, URL or archive member (default $CEX)"),
+		delimiter: fs.String("delimiter", "", `field delimiter ("#", "|", "tab"); default: declared or detected`),
+		encoding:  fs.String("encoding", "", `source encoding ("utf-8", "latin1", "windows-1252")`),
+	}
+}
+
+func (c *corpusFlags) config() srv.CorpusConfig {
+	return srv.CorpusConfig{Delimiter: *c.delimiter, Encoding: *c.encoding}
+}
+
+// local serves the flags' source in-process, reporting a failure on stderr.
+func (c *corpusFlags) local() (*srv.Local, bool) {
+	l, err := srv.NewLocal(*c.cex, c.config())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, false
+	}
+	return l, true
+}
+
+// parseFlags parses args and checks that a source is given and that there are at least minArgs
+// arguments; it prints the usage otherwise.
+func parseFlags(fs *flag.FlagSet, c *corpusFlags, args []string, minArgs int) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if *c.cex == "" || fs.NArg() < minArgs {
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: annophis-text-service %s -cex <source> %s\n", name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// passFlags copies the flags named in names that were set on the command line to q. The flags are
+// named after the query parameters they stand for.
+func passFlags(fs *flag.FlagSet, q url.Values, names ...string) {
+	fs.Visit(func(f *flag.Flag) {
+		if slices.Contains(names, f.Name) {
+			q.Set(f.Name, f.Value.String())
+		}
+	})
+}
+
+// urnPath is route followed by the comma-separated URNs, each escaped as a path segment.
+func urnPath(route string, urns ...string) string {
+	escaped := make([]string, len(urns))
+	for i, u := range urns {
+		escaped[i] = url.PathEscape(u)
+	}
+	return route + strings.Join(escaped, ",")
+}
+
+// query runs one GET against the local service and copies a successful body to w. A problem,
+// Exception envelope or HTTP error is reported on stderr; a source that can't be read exits with 2.
+func query(l *srv.Local, w io.Writer, path string, q url.Values) int {
+	status, header, body := l.Get(context.Background(), path, q)
+	if msg, code, failed := exception(status, header, body); failed {
+		fmt.Fprintln(os.Stderr, msg)
+		if status >= http.StatusInternalServerError || code == "corpus_not_found" || code == "missing_block" {
+			return 2
+		}
+		return 1
+	}
+	if _, err := w.Write(body); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+// exception reports whether a response is an error, with its message and, for problems, its code.
+func exception(status int, header http.Header, body []byte) (msg, code string, failed bool) {
+	switch ct := header.Get("Content-Type"); {
+	case strings.HasPrefix(ct, "application/problem+json"):
+		var p srv.Problem
+		if json.Unmarshal(body, &p) == nil {
+			return p.Detail, p.Code, true
+		}
+	case strings.HasPrefix(ct, "application/json"):
+		var env struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &env) == nil && (env.Status == "Exception" || status >= http.StatusBadRequest) {
+			return env.Message, "", true
+		}
+	}
+	if status >= http.StatusBadRequest {
+		return strings.TrimSpace(string(body)), "", true
+	}
+	return "", "", false
+}
+
+// runGet implements `annophis-text-service get -cex <source> [flags] <URN>...`.
+func runGet(args []string) int {
+	fs := newFlagSet("get", "[-format f] [filters] <URN>...")
+	src := addCorpusFlags(fs)
+	format := fs.String("format", "text", "json, tei, text, markdown, html, csv, tsv, ndjson or jsonld")
+	fs.String("substring", "", "clip to this substring (with -clip)")
+	fs.Bool("clip", false, "clip to the substring or anchor (default true for anchored URNs)")
+	fs.Int("context", 0, "runes around a substring or anchor (default 40 for -substring, 0 for anchors)")
+	fs.Int("maxChars", 0, "cut every passage at this many runes")
+	fs.Bool("tail", false, "anchored URNs: from the match to the end of the passage")
+	fs.String("labels", "", `text format: prefix passages with their "ref" or "urn"`)
+	fs.String("sep", "", `text format: passage separator (default a newline; \n and \t are unescaped)`)
+	if !parseFlags(fs, src, args, 1) {
+		return 2
+	}
+
+	l, ok := src.local()
+	if !ok {
+		return 2
+	}
+	q := url.Values{"format": {*format}}
+	passFlags(fs, q, "substring", "clip", "context", "maxChars", "tail", "labels", "sep")
+	for _, urn := range fs.Args() {
+		if code := query(l, os.Stdout, urnPath("/texts/", urn), q); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// runURNs implements `annophis-text-service urns -cex <source> [-format f] <URN>`.
+func runURNs(args []string) int {
+	fs := newFlagSet("urns", "[-format f] <URN>")
+	src := addCorpusFlags(fs)
+	format := fs.String("format", "text", "text (one URN per line), json, csv, tsv or ndjson")
+	if !parseFlags(fs, src, args, 1) {
+		return 2
+	}
+
+	l, ok := src.local()
+	if !ok {
+		return 2
+	}
+	path := urnPath("/texts/urns/", fs.Arg(0))
+	if *format != "text" {
+		return query(l, os.Stdout, path, url.Values{"format": {*format}})
+	}
+	var out strings.Builder
+	if code := query(l, &out, path, url.Values{"format": {"json"}}); code != 0 {
+		return code
+	}
+	var res api.URNResponse
+	if err := json.Unmarshal([]byte(out.String()), &res); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, u := range res.URN {
+		fmt.Println(u)
+	}
+	return 0
+}
+
+// runCatalog implements `annophis-text-service catalog -cex <source> [-format f]`.
+func runCatalog(args []string) int {
+	fs := newFlagSet("catalog", "[-format f]")
+	src := addCorpusFlags(fs)
+	format := fs.String("format", "tsv", "tsv, csv, ndjson, json, jsonld or turtle")
+	if !parseFlags(fs, src, args, 0) {
+		return 2
+	}
+	l, ok := src.local()
+	if !ok {
+		return 2
+	}
+	return query(l, os.Stdout, "/texts/catalog", url.Values{"format": {*format}})
+}
+
+// runExport implements `annophis-text-service export -cex <source> [-format f] [-o file] <URN>...`.
+// cex, epub and print take several URNs, like /texts/export; tei and csv render one.
+func runExport(args []string) int {
+	fs := newFlagSet("export", "[-format f] [-o file] <URN>...")
+	src := addCorpusFlags(fs)
+	format := fs.String("format", "cex", "cex, tei, csv, epub or print")
+	out := fs.String("o", "", "write to this file instead of standard output")
+	if !parseFlags(fs, src, args, 1) {
+		return 2
+	}
+
+	var path string
+	switch *format {
+	case "cex", "epub", "print":
+		path = urnPath("/texts/export/", fs.Args()...)
+	case "tei", "csv":
+		if fs.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "export -format %s takes one URN\n", *format)
+			return 2
+		}
+		path = urnPath("/texts/", fs.Arg(0))
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported export format %q; use cex, tei, csv, epub or print\n", *format)
+		return 2
+	}
+
+	l, ok := src.local()
+	if !ok {
+		return 2
+	}
+	var buf strings.Builder
+	if code := query(l, &buf, path, url.Values{"format": {*format}}); code != 0 {
+		return code
+	}
+	if *out == "" {
+		fmt.Print(buf.String())
+		return 0
+	}
+	if err := os.WriteFile(*out, []byte(buf.String()), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
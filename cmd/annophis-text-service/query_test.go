@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const demo = "../../internal/server/testdata/demo.cex"
+
+// run calls a command with its standard output captured.
+func run(t *testing.T, cmd func([]string) int, args ...string) (string, int) {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdout := os.Stdout
+	os.Stdout = f
+	code := cmd(args)
+	os.Stdout = stdout
+	out, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), code
+}
+
+func TestSearchAnchorsResolveWithGet(t *testing.T) {
+	for _, pattern := range []string{"Persian", "/Pers[a-z]+/", "/Egypt;?/", "/off to/", "/μ[^ ]+/"} {
+		out, code := run(t, runSearch, "-cex", demo, "-context", "0", pattern)
+		if code != 0 {
+			t.Fatalf("search %s: exit %d", pattern, code)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+			anchor, text, ok := strings.Cut(line, "\t")
+			if !ok {
+				t.Fatalf("search %s: unexpected line %q", pattern, line)
+			}
+			got, code := run(t, runGet, "-cex", demo, "-format", "text", anchor)
+			if code != 0 || strings.TrimSuffix(got, "\n") != text {
+				t.Errorf("get %s = %q (exit %d), want %q", anchor, got, code, text)
+			}
+		}
+	}
+}
+
+func TestSearchExitCodes(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"-cex", demo, "Hector"}, 1},
+		{[]string{"-cex", demo, "/(/"}, 1},
+		{[]string{"-cex", "nosuchfile.cex", "Io"}, 2},
+		{[]string{"-cex", demo, "-limit", "1", "-json", "the"}, 0},
+	} {
+		if out, code := run(t, runSearch, c.args...); code != c.want {
+			t.Errorf("search %q: exit %d (%s), want %d", c.args, code, out, c.want)
+		}
+	}
+	out, _ := run(t, runSearch, "-cex", demo, "-limit", "1", "-json", "the")
+	if strings.Count(out, `"anchor"`) != 1 {
+		t.Errorf("search -limit 1 -json = %s, want one hit", out)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/api"
+)
+
+// runSearch implements `annophis-text-service search -cex <source> [flags] <pattern>`. Every hit is
+// printed as an anchored URN and the text around it; the anchored URN resolves back to the hit with
+// `get`. It exits 1 when nothing is found.
+func runSearch(args []string) int {
+	fs := newFlagSet("search", "[-within URN] [-context n] [-limit n] [-json] <substring or /regex/>")
+	src := addCorpusFlags(fs)
+	fs.String("within", "", "only passages whose URN starts with this prefix")
+	window := fs.Int("context", 40, "runes shown either side of a hit")
+	limit := fs.Int("limit", 0, "stop after this many hits (0 for all)")
+	asJSON := fs.Bool("json", false, "print the hits as a JSON array")
+	if !parseFlags(fs, src, args, 1) {
+		return 2
+	}
+
+	l, ok := src.local()
+	if !ok {
+		return 2
+	}
+	q := url.Values{"q": {fs.Arg(0)}, "context": {strconv.Itoa(*window)}, "format": {"json"}}
+	passFlags(fs, q, "within")
+	if *limit > 0 {
+		q.Set("limit", strconv.Itoa(*limit))
+	}
+	var out strings.Builder
+	if code := query(l, &out, "/texts/search", q); code != 0 {
+		return code
+	}
+	var res api.SearchResponse
+	if err := json.Unmarshal([]byte(out.String()), &res); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	hits := res.Hits
+	if hits == nil {
+		hits = []api.SearchHit{}
+	}
+
+	if *asJSON {
+		_ = json.NewEncoder(os.Stdout).Encode(hits)
+	} else {
+		for _, h := range hits {
+			fmt.Printf("%s\t%s\n", h.Anchor, h.Text)
+		}
+	}
+	if len(hits) == 0 {
+		return 1
+	}
+	return 0
+}
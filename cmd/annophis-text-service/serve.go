@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	srv "github.com/GhentCDH/annophis-text-service/internal/server"
+)
+
+// runServe implements `annophis-text-service [serve]`: the HTTP service, configured by $CONFIG
+// (default ./config.json).
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: annophis-text-service [serve]   (configured by $CONFIG, default ./config.json)")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfgPath := os.Getenv("CONFIG")
+	if cfgPath == "" {
+		cfgPath = "./config.json"
+	}
+
+	cfg, err := srv.LoadConfiguration(cfgPath)
+	if err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+
+	s := srv.NewServer(cfg)
+	router, err := srv.BuildRouter(s)
+	if err != nil {
+		log.Fatalf("router error: %v", err)
+	}
+
+	addr := cfg.Port
+	if addr == "" {
+		addr = ":8080"
+	}
+	if addr[0] != ':' && !containsColon(addr) {
+		addr = ":" + addr
+	}
+
+	httpSrv := &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+
+	// graceful shutdown
+	idle := make(chan struct{})
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpSrv.Shutdown(ctx)
+		close(idle)
+	}()
+
+	log.Printf("Listening on %s ...", addr)
+	if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("server error: %v", err)
+	}
+	<-idle
+	return 0
+}
+
+func containsColon(s string) bool {
+	for _, r := range s {
+		if r == ':' {
+			return true
+		}
+	}
+	return false
+}
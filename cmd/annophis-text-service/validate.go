@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	srv "github.com/GhentCDH/annophis-text-service/internal/server"
+)
+
+// runValidate implements `annophis-text-service validate [-json] <file-or-url>...`.
+// Sources may be compressed or archive members, as for cex_source.
+// It exits 1 when any source has errors and 2 on usage or read failures.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print diagnostics as JSON")
+	delimiter := fs.String("delimiter", "", `field delimiter ("#", "|", "tab"); default: declared or detected`)
+	encoding := fs.String("encoding", "", `source encoding ("utf-8", "latin1", "windows-1252")`)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: annophis-text-service validate [-json] [-delimiter d] [-encoding e] <file-or-url>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	code := 0
+	for _, src := range fs.Args() {
+		rc, err := srv.OpenSource(context.Background(), src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", src, err)
+			return 2
+		}
+		diags, err := srv.ValidateCEX(rc, srv.CorpusConfig{Delimiter: *delimiter, Encoding: *encoding})
+		rc.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", src, err)
+			return 2
+		}
+
+		errs := 0
+		for _, d := range diags {
+			if d.Severity == "error" {
+				errs++
+			}
+		}
+		if errs > 0 {
+			code = 1
+		}
+
+		if *asJSON {
+			_ = json.NewEncoder(os.Stdout).Encode(struct {
+				Source      string           `json:"source"`
+				Valid       bool             `json:"valid"`
+				Diagnostics []srv.Diagnostic `json:"diagnostics"`
+			}{src, errs == 0, diags})
+			continue
+		}
+		for _, d := range diags {
+			fmt.Printf("%s:%d:%d: %s: %s [%s]\n", src, d.Line, d.Column, d.Severity, d.Message, d.Code)
+		}
+		fmt.Printf("%s: %d error(s), %d warning(s)\n", src, errs, len(diags)-errs)
+	}
+	return code
+}
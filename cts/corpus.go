@@ -0,0 +1,123 @@
+// Package cts is the text service's CTS engine without HTTP: it parses CEX corpora and resolves
+// passage, prefix, range and anchored URNs against them exactly as the /texts endpoints do.
+//
+//	c, err := cts.Read(f, cts.ReadOptions{})
+//	nodes, err := c.Resolve("urn:cts:greekLit:tlg0016.tlg001.eng:1.1@Persian[1]", cts.Options{})
+package cts
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/api"
+	"github.com/GhentCDH/annophis-text-service/internal/cex"
+)
+
+// Corpus is a parsed CEX source. Resolution only reads it, so one Corpus may be shared between
+// goroutines. A Corpus can also be built by hand from parallel URNs and Texts.
+type Corpus struct {
+	URNs     []string // #!ctsdata passage URNs in source order
+	Texts    []string // passage texts, parallel to URNs
+	Catalog  []api.CatalogEntry
+	CiteData []CiteData
+	Sections map[string]bool // block names present in the source
+	Skipped  []int           // lines of #!ctsdata and #!ctscatalog rows too short to use, left out
+}
+
+// CiteData is one #!citedata block: its header row and the records below it.
+type CiteData struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Col returns the index of the first header column matching one of names (case-insensitive), or -1.
+func (b CiteData) Col(names ...string) int {
+	for _, n := range names {
+		for i, h := range b.Header {
+			if strings.EqualFold(strings.TrimSpace(h), n) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ReadOptions are the source settings of Read; the zero value reads UTF-8 with a declared or
+// detected delimiter.
+type ReadOptions struct {
+	Delimiter string // a single character, or "tab", "pipe", "hash"
+	Encoding  string // utf-8, latin1 or windows-1252; a UTF-16 byte order mark overrides it
+}
+
+// Read parses a CEX stream block by block, keeping only the parsed rows in memory. A #!ctsdata
+// text runs to the end of its line, delimiters included; #!ctsdata rows without a delimiter and
+// #!ctscatalog rows with fewer than 4 fields are left out and listed in Skipped. The error is for
+// undecodable input and read failures.
+func Read(r io.Reader, opts ReadOptions) (*Corpus, error) {
+	cr, err := cex.NewReader(r, cex.Options{Delimiter: opts.Delimiter, Encoding: opts.Encoding})
+	if err != nil {
+		return nil, err
+	}
+	c := &Corpus{}
+	for {
+		row, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch row.Section {
+		case "ctsdata":
+			fields := cr.SplitN(row.Text, 2)
+			if len(fields) != 2 {
+				c.Skipped = append(c.Skipped, row.Num)
+				continue
+			}
+			c.URNs = append(c.URNs, strings.TrimSpace(fields[0]))
+			c.Texts = append(c.Texts, fields[1])
+		case "ctscatalog":
+			fields := cr.Split(row.Text)
+			if row.First && strings.EqualFold(strings.TrimSpace(fields[0]), "urn") {
+				continue
+			}
+			if len(fields) < 4 {
+				c.Skipped = append(c.Skipped, row.Num)
+				continue
+			}
+			c.Catalog = append(c.Catalog, catalogEntry(fields))
+		case "citedata":
+			fields := cr.Split(row.Text)
+			if row.First {
+				c.CiteData = append(c.CiteData, CiteData{Header: fields})
+				continue
+			}
+			b := &c.CiteData[len(c.CiteData)-1]
+			b.Rows = append(b.Rows, fields)
+		}
+	}
+	c.Sections = cr.Seen()
+	return c, nil
+}
+
+func catalogEntry(fields []string) api.CatalogEntry {
+	var entry api.CatalogEntry
+	entry.URN = strings.TrimSpace(fields[0])
+	entry.CitationScheme = strings.TrimSpace(fields[1])
+	entry.GroupName = strings.TrimSpace(fields[2])
+	entry.WorkTitle = strings.TrimSpace(fields[3])
+	if len(fields) > 4 {
+		entry.VersionLabel = strings.TrimSpace(fields[4])
+	}
+	if len(fields) > 5 {
+		entry.ExemplarLabel = strings.TrimSpace(fields[5])
+	}
+	if len(fields) > 6 {
+		entry.Online = strings.EqualFold(strings.TrimSpace(fields[6]), "true")
+	}
+	if len(fields) > 7 {
+		entry.Lang = strings.TrimSpace(fields[7])
+	}
+	return entry
+}
@@ -0,0 +1,32 @@
+package cts
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadKeepsDelimitersInTexts(t *testing.T) {
+	src := "#!ctscatalog\n" +
+		"urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang\n" +
+		"urn:cts:greekLit:tlg0012.tlg001.grc:#book.line#Homer#Iliad#Greek text##true#grc\n" +
+		"urn:cts:greekLit:tlg0012.tlg002.grc:#book.line\n" +
+		"\n#!ctsdata\n" +
+		"urn:cts:greekLit:tlg0012.tlg001.grc:1.4#first\n" +
+		"urn:cts:greekLit:tlg0012.tlg001.grc:1.5#upon the # Achaeans\n" +
+		"urn:cts:greekLit:tlg0012.tlg001.grc:1.6 without a delimiter\n" +
+		"urn:cts:greekLit:tlg0012.tlg001.grc:1.7#last\n"
+	c, err := Read(strings.NewReader(src), ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"first", "upon the # Achaeans", "last"}; !slices.Equal(c.Texts, want) {
+		t.Errorf("Texts = %q, want %q", c.Texts, want)
+	}
+	if want := []int{4, 9}; !slices.Equal(c.Skipped, want) {
+		t.Errorf("Skipped = %v, want lines %v", c.Skipped, want)
+	}
+	if len(c.Catalog) != 1 || c.Catalog[0].WorkTitle != "Iliad" || c.Catalog[0].Lang != "grc" {
+		t.Errorf("Catalog = %+v", c.Catalog)
+	}
+}
@@ -0,0 +1,91 @@
+package cts
+
+import (
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/api"
+	cite "github.com/ThomasK81/gocite"
+)
+
+// First returns the first passage of urn's work (its first four URN components) in source order.
+// urn may be the work itself or any passage in it. Errors are *Error values.
+func (c *Corpus) First(urn string) (api.Node, error) { return c.end(urn, true) }
+
+// Last returns the last passage of urn's work in source order.
+func (c *Corpus) Last(urn string) (api.Node, error) { return c.end(urn, false) }
+
+// Prev returns the passage before urn in its work; ok is false when urn is the work's first.
+// Previous and next never leave the work, even when another work follows in the corpus.
+func (c *Corpus) Prev(urn string) (n api.Node, ok bool, err error) { return c.step(urn, -1) }
+
+// Next returns the passage after urn in its work; ok is false when urn is the work's last.
+func (c *Corpus) Next(urn string) (n api.Node, ok bool, err error) { return c.step(urn, 1) }
+
+func (c *Corpus) end(urn string, first bool) (api.Node, error) {
+	if !cite.IsCTSURN(urn) {
+		return api.Node{}, errorf(ErrInvalid, "%s is not valid CTS.", urn)
+	}
+	work := c.work(urn)
+	if len(work) == 0 {
+		return api.Node{}, errorf(ErrNotFound, "No results for %s", urn)
+	}
+	if first {
+		return c.workNode(work, 0), nil
+	}
+	return c.workNode(work, len(work)-1), nil
+}
+
+func (c *Corpus) step(urn string, by int) (api.Node, bool, error) {
+	if !cite.IsCTSURN(urn) {
+		return api.Node{}, false, errorf(ErrInvalid, "%s is not valid CTS.", urn)
+	}
+	work := c.work(urn)
+	k := -1
+	for j, i := range work {
+		if c.URNs[i] == urn {
+			k = j
+			break
+		}
+	}
+	if k < 0 {
+		return api.Node{}, false, errorf(ErrNotFound, "Could not find node to %s in source.", urn)
+	}
+	if k += by; k < 0 || k >= len(work) {
+		return api.Node{}, false, nil
+	}
+	return c.workNode(work, k), true, nil
+}
+
+// work returns the indexes of the passages in urn's work, in source order.
+func (c *Corpus) work(urn string) []int {
+	p := strings.Split(urn, ":")
+	if len(p) < 4 {
+		return nil
+	}
+	stem := strings.Join(p[:4], ":") + ":"
+	var out []int
+	for i, id := range c.URNs {
+		if strings.HasPrefix(id, stem) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// workNode is the whole passage work[k], linked to its neighbours within the work.
+func (c *Corpus) workNode(work []int, k int) api.Node {
+	i := work[k]
+	n := api.Node{
+		URN:      []string{c.URNs[i]},
+		Text:     []string{c.Texts[i]},
+		Sequence: i + 1,
+		Complete: true,
+	}
+	if k > 0 {
+		n.Previous = []string{c.URNs[work[k-1]]}
+	}
+	if k+1 < len(work) {
+		n.Next = []string{c.URNs[work[k+1]]}
+	}
+	return n
+}
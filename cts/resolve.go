@@ -0,0 +1,616 @@
+package cts
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/api"
+	cite "github.com/ThomasK81/gocite"
+)
+
+// Options are the text filters of a resolution; the zero value returns whole passages and, for
+// anchored URNs, just the match.
+type Options struct {
+	Substring string // with Clip, cut passages down to the first match and Context runes around it
+	Clip      *bool  // nil: false, but true for anchored URNs
+	Context   *int   // nil: 40 around a Substring, 0 around an anchor
+	MaxChars  int    // cap on the text of each node; 0 for none
+	Tail      bool   // anchored URNs: from the match to the end of the passage
+}
+
+var (
+	ErrInvalid  = errors.New("invalid URN")
+	ErrNotFound = errors.New("not found")
+
+	// Malformed anchors and regular expressions are also ErrInvalid.
+	ErrInvalidAnchor = fmt.Errorf("invalid anchor: %w", ErrInvalid)
+	ErrInvalidRegex  = fmt.Errorf("invalid regex: %w", ErrInvalid)
+)
+
+// Error is a failed resolution. Its message is meant for users; it unwraps to ErrNotFound, or to
+// ErrInvalid and possibly ErrInvalidAnchor or ErrInvalidRegex.
+type Error struct {
+	Err     error
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+func (e *Error) Unwrap() error { return e.Err }
+
+func errorf(kind error, format string, args ...any) error {
+	return &Error{Err: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// Resolve expands urn (exact, prefix, range, anchored) against the corpus and returns the nodes in
+// source order. Errors are *Error values.
+func (c *Corpus) Resolve(urn string, opts Options) ([]api.Node, error) {
+	var nodes []api.Node
+	err := c.ResolveEach(urn, opts, func(n api.Node) error {
+		nodes = append(nodes, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// ResolveEach is Resolve handing the nodes to emit one by one, so large results can be streamed.
+// Resolution errors are always returned before the first node; an error from emit stops the
+// resolution and is returned as is.
+func (c *Corpus) ResolveEach(reqURN string, opts Options, emit func(api.Node) error) error {
+	allURNs, allTexts := c.URNs, c.Texts
+	// --- Anchored (single); a regex anchor may contain "-" without making a range
+	if cite.WantSubstr(reqURN) && (!cite.IsRange(reqURN) || regexAnchored(reqURN)) {
+		baseURN, needle, occ, ok := parseAnchoredURN(reqURN)
+		if !ok {
+			return errorf(ErrInvalidAnchor, "Malformed anchored URN.")
+		}
+		if !cite.IsCTSURN(baseURN) {
+			return errorf(ErrInvalid, "%s is not valid CTS.", baseURN)
+		}
+		idx := slices.Index(allURNs, baseURN)
+		if idx < 0 {
+			return errorf(ErrNotFound, "Could not find base passage %s", baseURN)
+		}
+		full := allTexts[idx]
+
+		var textOut string
+		var complete bool
+		if strings.HasPrefix(needle, "/") && strings.HasSuffix(needle, "/") {
+			pat := strings.TrimSuffix(strings.TrimPrefix(needle, "/"), "/")
+			re, err := regexp.Compile("(?i)" + pat)
+			if err != nil {
+				return errorf(ErrInvalidRegex, "Invalid regex pattern.")
+			}
+			matches := re.FindAllStringIndex(full, -1)
+			if occ < 1 || occ > len(matches) {
+				return errorf(ErrNotFound, "Regex %q (occurrence %d) not found in %s.", pat, occ, baseURN)
+			}
+			start := matches[occ-1][0]
+			end := matches[occ-1][1]
+			textOut, complete = anchorWindowFromByteOffsets(opts, full, start, end)
+		} else {
+			startRune, endRune := findNthInsensitive(full, needle, occ)
+			if startRune < 0 {
+				return errorf(ErrNotFound, "Substring %q (occurrence %d) not found in %s.", needle, occ, baseURN)
+			}
+			rns := []rune(full)
+			textOut, complete = anchorWindowFromRuneOffsets(opts, rns, startRune, endRune)
+		}
+
+		node := api.Node{
+			URN:      []string{baseURN},
+			Text:     []string{textOut},
+			Sequence: idx + 1,
+			Complete: complete,
+		}
+		attachNeighbors(&node, allURNs, idx)
+
+		return emit(node)
+	}
+
+	// Validate CTS/range
+	if !cite.IsCTSURN(reqURN) && !cite.IsRange(reqURN) {
+		return errorf(ErrInvalid, "%s is not valid CTS.", reqURN)
+	}
+
+	// --- Exact node
+	if idx := slices.Index(allURNs, reqURN); idx >= 0 {
+		txt := allTexts[idx]
+		txt, complete := applyTextFilters(opts, txt)
+		node := api.Node{
+			URN:      []string{allURNs[idx]},
+			Text:     []string{txt},
+			Sequence: idx + 1,
+			Complete: complete,
+		}
+		attachNeighbors(&node, allURNs, idx)
+		return emit(node)
+	}
+
+	// --- Prefix expansion (non-range)
+	if !cite.IsRange(reqURN) {
+		found := false
+		for i, id := range allURNs {
+			if strings.HasPrefix(id, reqURN) {
+				txt, complete := applyTextFilters(opts, allTexts[i])
+				n := api.Node{
+					URN:      []string{id},
+					Text:     []string{txt},
+					Sequence: i + 1,
+					Complete: complete,
+				}
+				attachNeighbors(&n, allURNs, i)
+				if err := emit(n); err != nil {
+					return err
+				}
+				found = true
+			}
+		}
+		if !found {
+			return errorf(ErrNotFound, "Could not find node to %s in source.", reqURN)
+		}
+		return nil
+	}
+
+	// --- Range (supports anchors on both sides)
+	parts := strings.Split(reqURN, ":")
+	if len(parts) < 5 {
+		return errorf(ErrInvalid, "Could not parse %s", reqURN)
+	}
+	stem := strings.Join(parts[:4], ":") + ":"
+	rangeRef := parts[4]
+	dash := strings.Index(rangeRef, "-")
+	if dash <= 0 || dash >= len(rangeRef)-1 {
+		return errorf(ErrInvalid, "Could not parse range %s", reqURN)
+	}
+	leftTok := rangeRef[:dash]
+	rightTok := rangeRef[dash+1:]
+
+	lRef, lNeedle, lOcc, lAnch := parseRefAnchorToken(leftTok)
+	rRef, rNeedle, rOcc, rAnch := parseRefAnchorToken(rightTok)
+	if rAnch && rRef == "" {
+		rRef = lRef
+	}
+
+	// filter to this stem; seq keeps each passage's position in the source
+	var fURNs, fTexts []string
+	var seq []int
+	for i, id := range allURNs {
+		if strings.HasPrefix(id, stem) {
+			fURNs = append(fURNs, id)
+			fTexts = append(fTexts, allTexts[i])
+			seq = append(seq, i+1)
+		}
+	}
+	if len(fURNs) == 0 {
+		return errorf(ErrNotFound, "Could not find node to %s in source.", reqURN)
+	}
+
+	startID := stem + lRef
+	endID := stem + rRef
+
+	sIdx := slices.Index(fURNs, startID)
+	if sIdx < 0 && lRef != "" {
+		sIdx = firstPrefixIndex(fURNs, startID)
+	}
+	eIdx := slices.Index(fURNs, endID)
+	if eIdx < 0 && rRef != "" {
+		eIdx = firstPrefixIndex(fURNs, endID)
+	}
+
+	// both anchors in same passage
+	if lAnch && rAnch && rRef == lRef && sIdx >= 0 {
+		full := fTexts[sIdx]
+		startRune, endRuneStart := findNthInsensitive(full, lNeedle, lOcc)
+		if startRune < 0 {
+			return errorf(ErrNotFound, "Start anchor %q (occurrence %d) not found in %s.", lNeedle, lOcc, stem+lRef)
+		}
+		erS, erE := findNthInsensitive(full, rNeedle, rOcc)
+		if erS < 0 || erS < endRuneStart {
+			return errorf(ErrNotFound, "End anchor %q (occurrence %d) not found after start in %s.", rNeedle, rOcc, stem+lRef)
+		}
+		rns := []rune(full)
+		txt, complete := sliceBetweenRunes(rns, startRune, erE)
+		node := api.Node{
+			URN:      []string{fURNs[sIdx]},
+			Text:     []string{txt},
+			Sequence: seq[sIdx],
+			Complete: complete,
+		}
+		attachNeighbors(&node, fURNs, sIdx)
+		return emit(node)
+	}
+
+	if sIdx < 0 {
+		return errorf(ErrNotFound, "Start of range not found.")
+	}
+	if rRef != "" && eIdx < 0 {
+		return errorf(ErrNotFound, "End of range not found.")
+	}
+	if !rAnch && rRef == "" {
+		return errorf(ErrInvalid, "Right side of range missing.")
+	}
+	if eIdx >= 0 && sIdx > eIdx {
+		sIdx, eIdx = eIdx, sIdx
+		lAnch, rAnch = rAnch, lAnch
+		lRef, rRef = rRef, lRef
+		lNeedle, rNeedle = rNeedle, lNeedle
+		lOcc, rOcc = rOcc, lOcc
+	}
+
+	// Start and end are resolved first, so that a missing anchor is reported before anything is emitted.
+	var start api.Node
+	{
+		txt := fTexts[sIdx]
+		out, complete := "", false
+		if lAnch {
+			sr, _ := findNthInsensitive(txt, lNeedle, lOcc)
+			if sr < 0 {
+				return errorf(ErrNotFound, "Start anchor %q (occurrence %d) not found in %s.", lNeedle, lOcc, fURNs[sIdx])
+			}
+			out, complete = sliceFromRunes([]rune(txt), sr)
+		} else {
+			out, complete = applyTextFilters(opts, txt)
+		}
+		start = api.Node{
+			URN:      []string{fURNs[sIdx]},
+			Text:     []string{out},
+			Sequence: seq[sIdx],
+			Complete: complete,
+		}
+		attachNeighbors(&start, fURNs, sIdx)
+	}
+
+	var end *api.Node
+	if eIdx >= 0 && (rAnch || eIdx != sIdx) {
+		txt := fTexts[eIdx]
+		out, complete := "", false
+		if rAnch {
+			erS, erE := findNthInsensitive(txt, rNeedle, rOcc)
+			if erS < 0 {
+				return errorf(ErrNotFound, "End anchor %q (occurrence %d) not found in %s.", rNeedle, rOcc, fURNs[eIdx])
+			}
+			out, complete = sliceUntilRunes([]rune(txt), erE)
+		} else {
+			out, complete = applyTextFilters(opts, txt)
+		}
+		end = &api.Node{
+			URN:      []string{fURNs[eIdx]},
+			Text:     []string{out},
+			Sequence: seq[eIdx],
+			Complete: complete,
+		}
+		attachNeighbors(end, fURNs, eIdx)
+	}
+
+	if err := emit(start); err != nil {
+		return err
+	}
+	for i := sIdx + 1; i < eIdx; i++ {
+		out, complete := applyTextFilters(opts, fTexts[i])
+		n := api.Node{
+			URN:      []string{fURNs[i]},
+			Text:     []string{out},
+			Sequence: seq[i],
+			Complete: complete,
+		}
+		attachNeighbors(&n, fURNs, i)
+		if err := emit(n); err != nil {
+			return err
+		}
+	}
+	if end != nil {
+		return emit(*end)
+	}
+	return nil
+}
+
+func neighboursIDsIn(ids []string, i int) (prev, next string) {
+	if i > 0 {
+		prev = ids[i-1]
+	}
+	if i+1 < len(ids) {
+		next = ids[i+1]
+	}
+	return
+}
+
+func firstPrefixIndex(ids []string, prefix string) int {
+	for i, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+func attachNeighbors(n *api.Node, ids []string, idx int) {
+	prevID, nextID := neighboursIDsIn(ids, idx)
+	if prevID != "" {
+		n.Previous = []string{prevID}
+	}
+	if nextID != "" {
+		n.Next = []string{nextID}
+	}
+}
+
+// ------------- text clipping / anchors (no ellipses) -------------
+
+func applyTextFilters(opts Options, full string) (string, bool) {
+	substr := strings.TrimSpace(opts.Substring)
+	clip := opts.Clip != nil && *opts.Clip
+	context := 40
+	if opts.Context != nil {
+		context = *opts.Context
+	}
+	maxChars := opts.MaxChars
+
+	out := full
+	complete := true
+
+	if substr != "" && clip {
+		out2, ok := clipToSubstring(full, substr, context)
+		out = out2
+		if !ok || out2 != full {
+			complete = false
+		}
+	}
+	if maxChars > 0 {
+		rr := []rune(out)
+		if len(rr) > maxChars {
+			out = string(rr[:maxChars])
+			complete = false
+		}
+	}
+	return out, complete
+}
+
+func clipToSubstring(full, needle string, ctx int) (string, bool) {
+	if needle == "" {
+		return full, true
+	}
+	lowerFull := strings.ToLower(full)
+	lowerNeedle := strings.ToLower(needle)
+	bi := strings.Index(lowerFull, lowerNeedle)
+	if bi < 0 {
+		return full, true
+	}
+	rns := []rune(full)
+	cb := 0
+	startRune := 0
+	for i, r := range rns {
+		cb += len(string(r))
+		if cb > bi {
+			startRune = i
+			break
+		}
+	}
+	endRune := startRune + len([]rune(needle))
+
+	s := startRune - ctx
+	if s < 0 {
+		s = 0
+	}
+	e := endRune + ctx
+	if e > len(rns) {
+		e = len(rns)
+	}
+	out := string(rns[s:e])
+	complete := (s == 0 && e == len(rns))
+	return out, complete
+}
+
+// byte -> rune window around match; returns (text, complete)
+func anchorWindowFromByteOffsets(opts Options, full string, startByte, endByte int) (string, bool) {
+	rns := []rune(full)
+	cb := 0
+	s := 0
+	for i, rr := range rns {
+		cb += len(string(rr))
+		if cb > startByte {
+			s = i
+			break
+		}
+	}
+	cb2 := 0
+	e := s
+	for i := s; i < len(rns); i++ {
+		cb2 += len(string(rns[i]))
+		if cb2 >= (endByte - startByte) {
+			e = i + 1
+			break
+		}
+	}
+	return anchorWindowFromRuneOffsets(opts, rns, s, e)
+}
+
+// builds snippet/full around rune offsets; never adds ellipses
+func anchorWindowFromRuneOffsets(opts Options, rns []rune, startRune, endRune int) (string, bool) {
+	clip := true // default clip for anchors
+	if opts.Clip != nil {
+		clip = *opts.Clip
+	}
+
+	// tail: from match to end of passage
+	if opts.Tail {
+		out := string(rns[startRune:])
+		complete := (startRune == 0)
+		if maxChars := opts.MaxChars; maxChars > 0 {
+			rr := []rune(out)
+			if len(rr) > maxChars {
+				return string(rr[:maxChars]), false
+			}
+		}
+		return out, complete
+	}
+
+	ctx := 0
+	if opts.Context != nil {
+		ctx = *opts.Context
+	}
+	maxChars := opts.MaxChars
+
+	if !clip && ctx == 0 {
+		txt := string(rns)
+		if maxChars > 0 {
+			rr := []rune(txt)
+			if len(rr) > maxChars {
+				return string(rr[:maxChars]), false
+			}
+		}
+		return txt, true
+	}
+
+	s := startRune - ctx
+	if s < 0 {
+		s = 0
+	}
+	e := endRune + ctx
+	if e > len(rns) {
+		e = len(rns)
+	}
+	out := string(rns[s:e])
+	complete := (s == 0 && e == len(rns))
+
+	if maxChars > 0 {
+		rr := []rune(out)
+		if len(rr) > maxChars {
+			return string(rr[:maxChars]), false
+		}
+	}
+	return out, complete
+}
+
+// "urn:...:<ref>@needle[n]" → (base, needle, occ, ok)
+func parseAnchoredURN(u string) (string, string, int, bool) {
+	at := strings.Index(u, "@")
+	if at < 0 {
+		return "", "", 0, false
+	}
+	base := u[:at]
+	rest := u[at+1:]
+	occ := 1
+	needle := rest
+	if lb := strings.LastIndex(rest, "["); lb >= 0 && strings.HasSuffix(rest, "]") {
+		needle = rest[:lb]
+		nStr := rest[lb+1 : len(rest)-1]
+		if n, err := strconv.Atoi(strings.TrimSpace(nStr)); err == nil && n >= 1 {
+			occ = n
+		}
+	}
+	needle = strings.TrimSpace(needle)
+	if needle == "" {
+		return "", "", 0, false
+	}
+	return base, needle, occ, true
+}
+
+// regexAnchored reports whether u is a single passage anchored by a "/.../" regular expression.
+func regexAnchored(u string) bool {
+	_, needle, _, ok := parseAnchoredURN(u)
+	return ok && len(needle) > 1 && strings.HasPrefix(needle, "/") && strings.HasSuffix(needle, "/")
+}
+
+// n-th case-insensitive occurrence → rune start,end
+func findNthInsensitive(haystack, needle string, n int) (int, int) {
+	if n < 1 || needle == "" {
+		return -1, -1
+	}
+	hl := strings.ToLower(haystack)
+	nl := strings.ToLower(needle)
+	bytePos := 0
+	for i := 0; i < n; i++ {
+		idx := strings.Index(hl[bytePos:], nl)
+		if idx < 0 {
+			return -1, -1
+		}
+		bytePos += idx
+		if i < n-1 {
+			bytePos += len(nl)
+		}
+	}
+	runes := []rune(haystack)
+	cb := 0
+	startRune := 0
+	for i, r := range runes {
+		cb += len(string(r))
+		if cb > bytePos {
+			startRune = i
+			break
+		}
+	}
+	endRune := startRune + len([]rune(needle))
+	if endRune > len(runes) {
+		endRune = len(runes)
+	}
+	return startRune, endRune
+}
+
+// range tokens like "1.0@foo[1]" → (ref, needle, occ, anchored)
+func parseRefAnchorToken(tok string) (ref, needle string, occ int, anchored bool) {
+	occ = 1
+	tok = strings.TrimSpace(tok)
+	if tok == "" {
+		return "", "", 1, false
+	}
+	at := strings.Index(tok, "@")
+	if at < 0 {
+		return tok, "", 1, false
+	}
+	ref = strings.TrimSpace(tok[:at])
+	anchored = true
+	rest := strings.TrimSpace(tok[at+1:])
+	if lb := strings.LastIndex(rest, "["); lb >= 0 && strings.HasSuffix(rest, "]") {
+		needle = strings.TrimSpace(rest[:lb])
+		nStr := rest[lb+1 : len(rest)-1]
+		if n, err := strconv.Atoi(strings.TrimSpace(nStr)); err == nil && n >= 1 {
+			occ = n
+		}
+	} else {
+		needle = rest
+	}
+	return ref, needle, occ, anchored
+}
+
+func sliceFromRunes(rns []rune, start int) (string, bool) {
+	if start < 0 {
+		start = 0
+	}
+	if start > len(rns) {
+		start = len(rns)
+	}
+	out := string(rns[start:])
+	complete := (start == 0)
+	return out, complete
+}
+func sliceUntilRunes(rns []rune, end int) (string, bool) {
+	if end < 0 {
+		end = 0
+	}
+	if end > len(rns) {
+		end = len(rns)
+	}
+	out := string(rns[:end])
+	complete := (end == len(rns))
+	return out, complete
+}
+func sliceBetweenRunes(rns []rune, start, end int) (string, bool) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(rns) {
+		end = len(rns)
+	}
+	if start > end {
+		start, end = end, start
+	}
+	out := string(rns[start:end])
+	complete := (start == 0 && end == len(rns))
+	return out, complete
+}
@@ -0,0 +1,159 @@
+package cts
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/GhentCDH/annophis-text-service/api"
+)
+
+const (
+	w1 = "urn:cts:demo:tg.w1.v1:"
+	w2 = "urn:cts:demo:tg.w2.v1:"
+)
+
+func testCorpus(t *testing.T) *Corpus {
+	t.Helper()
+	src := "#!ctsdata\n" +
+		w1 + "1.1#Sing, goddess, the wrath of Achilles\n" +
+		w1 + "1.2#the wrath that brought countless woes\n" +
+		w1 + "1.10#Achilles and Agamemnon\n" +
+		w1 + "2.1#Tell me, Muse, of the man\n" +
+		w2 + "1#Another work on wrath\n"
+	c, err := Read(strings.NewReader(src), ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func nodeURNs(nodes []api.Node) []string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, n.URN[0])
+	}
+	return out
+}
+
+func nodeTexts(nodes []api.Node) []string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, n.Text[0])
+	}
+	return out
+}
+
+func TestResolve(t *testing.T) {
+	c := testCorpus(t)
+	for _, tc := range []struct {
+		urn   string
+		urns  []string
+		texts []string // nil to skip
+	}{
+		{w1 + "1.2", []string{w1 + "1.2"}, []string{"the wrath that brought countless woes"}},
+		{w1 + "1.", []string{w1 + "1.1", w1 + "1.2", w1 + "1.10"}, nil},
+		{w1, []string{w1 + "1.1", w1 + "1.2", w1 + "1.10", w1 + "2.1"}, nil},
+		{w1 + "1.2-2.1", []string{w1 + "1.2", w1 + "1.10", w1 + "2.1"}, nil},
+		{w1 + "2.1-1.10", []string{w1 + "1.10", w1 + "2.1"}, nil},
+		{w1 + "1.1@wrath-1.2@woes", []string{w1 + "1.1", w1 + "1.2"}, []string{"wrath of Achilles", "the wrath that brought countless woes"}},
+		{w1 + "1.1@WRATH", []string{w1 + "1.1"}, []string{"wrath"}},
+		{w1 + "1.2@/c\\w+s/", []string{w1 + "1.2"}, []string{"countless"}},
+		{w1 + "1.1@/a/[2]", []string{w1 + "1.1"}, []string{"A"}},
+		{w1 + "1.2@/t-?h/[3]", []string{w1 + "1.2"}, []string{"th"}},
+	} {
+		nodes, err := c.Resolve(tc.urn, Options{})
+		if err != nil {
+			t.Errorf("Resolve(%s): %v", tc.urn, err)
+			continue
+		}
+		if got := nodeURNs(nodes); !slices.Equal(got, tc.urns) {
+			t.Errorf("Resolve(%s) = %q, want %q", tc.urn, got, tc.urns)
+		}
+		if got := nodeTexts(nodes); tc.texts != nil && !slices.Equal(got, tc.texts) {
+			t.Errorf("Resolve(%s) texts = %q, want %q", tc.urn, got, tc.texts)
+		}
+	}
+}
+
+func TestResolveExactNode(t *testing.T) {
+	nodes, err := testCorpus(t).Resolve(w1+"1.2", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := nodes[0]
+	if n.Sequence != 2 || !n.Complete || !slices.Equal(n.Previous, []string{w1 + "1.1"}) || !slices.Equal(n.Next, []string{w1 + "1.10"}) {
+		t.Errorf("Resolve(1.2) = %+v", n)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	c := testCorpus(t)
+	for _, tc := range []struct {
+		urn  string
+		want error
+	}{
+		{"not-a-urn", ErrInvalid},
+		{w1 + "9", ErrNotFound},
+		{w1 + "1.1@Hector", ErrNotFound},
+		{w1 + "1.1@wrath[2]", ErrNotFound},
+		{w1 + "1.1@/(/", ErrInvalidRegex},
+		{w1 + "1.1@/x/[2]", ErrNotFound},
+		{w1 + "1.1-9.9", ErrNotFound},
+		{"urn:cts:demo:tg.w9.v1:1-2", ErrNotFound},
+	} {
+		var e *Error
+		_, err := c.Resolve(tc.urn, Options{})
+		if !errors.Is(err, tc.want) || !errors.As(err, &e) {
+			t.Errorf("Resolve(%s) = %v, want %v", tc.urn, err, tc.want)
+		}
+	}
+}
+
+func TestNavigate(t *testing.T) {
+	c := testCorpus(t)
+	for _, tc := range []struct {
+		name       string
+		get        func() (api.Node, bool, error)
+		want       string // "" for none
+		prev, next string
+	}{
+		{"First(work)", wrap(c.First, w1), w1 + "1.1", "", w1 + "1.2"},
+		{"First(passage)", wrap(c.First, w1+"2.1"), w1 + "1.1", "", w1 + "1.2"},
+		{"Last(work)", wrap(c.Last, w1), w1 + "2.1", w1 + "1.10", ""},
+		{"Prev(1.10)", func() (api.Node, bool, error) { return c.Prev(w1 + "1.10") }, w1 + "1.2", w1 + "1.1", w1 + "1.10"},
+		{"Next(1.10)", func() (api.Node, bool, error) { return c.Next(w1 + "1.10") }, w1 + "2.1", w1 + "1.10", ""},
+		{"Prev(1.1)", func() (api.Node, bool, error) { return c.Prev(w1 + "1.1") }, "", "", ""},
+		{"Next(2.1)", func() (api.Node, bool, error) { return c.Next(w1 + "2.1") }, "", "", ""},
+		{"Prev(w2 1)", func() (api.Node, bool, error) { return c.Prev(w2 + "1") }, "", "", ""},
+	} {
+		n, ok, err := tc.get()
+		if err != nil || ok != (tc.want != "") {
+			t.Errorf("%s = %+v, %v, %v", tc.name, n, ok, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if n.URN[0] != tc.want || strings.Join(n.Previous, "") != tc.prev || strings.Join(n.Next, "") != tc.next || !n.Complete {
+			t.Errorf("%s = %+v, want %s between %q and %q", tc.name, n, tc.want, tc.prev, tc.next)
+		}
+	}
+
+	for _, urn := range []string{w1 + "1.3", "not-a-urn"} {
+		if _, _, err := c.Next(urn); err == nil {
+			t.Errorf("Next(%s) succeeded", urn)
+		}
+	}
+	if _, err := c.First("urn:cts:demo:tg.w9.v1:"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("First of a missing work = %v, want ErrNotFound", err)
+	}
+}
+
+func wrap(f func(string) (api.Node, error), urn string) func() (api.Node, bool, error) {
+	return func() (api.Node, bool, error) {
+		n, err := f(urn)
+		return n, err == nil, err
+	}
+}
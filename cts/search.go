@@ -0,0 +1,75 @@
+package cts
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/api"
+)
+
+// Match is one search hit. Anchor is an anchored URN for it that Resolve maps back to the same hit;
+// Text is the hit with SearchOptions.Context runes either side.
+type Match = api.SearchHit
+
+// SearchOptions narrow a search; the zero value searches every passage for every hit.
+type SearchOptions struct {
+	Within  string // URN prefix the passages must start with, e.g. a work or a book
+	Context int    // runes kept either side of the hit in Match.Text
+	Limit   int    // stop after this many hits; 0 for no limit
+}
+
+// Search finds pattern in the passage texts the way anchors do: case-insensitively, or as a regular
+// expression when written "/.../". Hits are in source order; overlapping occurrences are not counted.
+func (c *Corpus) Search(pattern string, opts SearchOptions) ([]Match, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, errorf(ErrInvalid, "Empty search pattern.")
+	}
+	var re *regexp.Regexp
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		var err error
+		re, err = regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+		if err != nil {
+			return nil, errorf(ErrInvalidRegex, "Invalid regex pattern.")
+		}
+	}
+	window := Options{Context: &opts.Context}
+
+	matches := []Match{}
+	for i, urn := range c.URNs {
+		if !strings.HasPrefix(urn, opts.Within) {
+			continue
+		}
+		full := c.Texts[i]
+		add := func(occ int, text string) bool {
+			matches = append(matches, Match{
+				URN:      urn,
+				Anchor:   urn + "@" + pattern + "[" + strconv.Itoa(occ) + "]",
+				Text:     text,
+				Sequence: i + 1,
+			})
+			return opts.Limit > 0 && len(matches) >= opts.Limit
+		}
+		if re != nil {
+			for k, m := range re.FindAllStringIndex(full, -1) {
+				text, _ := anchorWindowFromByteOffsets(window, full, m[0], m[1])
+				if add(k+1, text) {
+					return matches, nil
+				}
+			}
+			continue
+		}
+		for occ := 1; ; occ++ {
+			start, end := findNthInsensitive(full, pattern, occ)
+			if start < 0 {
+				break
+			}
+			text, _ := anchorWindowFromRuneOffsets(window, []rune(full), start, end)
+			if add(occ, text) {
+				return matches, nil
+			}
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,64 @@
+package cts
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	c := testCorpus(t)
+	for _, tc := range []struct {
+		pattern string
+		opts    SearchOptions
+		anchors []string
+		texts   []string
+	}{
+		{"wrath", SearchOptions{}, []string{w1 + "1.1@wrath[1]", w1 + "1.2@wrath[1]", w2 + "1@wrath[1]"}, []string{"wrath", "wrath", "wrath"}},
+		{"wrath", SearchOptions{Within: w1}, []string{w1 + "1.1@wrath[1]", w1 + "1.2@wrath[1]"}, nil},
+		{"wrath", SearchOptions{Limit: 1}, []string{w1 + "1.1@wrath[1]"}, nil},
+		{"/ach\\w+/", SearchOptions{}, []string{w1 + "1.1@/ach\\w+/[1]", w1 + "1.10@/ach\\w+/[1]"}, []string{"Achilles", "Achilles"}},
+		{"/ m/", SearchOptions{Context: 3}, []string{w1 + "2.1@/ m/[1]", w1 + "2.1@/ m/[2]", w1 + "2.1@/ m/[3]"}, []string{"ell me, ", "me, Muse", "the man"}},
+		{"Hector", SearchOptions{}, nil, nil},
+	} {
+		hits, err := c.Search(tc.pattern, tc.opts)
+		if err != nil {
+			t.Errorf("Search(%q): %v", tc.pattern, err)
+			continue
+		}
+		if len(hits) != len(tc.anchors) {
+			t.Errorf("Search(%q, %+v) = %+v, want anchors %q", tc.pattern, tc.opts, hits, tc.anchors)
+			continue
+		}
+		for i, h := range hits {
+			if h.Anchor != tc.anchors[i] || tc.texts != nil && h.Text != tc.texts[i] {
+				t.Errorf("Search(%q) hit %d = %+v, want %s with %q", tc.pattern, i, h, tc.anchors[i], tc.texts)
+			}
+		}
+	}
+}
+
+func TestSearchAnchorsResolveToHits(t *testing.T) {
+	c := testCorpus(t)
+	for _, pattern := range []string{"wrath", "A", "/\\bo\\w/", "/[a-z]+ [a-z]+/", "/a@?/"} {
+		hits, err := c.Search(pattern, SearchOptions{})
+		if err != nil || len(hits) == 0 {
+			t.Fatalf("Search(%q) = %v, %v", pattern, hits, err)
+		}
+		for _, h := range hits {
+			nodes, err := c.Resolve(h.Anchor, Options{})
+			if err != nil || len(nodes) != 1 || nodes[0].URN[0] != h.URN || nodes[0].Text[0] != h.Text || nodes[0].Sequence != h.Sequence {
+				t.Errorf("Resolve(%s) = %+v, %v, want hit %+v", h.Anchor, nodes, err, h)
+			}
+		}
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	c := testCorpus(t)
+	if _, err := c.Search("  ", SearchOptions{}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("empty pattern: %v, want ErrInvalid", err)
+	}
+	if _, err := c.Search("/(/", SearchOptions{}); !errors.Is(err, ErrInvalidRegex) {
+		t.Errorf("bad regex: %v, want ErrInvalidRegex", err)
+	}
+}
@@ -6,4 +6,7 @@ require (
 	github.com/ThomasK81/gocite v0.0.0-20200703112544-785f5b9bd278
 	github.com/go-chi/chi/v5 v5.2.3
 	github.com/go-chi/cors v1.2.2
+	github.com/graph-gophers/graphql-go v1.9.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/text v0.30.0
 )
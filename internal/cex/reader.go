@@ -0,0 +1,282 @@
+// Package cex reads CEX sources line by line: it decodes them to UTF-8, tracks the current block and
+// learns the field delimiter. The corpus parser, the validator and the write path share it.
+package cex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Options are the per-source reader settings; empty fields mean UTF-8 and a declared or detected delimiter.
+type Options struct {
+	Delimiter string // a single character, or "tab", "pipe", "hash"
+	Encoding  string // utf-8 (default), latin1, windows-1252; a UTF-16 BOM overrides it
+}
+
+// Row is one content line of a CEX block (blank lines, comments and block headers are skipped).
+type Row struct {
+	Num     int    // 1-based line number in the source, or in its archive member
+	Section string // block name without "#!", lower-case
+	First   bool   // first content line of its block
+	Text    string
+	Member  string // archive member the row is from ("" outside archives)
+}
+
+// LineError is a problem with one line: fatal for the parser, a diagnostic for the validator.
+type LineError struct {
+	Line, Col int
+	Code, Msg string
+	Member    string
+}
+
+func (e *LineError) Error() string {
+	if e.Member != "" {
+		return fmt.Sprintf("%s: line %d: %s", e.Member, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// MemberStart is the line an archive reader puts before each member it concatenates, name
+// included. The Reader starts over at it: line numbers count from the member's first line and the
+// delimiter is declared or detected again, so members need not share one.
+func MemberStart(name string) string { return "\n" + memberMark + name + "\n" }
+
+const memberMark = "\x1e" // ASCII record separator
+
+// Reader reads a CEX source line by line, decoding it to UTF-8 and tracking the current block.
+// The field delimiter is taken from Options, else from a "delimiter" property in
+// #!cexversion or #!citelibrary, else detected from the first #!ctsdata or #!ctscatalog row.
+type Reader struct {
+	br      *bufio.Reader
+	dec     *encoding.Decoder // nil for UTF-8
+	sep     rune              // 0 until configured, declared or detected
+	optSep  rune              // the configured delimiter, kept for each archive member
+	member  string
+	num     int
+	section string
+	first   bool
+	seen    map[string]bool // block names encountered
+}
+
+// NewReader returns a reader for r; a UTF-8 or UTF-16 byte order mark is consumed.
+func NewReader(r io.Reader, opts Options) (*Reader, error) {
+	cr := &Reader{seen: map[string]bool{}}
+	switch enc := strings.ToLower(strings.TrimSpace(opts.Encoding)); enc {
+	case "", "utf-8", "utf8":
+	case "latin1", "latin-1", "iso-8859-1":
+		cr.dec = charmap.ISO8859_1.NewDecoder()
+	case "windows-1252", "cp1252":
+		cr.dec = charmap.Windows1252.NewDecoder()
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", opts.Encoding)
+	}
+	if opts.Delimiter != "" {
+		sep, err := parseDelimiter(opts.Delimiter)
+		if err != nil {
+			return nil, err
+		}
+		cr.sep, cr.optSep = sep, sep
+	}
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	bom, _ := br.Peek(3)
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}):
+		br.Discard(3)
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}):
+		br.Discard(2)
+		br = bufio.NewReaderSize(&utf16Reader{r: br, order: binary.LittleEndian}, 64<<10)
+		cr.dec = nil
+	case bytes.HasPrefix(bom, []byte{0xFE, 0xFF}):
+		br.Discard(2)
+		br = bufio.NewReaderSize(&utf16Reader{r: br, order: binary.BigEndian}, 64<<10)
+		cr.dec = nil
+	}
+	cr.br = br
+	return cr, nil
+}
+
+// Next returns the next content row. A *LineError comes with a usable row (invalid bytes replaced),
+// so callers may report it and carry on.
+func (cr *Reader) Next() (Row, error) {
+	for {
+		raw, err := cr.br.ReadString('\n')
+		if raw == "" && err != nil {
+			return Row{}, err
+		}
+		cr.num++
+		raw = strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r")
+		if name, ok := strings.CutPrefix(raw, memberMark); ok {
+			cr.member, cr.num, cr.sep = name, 0, cr.optSep
+			cr.section, cr.first = "", false
+			continue
+		}
+
+		var lineErr error
+		raw, lineErr = cr.decode(raw)
+
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "//") {
+			if lineErr != nil {
+				return Row{Num: cr.num, Section: cr.section, Member: cr.member}, lineErr
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#!") {
+			cr.section = strings.ToLower(strings.TrimSpace(trimmed[2:]))
+			cr.seen[cr.section] = true
+			cr.first = true
+			if lineErr != nil {
+				return Row{Num: cr.num, Section: cr.section, Member: cr.member}, lineErr
+			}
+			continue
+		}
+
+		row := Row{Num: cr.num, Section: cr.section, First: cr.first, Text: raw, Member: cr.member}
+		cr.first = false
+		if derr := cr.delimiter(row); derr != nil && lineErr == nil {
+			lineErr = derr
+		}
+		return row, lineErr
+	}
+}
+
+func (cr *Reader) decode(raw string) (string, error) {
+	if cr.dec != nil {
+		return cr.dec.String(raw)
+	}
+	if utf8.ValidString(raw) {
+		return raw, nil
+	}
+	bad := 0
+	for bad < len(raw) {
+		r, size := utf8.DecodeRuneInString(raw[bad:])
+		if r == utf8.RuneError && size <= 1 {
+			break
+		}
+		bad += size
+	}
+	return strings.ToValidUTF8(raw, "�"), &LineError{
+		Line: cr.num, Col: utf8.RuneCountInString(raw[:bad]) + 1, Code: "encoding", Member: cr.member,
+		Msg: fmt.Sprintf("byte 0x%02X is not valid UTF-8; set the corpus encoding (latin1, windows-1252) to transcode", raw[bad]),
+	}
+}
+
+// delimiter learns the separator from a declaration or from the first data/catalog row.
+func (cr *Reader) delimiter(row Row) error {
+	if cr.sep != 0 {
+		return nil
+	}
+	switch row.Section {
+	case "cexversion", "citelibrary":
+		line := strings.Trim(row.Text, " ")
+		if len(line) <= len("delimiter") || !strings.EqualFold(line[:len("delimiter")], "delimiter") {
+			return nil
+		}
+		// "delimiter" <sep> <value>, e.g. "delimiter#|" or "delimiter|tab"
+		rest := line[len("delimiter"):]
+		_, size := utf8.DecodeRuneInString(rest)
+		sep, err := parseDelimiter(rest[size:])
+		if err != nil {
+			return &LineError{Line: row.Num, Col: 1, Code: "delimiter", Msg: err.Error(), Member: row.Member}
+		}
+		cr.sep = sep
+	case "ctsdata", "ctscatalog":
+		if strings.HasPrefix(row.Text, "urn") {
+			if i := strings.IndexAny(row.Text, "#|\t"); i > 0 {
+				cr.sep, _ = utf8.DecodeRuneInString(row.Text[i:])
+			}
+		}
+	}
+	return nil
+}
+
+// Split splits a row into fields ('#' until a delimiter is known).
+func (cr *Reader) Split(text string) []string {
+	sep := cr.sep
+	if sep == 0 {
+		sep = '#'
+	}
+	return strings.Split(text, string(sep))
+}
+
+// SplitN splits text on the delimiter into at most n fields, the last holding the rest.
+func (cr *Reader) SplitN(text string, n int) []string {
+	sep := cr.sep
+	if sep == 0 {
+		sep = '#'
+	}
+	return strings.SplitN(text, string(sep), n)
+}
+
+// Sep is the field delimiter, or 0 while it is still unknown.
+func (cr *Reader) Sep() rune { return cr.sep }
+
+// Seen returns the block names encountered so far. The map is live: it grows as reading goes on.
+func (cr *Reader) Seen() map[string]bool { return cr.seen }
+
+func parseDelimiter(v string) (rune, error) {
+	switch strings.ToLower(v) {
+	case "tab", `\t`, "\t":
+		return '\t', nil
+	case "pipe":
+		return '|', nil
+	case "hash":
+		return '#', nil
+	}
+	v = strings.TrimSpace(v)
+	if r, size := utf8.DecodeRuneInString(v); size > 0 && size == len(v) && r != '\n' && r != '\r' {
+		return r, nil
+	}
+	return 0, fmt.Errorf("invalid delimiter %q", v)
+}
+
+// ---- encodings ----
+
+// utf16Reader transcodes a UTF-16 stream (BOM already consumed) to UTF-8.
+type utf16Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	buf   []byte // transcoded bytes not yet returned
+	odd   []byte // a trailing half code unit or an unpaired high surrogate
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.buf) == 0 {
+		in := make([]byte, 32<<10)
+		n, err := u.r.Read(in)
+		data := append(u.odd, in[:n]...)
+		u.odd = nil
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = u.order.Uint16(data[2*i:])
+		}
+		if len(data)%2 == 1 {
+			u.odd = data[len(data)-1:]
+		}
+		if k := len(units); k > 0 && err == nil && utf16.IsSurrogate(rune(units[k-1])) && units[k-1] < 0xDC00 {
+			// keep a high surrogate for the next read
+			u.odd = append(data[2*(k-1):2*k:2*k], u.odd...)
+			units = units[:k-1]
+		}
+		u.buf = []byte(string(utf16.Decode(units)))
+		if err != nil {
+			if len(u.buf) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
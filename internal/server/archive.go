@@ -0,0 +1,257 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/internal/cex"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed sources are recognised by their magic bytes, whatever their name; archives are
+// addressed as "<archive>#<member>[,<member>...]" where members may be path.Match patterns.
+// Without a member list every *.cex member is read, in archive order.
+
+var archiveExts = []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.zst", ".tar.zstd"}
+
+// splitArchiveSource separates "bundle.zip#a.cex,b.cex" into the archive and its member patterns.
+func splitArchiveSource(source string) (container, members string) {
+	if i := strings.LastIndex(source, "#"); i > 0 && isArchiveName(source[:i]) {
+		return source[:i], source[i+1:]
+	}
+	return source, ""
+}
+
+func isArchiveName(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCEXFileName reports whether name addresses a single CEX source rather than a directory base.
+func isCEXFileName(name string) bool {
+	container, _ := splitArchiveSource(name)
+	lower := strings.ToLower(container)
+	for _, ext := range []string{".cex", ".cex.gz", ".cex.zst", ".cex.zstd"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return isArchiveName(container)
+}
+
+// unwrapSource peels compression and archive layers off rc until plain CEX text remains.
+func unwrapSource(rc io.ReadCloser, name, members string) (io.ReadCloser, error) {
+	for {
+		br := bufio.NewReader(rc)
+		magic, _ := br.Peek(262)
+		switch {
+		case bytes.HasPrefix(magic, []byte{0x1F, 0x8B}):
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				rc.Close()
+				return nil, fmt.Errorf("%s: gzip: %w", name, err)
+			}
+			rc = &layeredReader{Reader: zr, closers: []io.Closer{zr, rc}}
+		case bytes.HasPrefix(magic, []byte{0x28, 0xB5, 0x2F, 0xFD}):
+			zr, err := zstd.NewReader(br)
+			if err != nil {
+				rc.Close()
+				return nil, fmt.Errorf("%s: zstd: %w", name, err)
+			}
+			rc = &layeredReader{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), rc}}
+		case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+			return openZipMembers(rc, br, name, members)
+		case len(magic) >= 262 && string(magic[257:262]) == "ustar":
+			return openTarMembers(&layeredReader{Reader: br, closers: []io.Closer{rc}}, name, members)
+		default:
+			if members != "" {
+				rc.Close()
+				return nil, fmt.Errorf("%s is not a zip or tar archive", name)
+			}
+			// a UTF-8 BOM would land mid-stream once members are concatenated
+			if bytes.HasPrefix(magic, []byte{0xEF, 0xBB, 0xBF}) {
+				br.Discard(3)
+			}
+			return &layeredReader{Reader: br, closers: []io.Closer{rc}}, nil
+		}
+	}
+}
+
+// layeredReader reads from the outermost layer and closes every layer.
+type layeredReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (l *layeredReader) Close() error {
+	var errs []error
+	for _, c := range l.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func memberMatches(name, members string) bool {
+	if members == "" {
+		return strings.HasSuffix(strings.ToLower(name), ".cex")
+	}
+	for _, pat := range strings.Split(members, ",") {
+		pat = strings.TrimSpace(pat)
+		if pat == name {
+			return true
+		}
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// openZipMembers needs random access: local files are used in place, anything else is spooled to a temp file.
+func openZipMembers(rc io.ReadCloser, br *bufio.Reader, name, members string) (io.ReadCloser, error) {
+	f, ok := rc.(*os.File)
+	cleanup := func() { rc.Close() }
+	if !ok {
+		tmp, err := os.CreateTemp("", "annophis-*.zip")
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		_, err = io.Copy(tmp, br)
+		rc.Close()
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		f = tmp
+		cleanup = func() { tmp.Close(); os.Remove(tmp.Name()) }
+	}
+	st, err := f.Stat()
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, st.Size())
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("%s: zip: %w", name, err)
+	}
+
+	var files []*zip.File
+	for _, zf := range zr.File {
+		if !zf.FileInfo().IsDir() && memberMatches(zf.Name, members) {
+			files = append(files, zf)
+		}
+	}
+	if len(files) == 0 {
+		cleanup()
+		return nil, fmt.Errorf("%s: no member matching %q", name, memberPatterns(members))
+	}
+	next := func() (string, io.ReadCloser, error) {
+		if len(files) == 0 {
+			return "", nil, io.EOF
+		}
+		zf := files[0]
+		files = files[1:]
+		m, err := zf.Open()
+		if err != nil {
+			return "", nil, fmt.Errorf("%s#%s: %w", name, zf.Name, err)
+		}
+		rc, err := unwrapSource(m, name+"#"+zf.Name, "")
+		return name + "#" + zf.Name, rc, err
+	}
+	return &memberReader{next: next, close: cleanup}, nil
+}
+
+// openTarMembers streams the matching members of a tar archive, in archive order.
+func openTarMembers(rc io.ReadCloser, name, members string) (io.ReadCloser, error) {
+	tr := tar.NewReader(rc)
+	found := false
+	next := func() (string, io.ReadCloser, error) {
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				if !found {
+					return "", nil, fmt.Errorf("%s: no member matching %q", name, memberPatterns(members))
+				}
+				return "", nil, io.EOF
+			}
+			if err != nil {
+				return "", nil, fmt.Errorf("%s: tar: %w", name, err)
+			}
+			if hdr.Typeflag != tar.TypeReg || !memberMatches(hdr.Name, members) {
+				continue
+			}
+			found = true
+			rc, err := unwrapSource(io.NopCloser(tr), name+"#"+hdr.Name, "")
+			return name + "#" + hdr.Name, rc, err
+		}
+	}
+	return &memberReader{next: next, close: func() { rc.Close() }}, nil
+}
+
+func memberPatterns(members string) string {
+	if members == "" {
+		return "*.cex"
+	}
+	return members
+}
+
+// memberReader concatenates archive members, each introduced by a cex.MemberStart line so that the
+// CEX reader numbers its lines and detects its delimiter afresh.
+type memberReader struct {
+	next   func() (string, io.ReadCloser, error)
+	cur    io.ReadCloser
+	header string // the rest of the current member's start line, read before the member
+	close  func()
+}
+
+func (m *memberReader) Read(p []byte) (int, error) {
+	for {
+		if m.cur == nil {
+			name, cur, err := m.next()
+			if err != nil {
+				return 0, err
+			}
+			m.cur, m.header = cur, cex.MemberStart(name)
+		}
+		if m.header != "" {
+			n := copy(p, m.header)
+			m.header = m.header[n:]
+			return n, nil
+		}
+		n, err := m.cur.Read(p)
+		if errors.Is(err, io.EOF) {
+			m.cur.Close()
+			m.cur = nil
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (m *memberReader) Close() error {
+	if m.cur != nil {
+		m.cur.Close()
+	}
+	m.close()
+	return nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archive members: a and b use different delimiters; notes.txt is not CEX
+var archiveMembers = []struct{ name, body string }{
+	{"cex/a.cex", "#!ctsdata\nurn:cts:demo:g.a.v:1#alpha # one\n"},
+	{"cex/b.cex", "#!cexversion\n3.0\ndelimiter#|\n\n#!ctsdata\nurn:cts:demo:g.b.v:1|beta # two\n"},
+	{"notes.txt", "not CEX"},
+}
+
+func writeArchives(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for _, m := range archiveMembers {
+		f, _ := zw.Create(m.name)
+		f.Write([]byte(m.body))
+	}
+	zw.Close()
+	write("bundle.zip", zbuf.Bytes())
+
+	var tbuf bytes.Buffer
+	tw := tar.NewWriter(&tbuf)
+	for _, m := range archiveMembers {
+		tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0o644, Size: int64(len(m.body)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(m.body))
+	}
+	tw.Close()
+	write("bundle.tar", tbuf.Bytes())
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(tbuf.Bytes())
+	gw.Close()
+	write("bundle.tar.gz", gz.Bytes())
+
+	gz.Reset()
+	gw = gzip.NewWriter(&gz)
+	gw.Write([]byte(archiveMembers[0].body))
+	gw.Close()
+	write("a.cex.gz", gz.Bytes())
+
+	var zs bytes.Buffer
+	zsw, _ := zstd.NewWriter(&zs)
+	zsw.Write([]byte(archiveMembers[1].body))
+	zsw.Close()
+	write("b.cex.zst", zs.Bytes())
+	return dir
+}
+
+func TestArchiveSources(t *testing.T) {
+	dir := writeArchives(t)
+	s := &Server{}
+	a, b := "urn:cts:demo:g.a.v:1", "urn:cts:demo:g.b.v:1"
+	for _, tc := range []struct {
+		source string
+		want   []string // URNs, in order
+	}{
+		{"a.cex.gz", []string{a}},
+		{"b.cex.zst", []string{b}},
+		{"bundle.zip", []string{a, b}},
+		{"bundle.zip#cex/b.cex", []string{b}},
+		{"bundle.zip#cex/b.cex,cex/a.cex", []string{a, b}}, // archive order
+		{"bundle.tar#cex/*.cex", []string{a, b}},
+		{"bundle.tar#cex/a.cex", []string{a}},
+		{"bundle.tar.gz", []string{a, b}},
+		{"bundle.tar.gz#cex/b.cex", []string{b}},
+	} {
+		rc, err := s.openSource(context.Background(), filepath.Join(dir, tc.source))
+		if err != nil {
+			t.Errorf("%s: %v", tc.source, err)
+			continue
+		}
+		c, err := readCorpus(rc, CorpusConfig{})
+		rc.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tc.source, err)
+			continue
+		}
+		if !slices.Equal(c.URNs, tc.want) {
+			t.Errorf("%s: URNs %v, want %v", tc.source, c.URNs, tc.want)
+		}
+		for i, u := range c.URNs {
+			want := map[string]string{a: "alpha # one", b: "beta # two"}[u]
+			if c.Texts[i] != want {
+				t.Errorf("%s: %s text %q, want %q", tc.source, u, c.Texts[i], want)
+			}
+		}
+	}
+
+	for _, source := range []string{"bundle.zip#missing.cex", "bundle.tar#*.xml", "a.cex.gz#a.cex"} {
+		if rc, err := s.openSource(context.Background(), filepath.Join(dir, source)); err == nil {
+			_, err = io.ReadAll(rc)
+			rc.Close()
+			if err == nil {
+				t.Errorf("%s: no error", source)
+			}
+		}
+	}
+}
+
+func TestValidateArchiveMembers(t *testing.T) {
+	dir := writeArchives(t)
+	s := &Server{}
+	diags, err := s.validateSource(context.Background(), filepath.Join(dir, "bundle.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each member is numbered from its own first line; only the missing catalog is wrong
+	for _, d := range diags {
+		if d.Code != "missing-section" && d.Code != "field-count" {
+			t.Errorf("unexpected %+v", d)
+		}
+		if d.Code == "field-count" && (!strings.HasSuffix(d.Source, "#cex/a.cex") || d.Line != 2) {
+			t.Errorf("field-count at %s:%d, want line 2 of cex/a.cex", d.Source, d.Line)
+		}
+	}
+}
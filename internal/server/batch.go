@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/GhentCDH/annophis-text-service/cts"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxBatchItems = 1000
+	maxBatchBytes = 4 << 20
+)
+
+// handleBatch resolves many URNs in one request: POST /texts/batch with a JSON array of URNs or
+// {"urn": ..., "substring": ..., "context": ...} objects. All items are resolved against the same
+// corpus version. Each gets its own NodeResponse, in order and with its own status; the batch as a
+// whole only fails when the body can't be read or the corpus can't be loaded.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	source := pickSourceFromReq(s.cfg, chi.URLParam(r, "CEX"), r.URL.Query())
+	svc := "/texts/batch"
+
+	var items []BatchItem
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes)).Decode(&items); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, codeInvalidBody, BatchResponse{
+			Status: "Exception", Service: svc, Results: []NodeResponse{},
+			Message: "Body must be a JSON array of URNs or {\"urn\": ...} objects: " + err.Error(),
+		})
+		return
+	}
+	if len(items) > maxBatchItems {
+		s.writeError(w, r, http.StatusBadRequest, "", BatchResponse{
+			Status: "Exception", Service: svc, Results: []NodeResponse{},
+			Message: fmt.Sprintf("At most %d URNs per batch; got %d.", maxBatchItems, len(items)),
+		})
+		return
+	}
+
+	allURNs, allTexts, err := s.parseCTSData(ctx, source)
+	if err != nil {
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, BatchResponse{
+			Status: "Exception", Service: svc, Results: []NodeResponse{}, Message: msg,
+		})
+		return
+	}
+	version := s.servedVersion(ctx, source)
+
+	c := cts.Corpus{URNs: allURNs, Texts: allTexts}
+	results := make([]NodeResponse, len(items))
+	for i, it := range items {
+		res := NodeResponse{RequestUrn: []string{it.URN}, Status: "Success", Service: "/texts", Version: version}
+		nodes, err := c.Resolve(it.URN, cts.Options{
+			Substring: it.Substring, Clip: it.Clip, Context: it.Context, MaxChars: it.MaxChars, Tail: it.Tail,
+		})
+		if err != nil {
+			_, code := resolveFailure(err)
+			res.Status, res.Message, res.Code, res.Version = "Exception", err.Error(), code, ""
+		}
+		res.Nodes = nodes
+		results[i] = res
+	}
+	writeJSON(w, http.StatusOK, BatchResponse{Status: "Success", Service: svc, Results: results, Version: version})
+}
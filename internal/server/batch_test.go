@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postBatch(t *testing.T, target, body string, out any) int {
+	t.Helper()
+	_, h := newTestServer(t)
+	req := httptest.NewRequest("POST", target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("POST %s: %v: %s", target, err, truncate(rec.Body.String()))
+	}
+	return rec.Code
+}
+
+func TestBatchResolvesItemsIndependently(t *testing.T) {
+	body := `["` + hdt + `1.1@Persian[1]",
+		{"urn": "` + hdt + `1.2", "substring": "Io", "clip": true, "context": 3},
+		"` + hdt + `9.9",
+		"` + hdt + `1.1@/(/",
+		"` + hdt + `1.1@[1]",
+		"not-a-urn",
+		{"urn": "` + iliad + `1.1", "maxChars": 5}]`
+	var res BatchResponse
+	if code := postBatch(t, "/texts/batch", body, &res); code != 200 || res.Status != "Success" {
+		t.Fatalf("batch: %d %+v", code, res)
+	}
+	want := []struct {
+		status, code, text string
+	}{
+		{"Success", "", "Persian"},
+		{"Success", "", "So Io wa"},
+		{"Exception", codeNotFound, ""},
+		{"Exception", codeInvalidRegex, ""},
+		{"Exception", codeInvalidAnchor, ""},
+		{"Exception", codeInvalidURN, ""},
+		{"Success", "", "μῆνιν"},
+	}
+	if len(res.Results) != len(want) || res.Version == "" {
+		t.Fatalf("batch returned %d results with version %q, want %d", len(res.Results), res.Version, len(want))
+	}
+	for i, w := range want {
+		got := res.Results[i]
+		if got.Status != w.status || got.Code != w.code {
+			t.Errorf("item %d: %s %q (%s), want %s %q", i, got.Status, got.Code, got.Message, w.status, w.code)
+			continue
+		}
+		if w.status == "Exception" {
+			if got.Message == "" || len(got.Nodes) != 0 || got.Version != "" {
+				t.Errorf("item %d: %+v, want a message and no nodes or version", i, got)
+			}
+			continue
+		}
+		if len(got.Nodes) != 1 || got.Nodes[0].Text[0] != w.text || got.Version != res.Version {
+			t.Errorf("item %d: %+v, want %q from version %s", i, got, w.text, res.Version)
+		}
+	}
+}
+
+func TestBatchFailures(t *testing.T) {
+	tooMany := "[" + strings.Repeat(`"`+hdt+`1.1",`, maxBatchItems) + `"` + hdt + `1.2"]`
+	for _, c := range []struct {
+		target, body string
+		status       int
+		code         string
+	}{
+		{"/texts/batch", `{"urn": "` + hdt + `1.1"}`, 400, codeInvalidBody},
+		{"/texts/batch", `["` + hdt + `1.1"`, 400, codeInvalidBody},
+		{"/texts/batch", tooMany, 400, codeInvalidParam},
+		{"/nosuchcorpus/texts/batch", `["` + hdt + `1.1"]`, 404, codeCorpusNotFound},
+	} {
+		var p map[string]any
+		if code := postBatch(t, c.target, c.body, &p); code != c.status || p["code"] != c.code {
+			t.Errorf("POST %s %s: %d %v, want %d %s", c.target, truncate(c.body), code, p["code"], c.status, c.code)
+		}
+	}
+}
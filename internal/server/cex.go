@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/internal/cex"
+)
+
+// corpusConfig returns the per-corpus settings for source, falling back to the global defaults.
+// Entries in cfg.Corpora are keyed by the {CEX} name or by the full source.
+func (s *Server) corpusConfig(source string) CorpusConfig {
+	cc, ok := s.cfg.Corpora[source]
+	if !ok {
+		for name, c := range s.cfg.Corpora {
+			if pickSource(s.cfg, name, nil) == source {
+				cc = c
+				break
+			}
+		}
+	}
+	if cc.Delimiter == "" {
+		cc.Delimiter = s.cfg.Delimiter
+	}
+	if cc.Encoding == "" {
+		cc.Encoding = s.cfg.Encoding
+	}
+	if isStoredSource(s.cfg, source) {
+		cc.Encoding = "utf-8" // uploads are stored as UTF-8
+	}
+	return cc
+}
+
+// cexOptions are the line reader settings of a corpus.
+func cexOptions(cc CorpusConfig) cex.Options {
+	return cex.Options{Delimiter: cc.Delimiter, Encoding: cc.Encoding}
+}
+
+// ---- sources ----
+
+var errSourceTooLarge = errors.New("source exceeds max_source_bytes")
+
+// openSource streams an http(s) URL, a file:// URL, a local path or a git: source as plain CEX: gzip and zstd are
+// decompressed and archive members ("bundle.zip#iliad.cex") extracted. max_source_bytes caps both
+// the bytes fetched and the decompressed text.
+func (s *Server) openSource(ctx context.Context, source string) (io.ReadCloser, error) {
+	if name, ok := strings.CutPrefix(source, invalidScheme); ok {
+		return nil, fmt.Errorf("%q: %w", name, errBadCorpusName)
+	}
+	container, members := splitArchiveSource(source)
+	rc, err := s.openRaw(ctx, container)
+	if err != nil {
+		return nil, err
+	}
+	if rc, err = unwrapSource(rc, container, members); err != nil {
+		return nil, err
+	}
+	if s.cfg.MaxSource > 0 {
+		rc = &cappedReader{ReadCloser: rc, left: s.cfg.MaxSource}
+	}
+	return rc, nil
+}
+
+// OpenSource opens a CEX source the way the server does, without a size limit (used by the CLI).
+func OpenSource(ctx context.Context, source string) (io.ReadCloser, error) {
+	s := &Server{sourceClient: http.DefaultClient}
+	return s.openSource(ctx, source)
+}
+
+// openRaw opens source as stored. Local files are returned as *os.File so zip archives can be read in place.
+func (s *Server) openRaw(ctx context.Context, source string) (io.ReadCloser, error) {
+	switch {
+	case isGitSource(source):
+		return s.openGit(ctx, source)
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "annophis-text-service/1.0")
+		resp, err := s.sourceClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %w", source, err)
+		}
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %w (status %d)", source, errNoSource, resp.StatusCode)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: status %d", source, resp.StatusCode)
+		}
+		if max := s.cfg.MaxSource; max > 0 && resp.ContentLength > max {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %w (%d > %d bytes)", source, errSourceTooLarge, resp.ContentLength, max)
+		}
+		if s.cfg.MaxSource > 0 {
+			return &cappedReader{ReadCloser: resp.Body, left: s.cfg.MaxSource}, nil
+		}
+		return resp.Body, nil
+	default:
+		f, err := os.Open(strings.TrimPrefix(source, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		if st, err := f.Stat(); err == nil && s.cfg.MaxSource > 0 && st.Size() > s.cfg.MaxSource {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w (%d > %d bytes)", source, errSourceTooLarge, st.Size(), s.cfg.MaxSource)
+		}
+		return f, nil
+	}
+}
+
+// cappedReader fails once more than left bytes have been read (sources without a known length).
+type cappedReader struct {
+	io.ReadCloser
+	left int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.left -= int64(n)
+	if c.left < 0 {
+		return n, errSourceTooLarge
+	}
+	return n, err
+}
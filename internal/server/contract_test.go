@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// newTestServer serves testdata/demo.cex as the default corpus and, under a directory base, as
+// /demo. Uploads go to a temporary data_dir; IIIF images point at a local info.json stub.
+func newTestServer(t *testing.T) (*Server, http.Handler) {
+	t.Helper()
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"width": 2000, "height": 3000, "profile": "level2"}`))
+	}))
+	t.Cleanup(images.Close)
+
+	s := NewServer(ServerConfig{
+		Source:      "testdata/",
+		TestSource:  "testdata/demo.cex",
+		DataDir:     t.TempDir(),
+		WriteTokens: map[string]string{"tok-test": "Tester"},
+		AdminToken:  "adm-test",
+		IIIF:        IIIFConfig{Images: map[string]string{"urn:cite2:demo:img.v1:": images.URL + "/iiif/3/demo"}},
+	})
+	s.quiet = true
+	h, err := BuildRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, h
+}
+
+type contractCase struct {
+	method, target string
+	body           string
+	token          string
+	contentType    string
+	want           int
+}
+
+const (
+	hdt   = "urn:cts:greekLit:tlg0016.tlg001.eng:"
+	iliad = "urn:cts:greekLit:tlg0012.tlg001.grc:"
+)
+
+// corpusReads are requests to the corpus routes; each is sent at the base and under /demo.
+var corpusReads = []contractCase{
+	{method: "GET", target: "/texts", want: 200},
+	{method: "GET", target: "/texts/catalog", want: 200},
+	{method: "GET", target: "/texts/catalog?format=csv", want: 200},
+	{method: "GET", target: "/texts/catalog?format=jsonld", want: 200},
+	{method: "GET", target: "/texts/catalog?format=bogus", want: 400},
+	{method: "GET", target: "/texts/validate", want: 200},
+	{method: "GET", target: "/texts/first/" + hdt, want: 200},
+	{method: "GET", target: "/texts/last/" + hdt, want: 200},
+	{method: "GET", target: "/texts/previous/" + hdt + "1.2", want: 200},
+	{method: "GET", target: "/texts/next/" + hdt + "1.1", want: 200},
+	{method: "GET", target: "/texts/next/not-a-urn", want: 400},
+	{method: "GET", target: "/texts/urns/" + hdt, want: 200},
+	{method: "GET", target: "/texts/urns/" + hdt + "?limit=2", want: 200},
+	{method: "GET", target: "/texts/urns/" + hdt + "9", want: 404},
+	{method: "GET", target: "/texts/history/" + hdt + "1.1", want: 200},
+	{method: "GET", target: "/texts/export/" + hdt + "1.1," + iliad + "1.2", want: 200},
+	{method: "GET", target: "/texts/export/" + hdt + "1.1?format=epub", want: 200},
+	{method: "GET", target: "/texts/search?q=persian", want: 200},
+	{method: "GET", target: "/texts/search?q=/per(s/", want: 400},
+	{method: "GET", target: "/texts/search?q=the&format=ndjson", want: 200},
+	{method: "GET", target: "/texts/" + hdt + "1.1", want: 200},
+	{method: "GET", target: "/texts/" + hdt + "1.1@Persian[1]", want: 200},
+	{method: "GET", target: "/texts/" + hdt + "1.1@%2FPers%5Bi%5Dan%2F", want: 200},
+	{method: "GET", target: "/texts/" + hdt + "?limit=2", want: 200},
+	{method: "GET", target: "/texts/" + hdt + "1.1?format=tei", want: 200},
+	{method: "GET", target: "/texts/" + hdt + "1.1?format=markdown", want: 200},
+	{method: "GET", target: "/texts/" + hdt + "1.1?format=jsonld", want: 200},
+	{method: "GET", target: "/texts/" + hdt + "9.9", want: 404},
+	{method: "GET", target: "/texts/" + hdt + "1.1@nowhere[1]", want: 404},
+	{method: "GET", target: "/texts/not-a-urn", want: 400},
+	{method: "POST", target: "/texts/batch", body: `["` + hdt + `1.1", {"urn": "` + hdt + `1.2", "substring": "Io"}]`, contentType: "application/json", want: 200},
+	{method: "POST", target: "/texts/batch", body: `{}`, contentType: "application/json", want: 400},
+	{method: "PUT", target: "/texts/" + hdt + "1.1", body: "read-only", token: "tok-test", want: 409},
+	{method: "PATCH", target: "/texts/" + hdt + "1.1", body: "read-only", want: 401},
+	{method: "GET", target: "/iiif/manifest/" + iliad + "1.1", want: 200},
+	{method: "GET", target: "/iiif/images/" + iliad + "1.1", want: 200},
+	{method: "GET", target: "/iiif/images/" + iliad + "1.3", want: 404},
+	{method: "GET", target: "/orca/text/" + hdt + "1.1", want: 200},
+	{method: "GET", target: "/orca/analysis/urn:cite2:demo:syntax.v1:s1.subj", want: 200},
+	{method: "GET", target: "/orca/analysis/urn:cite2:demo:syntax.v1:s1", want: 404},
+	{method: "GET", target: "/graphql?query=" + url.QueryEscape(`{ catalog { urn } }`), want: 200},
+	{method: "POST", target: "/graphql", body: `{"query": "{ search(pattern: \"persian\") { anchor } }"}`, contentType: "application/json", want: 200},
+}
+
+// writeCases run in order against a corpus uploaded as "up" from cex.
+func writeCases(cex string) []contractCase {
+	return []contractCase{
+		{method: "PUT", target: "/corpora/up", body: cex, token: "tok-test", want: 201},
+		{method: "PUT", target: "/corpora/up", body: cex, want: 401},
+		{method: "PUT", target: "/corpora/up", body: "#!ctsdata\nnot a row\n", token: "tok-test", want: 422},
+		{method: "PUT", target: "/up/texts/" + hdt + "1.1", body: `{"text": "Changed.", "reason": "test"}`, contentType: "application/json", token: "tok-test", want: 200},
+		{method: "PATCH", target: "/up/texts/" + hdt + "1.2", body: "Also changed.", contentType: "text/plain", token: "tok-test", want: 200},
+		{method: "GET", target: "/up/texts/history/" + hdt, want: 200},
+		{method: "POST", target: "/admin/revert/up/7", token: "adm-test", want: 200},
+		{method: "POST", target: "/admin/revert/up/999", token: "adm-test", want: 404},
+		{method: "POST", target: "/admin/git/ref?ref=main", token: "adm-test", want: 404},
+		{method: "DELETE", target: "/corpora/up", token: "tok-test", want: 200},
+		{method: "DELETE", target: "/corpora/up", token: "tok-test", want: 404},
+	}
+}
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	s, h := newTestServer(t)
+	cex, err := os.ReadFile("testdata/demo.cex")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []contractCase{
+		{method: "GET", target: "/", want: 200},
+		{method: "GET", target: "/cite", want: 200},
+		{method: "GET", target: "/texts/version", want: 200},
+		{method: "GET", target: "/openapi.json", want: 200},
+		{method: "GET", target: "/healthz", want: 200},
+		{method: "GET", target: "/nosuchcorpus/texts/catalog", want: 404},
+		{method: "GET", target: "/texts/catalog?cex=../testdata/demo", want: 400},
+	}
+	for _, prefix := range []string{"", "/demo"} {
+		for _, c := range corpusReads {
+			c.target = prefix + c.target
+			cases = append(cases, c)
+		}
+	}
+	cases = append(cases, writeCases(string(cex))...)
+
+	mux := h.(*chi.Mux)
+	exercised := map[string]bool{}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		name := c.method + " " + c.target
+		if rec.Code != c.want {
+			t.Errorf("%s: status %d, want %d: %s", name, rec.Code, c.want, truncate(rec.Body.String()))
+		}
+		pattern := mux.Find(chi.NewRouteContext(), c.method, req.URL.EscapedPath())
+		if pattern == "" {
+			t.Errorf("%s: not routed", name)
+			continue
+		}
+		exercised[c.method+" "+pattern] = true
+
+		buffered := &bufferedResponse{header: rec.Header(), status: rec.Code}
+		buffered.body.Write(rec.Body.Bytes())
+		for _, p := range s.contractProblems(c.method, pattern, buffered) {
+			t.Errorf("%s: %s", name, p)
+		}
+		if rec.Code < 400 {
+			op := s.openAPI["paths"].(map[string]any)[pattern].(map[string]any)[strings.ToLower(c.method)].(map[string]any)
+			if _, ok := op["responses"].(map[string]any)[strconv.Itoa(rec.Code)]; !ok {
+				t.Errorf("%s: status %d is not documented", name, rec.Code)
+			}
+		}
+	}
+
+	var missed []string
+	for path, item := range s.openAPI["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			if key := strings.ToUpper(method) + " " + path; !exercised[key] {
+				missed = append(missed, key)
+			}
+		}
+	}
+	sort.Strings(missed)
+	if len(missed) > 0 {
+		t.Errorf("documented routes not exercised: %s", strings.Join(missed, ", "))
+	}
+}
+
+// getJSON sends GET target to h and decodes the JSON answer into out.
+func getJSON(t *testing.T, h http.Handler, target string, out any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+		t.Fatalf("GET %s: %v: %s", target, err, truncate(rec.Body.String()))
+	}
+	return rec.Code
+}
+
+func truncate(s string) string {
+	if len(s) > 300 {
+		return s[:300] + "..."
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// parseDSE collects DSE triples (passage, image region, surface) from every
+// #!citedata block whose header carries "passage" and "imageroi" columns.
+func (s *Server) parseDSE(ctx context.Context, source string) ([]DSERecord, error) {
+	blocks, err := s.parseCiteData(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	var out []DSERecord
+	for _, b := range blocks {
+		iURN := b.Col("urn")
+		iPassage := b.Col("passage", "text")
+		iImage := b.Col("imageroi", "image")
+		if iPassage < 0 || iImage < 0 {
+			continue
+		}
+		iLabel := b.Col("label")
+		iSurface := b.Col("surface")
+		for _, row := range b.Rows {
+			rec := DSERecord{
+				URN:      field(row, iURN),
+				Label:    field(row, iLabel),
+				Passage:  field(row, iPassage),
+				ImageROI: field(row, iImage),
+				Surface:  field(row, iSurface),
+			}
+			if rec.Passage == "" || rec.ImageROI == "" {
+				continue
+			}
+			out = append(out, rec)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errNoDSE
+	}
+	return out, nil
+}
+
+var errNoDSE = errors.New("no DSE records in #!citedata")
+
+// dseByPassage indexes records by passage URN (without any @subreference), keeping file order.
+func dseByPassage(recs []DSERecord) map[string][]DSERecord {
+	out := make(map[string][]DSERecord, len(recs))
+	for _, rec := range recs {
+		key := rec.Passage
+		if at := strings.Index(key, "@"); at >= 0 {
+			key = key[:at]
+		}
+		out[key] = append(out[key], rec)
+	}
+	return out
+}
+
+func field(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// E-book and print exports of /texts/export: an EPUB 3 with one chapter per top-level citation
+// division (a whole work when it has one level) and a navigation document from the citation
+// hierarchy, and a single HTML document styled for paged media, to be printed or converted to PDF
+// (browsers, WeasyPrint, Paged.js).
+
+// bookChapter is one spine item of an EPUB.
+type bookChapter struct {
+	work  *workNodes
+	first bool // first chapter of its work, which carries the work's title
+	title string
+	nodes []Node
+}
+
+// bookChapters splits every work at its top citation level; consecutive passages of one division
+// form one chapter.
+func bookChapters(works []*workNodes) []bookChapter {
+	var chapters []bookChapter
+	for _, wn := range works {
+		key := "\x00"
+		first := true
+		for _, n := range wn.nodes {
+			ref := passageRef(n.URN[0])
+			k, title := "", wn.title
+			if len(ref) > 1 {
+				k, title = ref[0], levelHeading(wn.scheme, ref, 0)
+			}
+			if k != key || len(chapters) == 0 {
+				chapters = append(chapters, bookChapter{work: wn, first: first, title: title})
+				key, first = k, false
+			}
+			c := &chapters[len(chapters)-1]
+			c.nodes = append(c.nodes, n)
+		}
+	}
+	return chapters
+}
+
+// bookTitle names the selection: the work title and reference for a single URN, else the work titles.
+func bookTitle(reqURNs []string, works []*workNodes) string {
+	var titles []string
+	for _, wn := range works {
+		titles = append(titles, wn.title)
+	}
+	title := strings.Join(titles, "; ")
+	if len(works) == 1 && len(reqURNs) == 1 {
+		if ref := passageRef(reqURNs[0]); ref != nil {
+			title += " " + strings.Join(ref, ".")
+		}
+	}
+	return title
+}
+
+func bookAuthors(works []*workNodes) []string {
+	var authors []string
+	for _, wn := range works {
+		if wn.author != "" && indexOf(authors, wn.author) < 0 {
+			authors = append(authors, wn.author)
+		}
+	}
+	return authors
+}
+
+// navEntry is one line of a table of contents; depth 0 is the outermost list.
+type navEntry struct {
+	depth int
+	href  string
+	text  string
+}
+
+// writeNavList writes entries as nested <ol>s, never nesting more than one level at a time.
+func writeNavList(b *strings.Builder, entries []navEntry) {
+	depth := -1
+	for _, e := range entries {
+		d := min(e.depth, depth+1)
+		switch {
+		case d > depth:
+			b.WriteString("<ol>\n")
+		case d == depth:
+			b.WriteString("</li>\n")
+		default:
+			for ; depth > d; depth-- {
+				b.WriteString("</li>\n</ol>\n")
+			}
+			b.WriteString("</li>\n")
+		}
+		depth = d
+		b.WriteString(`<li><a href="` + html.EscapeString(e.href) + `">` + html.EscapeString(e.text) + "</a>")
+	}
+	for ; depth >= 0; depth-- {
+		b.WriteString("</li>\n</ol>\n")
+	}
+}
+
+const bookCSS = `body{font-family:Georgia,serif;line-height:1.5}` +
+	`h1,h2,h3,h4,h5,h6{font-family:sans-serif}` +
+	`.byline{font-style:italic}` +
+	`.ref{color:#777;font-family:sans-serif;font-size:.7em;margin-right:.5em}` +
+	`p.l{margin:0}`
+
+// ---- EPUB ----
+
+// renderEPUB packages the works as an EPUB 3.
+func renderEPUB(reqURNs []string, version, lang string, works []*workNodes, modified time.Time) ([]byte, error) {
+	chapters := bookChapters(works)
+	title := bookTitle(reqURNs, works)
+	esc := func(s string) string { return xmlTextEscaper.Replace(xmlClean(s)) }
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// the mimetype must come first and uncompressed
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	mw.Write([]byte("application/epub+zip"))
+	files := []struct{ name, body string }{{"META-INF/container.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`}}
+
+	// package document
+	var opf strings.Builder
+	opf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	opf.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" xml:lang="` + esc(lang) + `">` + "\n")
+	opf.WriteString(`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	opf.WriteString(`<dc:identifier id="uid">` + esc(strings.Join(reqURNs, ",")) + "</dc:identifier>\n")
+	opf.WriteString("<dc:title>" + esc(title) + "</dc:title>\n")
+	for _, a := range bookAuthors(works) {
+		opf.WriteString("<dc:creator>" + esc(a) + "</dc:creator>\n")
+	}
+	opf.WriteString("<dc:language>" + esc(lang) + "</dc:language>\n")
+	for _, wn := range works {
+		opf.WriteString("<dc:source>" + esc(wn.stem) + "</dc:source>\n")
+		if wn.edition != "" {
+			opf.WriteString("<dc:description>" + esc(wn.title+": "+wn.edition) + "</dc:description>\n")
+		}
+	}
+	if version != "" {
+		opf.WriteString("<dc:description>" + esc("Corpus version "+version) + "</dc:description>\n")
+	}
+	opf.WriteString(`<meta property="dcterms:modified">` + modified.UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	opf.WriteString("</metadata>\n<manifest>\n")
+	opf.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	opf.WriteString(`<item id="css" href="style.css" media-type="text/css"/>` + "\n")
+	var spine strings.Builder
+	var nav []navEntry
+	for i, c := range chapters {
+		id := fmt.Sprintf("ch%03d", i+1)
+		href := id + ".xhtml"
+		opf.WriteString(`<item id="` + id + `" href="` + href + `" media-type="application/xhtml+xml"/>` + "\n")
+		spine.WriteString(`<itemref idref="` + id + `"/>` + "\n")
+
+		depth := 0
+		if len(works) > 1 {
+			if c.first {
+				nav = append(nav, navEntry{0, href, c.work.title})
+			}
+			depth = 1
+		}
+		if len(works) == 1 || c.title != c.work.title {
+			nav = append(nav, navEntry{depth, href, c.title})
+		}
+
+		var body strings.Builder
+		if c.first {
+			body.WriteString("<h1>" + html.EscapeString(c.work.title) + "</h1>\n")
+			if by := byline(c.work); by != "" {
+				body.WriteString(`<p class="byline">` + html.EscapeString(by) + "</p>\n")
+			}
+		}
+		headings := htmlPassages(&body, c.work, c.nodes, "", func(urn, label string) string {
+			return `<span class="ref">` + html.EscapeString(label) + "</span>"
+		})
+		for _, h := range headings {
+			if h.level > 0 {
+				nav = append(nav, navEntry{depth + h.level, href + "#" + h.id, h.text})
+			}
+		}
+		files = append(files, struct{ name, body string }{"OEBPS/" + href, xhtmlDocument(lang, c.title, `<section epub:type="chapter">`+"\n"+body.String()+"</section>")})
+	}
+	opf.WriteString("</manifest>\n<spine>\n" + spine.String() + "</spine>\n</package>\n")
+
+	var navDoc strings.Builder
+	navDoc.WriteString(`<nav epub:type="toc" id="toc">` + "\n<h1>Contents</h1>\n")
+	writeNavList(&navDoc, nav)
+	navDoc.WriteString("</nav>")
+
+	files = append(files,
+		struct{ name, body string }{"OEBPS/content.opf", opf.String()},
+		struct{ name, body string }{"OEBPS/nav.xhtml", xhtmlDocument(lang, title, navDoc.String())},
+		struct{ name, body string }{"OEBPS/style.css", bookCSS + "\n"},
+	)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write([]byte(f.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xhtmlDocument wraps body in an EPUB content document.
+func xhtmlDocument(lang, title, body string) string {
+	l := html.EscapeString(lang)
+	return xmlClean(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` + l + `" lang="` + l + `">
+<head>
+<meta charset="utf-8"/>
+<title>` + html.EscapeString(title) + `</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+` + body + `
+</body>
+</html>
+`)
+}
+
+// ---- print ----
+
+const printCSS = `@page{size:A4;margin:2.5cm 2cm;@bottom-center{content:counter(page)}}` +
+	`body{font-family:Georgia,serif;font-size:11pt;line-height:1.5;color:#000}` +
+	`.title-page{break-after:page;text-align:center;padding-top:30%}` +
+	`.title-page h1{font-size:2.2em}.meta{font-family:sans-serif;font-size:.8em;color:#555}` +
+	`nav.toc{break-after:page}nav.toc ol{list-style:none;padding-left:1.2em}` +
+	`nav.toc a{color:inherit;text-decoration:none}nav.toc a::after{content:leader('.') target-counter(attr(href),page)}` +
+	`article{break-before:page}article>h2:not(:first-of-type){break-before:page}h3,h4,h5,h6{break-after:avoid}` +
+	`p{orphans:3;widows:3}p.l{margin:0}` +
+	`.ref{color:#777;font-family:sans-serif;font-size:.7em;margin-right:.5em}.byline{font-style:italic}`
+
+// renderPrintHTML is one HTML document with a title page, a table of contents and every work,
+// with page breaks before each top-level division.
+func renderPrintHTML(reqURNs []string, version, lang string, works []*workNodes, date time.Time) []byte {
+	title := bookTitle(reqURNs, works)
+	var body strings.Builder
+	var toc []navEntry
+	for i, wn := range works {
+		prefix := ""
+		if len(works) > 1 {
+			prefix = fmt.Sprintf("w%d-", i+1)
+		}
+		body.WriteString(`<article id="` + prefix + `work">` + "\n<h1>" + html.EscapeString(wn.title) + "</h1>\n")
+		if by := byline(wn); by != "" {
+			body.WriteString(`<p class="byline">` + html.EscapeString(by) + "</p>\n")
+		}
+		depth := 0
+		if len(works) > 1 {
+			toc = append(toc, navEntry{0, "#" + prefix + "work", wn.title})
+			depth = 1
+		}
+		headings := htmlPassages(&body, wn, wn.nodes, prefix, func(urn, label string) string {
+			return `<span class="ref">` + html.EscapeString(label) + "</span>"
+		})
+		for _, h := range headings {
+			toc = append(toc, navEntry{depth + h.level, "#" + h.id, h.text})
+		}
+		body.WriteString("</article>\n")
+	}
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html lang=\"" + html.EscapeString(lang) + "\">\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString("<title>" + html.EscapeString(title) + "</title>\n<style>" + printCSS + "</style>\n</head>\n<body>\n")
+	b.WriteString(`<section class="title-page">` + "\n<h1>" + html.EscapeString(title) + "</h1>\n")
+	if authors := bookAuthors(works); len(authors) > 0 {
+		b.WriteString(`<p class="byline">` + html.EscapeString(strings.Join(authors, ", ")) + "</p>\n")
+	}
+	b.WriteString(`<p class="meta">` + html.EscapeString(strings.Join(reqURNs, ", ")))
+	if version != "" {
+		b.WriteString("<br>corpus version " + html.EscapeString(version))
+	}
+	b.WriteString("<br>" + date.UTC().Format("2006-01-02") + "</p>\n</section>\n")
+	if len(toc) > 0 {
+		b.WriteString(`<nav class="toc">` + "\n<h2>Contents</h2>\n")
+		writeNavList(&b, toc)
+		b.WriteString("</nav>\n")
+	}
+	b.WriteString(body.String())
+	b.WriteString("</body>\n</html>\n")
+	return []byte(b.String())
+}
+
+// writeBook sends the export as an EPUB (format "epub") or print HTML (format "print"). The document
+// language is the catalog's lang of the exported works, unless ?lang= overrides it.
+func (s *Server) writeBook(w http.ResponseWriter, r *http.Request, format, source string, reqURNs []string, nodes []Node, catalog []CatalogEntry) {
+	version := s.servedVersion(r.Context(), source)
+	works := groupWorks(nodes, catalog)
+	lang := strings.TrimSpace(r.URL.Query().Get("lang"))
+	if lang == "" {
+		lang = bookLang(works)
+	}
+	name := downloadName(chi.URLParam(r, "CEX"), urnNameParts(reqURNs)...)
+	if version != "" {
+		w.Header().Set("X-Corpus-Version", version)
+	}
+	if format == "print" {
+		w.Header().Set("Content-Type", formatHTML.contentType())
+		w.Header().Set("Content-Disposition", `inline; filename="`+name+`.html"`)
+		w.WriteHeader(http.StatusOK)
+		w.Write(renderPrintHTML(reqURNs, version, lang, works, time.Now()))
+		return
+	}
+	body, err := renderEPUB(reqURNs, version, lang, works, time.Now())
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "", NodeResponse{
+			RequestUrn: reqURNs, Status: "Exception", Service: "/texts/export", Message: "Couldn't build EPUB: " + err.Error(),
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.epub"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// bookLang is the language the catalog records for the works, "mul" when they differ and "und" when
+// none is recorded.
+func bookLang(works []*workNodes) string {
+	lang := ""
+	for _, wn := range works {
+		switch {
+		case wn.lang == "" || wn.lang == lang:
+		case lang == "":
+			lang = wn.lang
+		default:
+			return "mul"
+		}
+	}
+	if lang == "" {
+		return "und"
+	}
+	return lang
+}
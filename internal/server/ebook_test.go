@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBookLanguageFromCatalog(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, c := range []struct {
+		target, want string
+	}{
+		{"/texts/export/" + hdt + "1.1?format=print", `<html lang="eng">`},
+		{"/texts/export/" + iliad + "1.1?format=print", `<html lang="grc">`},
+		{"/texts/export/" + hdt + "1.1," + iliad + "1.1?format=print", `<html lang="mul">`},
+		{"/texts/export/" + hdt + "1.1?format=print&lang=en-GB", `<html lang="en-GB">`},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", c.target, nil))
+		if rec.Code != 200 || !strings.Contains(rec.Body.String(), c.want) {
+			t.Errorf("GET %s: %d %s, want %s", c.target, rec.Code, truncate(rec.Body.String()), c.want)
+		}
+	}
+}
+
+func TestBookLang(t *testing.T) {
+	for _, c := range []struct {
+		langs []string
+		want  string
+	}{
+		{nil, "und"},
+		{[]string{""}, "und"},
+		{[]string{"grc", ""}, "grc"},
+		{[]string{"grc", "grc"}, "grc"},
+		{[]string{"grc", "", "lat"}, "mul"},
+	} {
+		var works []*workNodes
+		for _, l := range c.langs {
+			works = append(works, &workNodes{lang: l})
+		}
+		if got := bookLang(works); got != c.want {
+			t.Errorf("bookLang(%q) = %s, want %s", c.langs, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// handleExport returns a self-contained CEX for one or more URNs (passages, prefixes or ranges):
+// GET /texts/export/{URN}[,{URN}...]?format=cex, further URNs may be given as ?urn=. format=epub
+// and format=print give an e-book or a print-ready HTML document of the same selection instead.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cexName := chi.URLParam(r, "CEX")
+	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
+	svc := "/texts/export"
+
+	reqURNs := exportURNs(pathURN(r), r.URL.Query()["urn"])
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "cex", "epub", "print":
+	default:
+		s.writeError(w, r, http.StatusBadRequest, "", NodeResponse{
+			RequestUrn: reqURNs, Status: "Exception", Service: svc, Message: "Unsupported export format " + strconv.Quote(format) + "; use cex, epub or print.",
+		})
+		return
+	}
+
+	allURNs, allTexts, err := s.parseCTSData(ctx, source)
+	if err != nil {
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, NodeResponse{
+			RequestUrn: reqURNs, Status: "Exception", Service: svc, Message: msg,
+		})
+		return
+	}
+	// a missing #!ctscatalog only leaves the export without catalog rows
+	catalog, _ := s.parseCTSCatalog(ctx, source)
+
+	var nodes []Node
+	seen := map[string]bool{}
+	for _, u := range reqURNs {
+		resolved, err := resolvePassage(r, u, allURNs, allTexts)
+		if err != nil {
+			status, code := resolveFailure(err)
+			s.writeError(w, r, status, code, NodeResponse{
+				RequestUrn: reqURNs, Status: "Exception", Service: svc, Message: err.Error(),
+			})
+			return
+		}
+		for _, n := range resolved {
+			if !seen[n.URN[0]] {
+				seen[n.URN[0]] = true
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	if format == "epub" || format == "print" {
+		s.writeBook(w, r, format, source, reqURNs, nodes, catalog)
+		return
+	}
+
+	position := make(map[string]int, len(allURNs))
+	for i, u := range allURNs {
+		position[u] = i
+	}
+	picked := map[int]bool{}
+	for _, n := range nodes {
+		// anchored and clipped nodes export their whole passage
+		if i, ok := position[n.URN[0]]; ok {
+			picked[i] = true
+		}
+	}
+	rows := make([]int, 0, len(picked))
+	for i := range picked {
+		rows = append(rows, i)
+	}
+	sort.Ints(rows)
+
+	works := map[string]bool{}
+	for _, i := range rows {
+		works[workStem(allURNs[i])] = true
+	}
+	var entries []CatalogEntry
+	for _, e := range catalog {
+		if works[e.URN] {
+			entries = append(entries, e)
+		}
+	}
+
+	sep := exportDelimiter(entries, allTexts, rows)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+downloadName(cexName, urnNameParts(reqURNs)...)+`.cex"`)
+	if v := s.servedVersion(ctx, source); v != "" {
+		w.Header().Set("X-Corpus-Version", v)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	bw.WriteString("#!cexversion\n3.0\n\n")
+	if len(entries) > 0 {
+		bw.WriteString("#!ctscatalog\n")
+		bw.WriteString(strings.Join([]string{"urn", "citationScheme", "groupName", "workTitle", "versionLabel", "exemplarLabel", "online", "lang"}, sep) + "\n")
+		for _, e := range entries {
+			bw.WriteString(strings.Join([]string{
+				e.URN, e.CitationScheme, e.GroupName, e.WorkTitle, e.VersionLabel, e.ExemplarLabel, strconv.FormatBool(e.Online), e.Lang,
+			}, sep) + "\n")
+		}
+		bw.WriteString("\n")
+	}
+	bw.WriteString("#!ctsdata\n")
+	for _, i := range rows {
+		bw.WriteString(allURNs[i] + sep + allTexts[i] + "\n")
+	}
+}
+
+// exportURNs splits the comma-separated path URNs and appends any ?urn= values, dropping duplicates.
+// A comma only separates URNs where the next one starts with "urn:", so anchors such as @/a{1,3}/
+// stay whole.
+func exportURNs(path string, extra []string) []string {
+	var split []string
+	for _, part := range strings.Split(path, ",") {
+		if n := len(split); n > 0 && !strings.HasPrefix(strings.TrimSpace(part), "urn:") {
+			split[n-1] += "," + part
+			continue
+		}
+		split = append(split, part)
+	}
+	var out []string
+	for _, u := range append(split, extra...) {
+		if u = strings.TrimSpace(u); u != "" {
+			out = append(out, u)
+		}
+	}
+	return dedupPreserveOrder(out)
+}
+
+// exportDelimiter is the standard "#" unless an exported field contains it. CEX has no way to
+// declare another delimiter, so the alternative is left for readers to detect or be told about.
+func exportDelimiter(entries []CatalogEntry, texts []string, rows []int) string {
+	for _, sep := range []string{"#", "|", "\t"} {
+		clash := false
+		for _, i := range rows {
+			if strings.Contains(texts[i], sep) {
+				clash = true
+				break
+			}
+		}
+		for _, e := range entries {
+			if strings.Contains(e.GroupName+e.WorkTitle+e.VersionLabel+e.ExemplarLabel+e.CitationScheme+e.Lang, sep) {
+				clash = true
+			}
+		}
+		if !clash {
+			return sep
+		}
+	}
+	return "#"
+}
+
+// downloadName is the file name (without extension) for a download from cexName ("export" for the
+// default corpus) with the given parts appended.
+func downloadName(cexName string, parts ...string) string {
+	name := "export"
+	if cexName != "" {
+		name, _, _ = strings.Cut(cexName, "@")
+	}
+	for _, p := range parts {
+		if p != "" {
+			name += "-" + p
+		}
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == '"' || r == '\\' || r == '/' || r == '@' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// urnNameParts names a single-URN download after its work and passage reference.
+func urnNameParts(urns []string) []string {
+	if len(urns) != 1 {
+		return nil
+	}
+	p := strings.Split(urns[0], ":")
+	if len(p) < 4 {
+		return nil
+	}
+	if len(p) > 4 {
+		return []string{p[3], p[4]}
+	}
+	return []string{p[3]}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExportURNs(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		extra []string
+		want  []string
+	}{
+		{"urn:cts:a:b.c:1,urn:cts:a:b.c:2", nil, []string{"urn:cts:a:b.c:1", "urn:cts:a:b.c:2"}},
+		{"urn:cts:a:b.c:1@/a{1,3}/, urn:cts:a:b.c:2", nil, []string{"urn:cts:a:b.c:1@/a{1,3}/", "urn:cts:a:b.c:2"}},
+		{"urn:cts:a:b.c:1@men, say[2]", []string{"urn:cts:a:b.c:3", "urn:cts:a:b.c:1@men, say[2]"}, []string{"urn:cts:a:b.c:1@men, say[2]", "urn:cts:a:b.c:3"}},
+	} {
+		if got := exportURNs(tc.path, tc.extra); !slices.Equal(got, tc.want) {
+			t.Errorf("exportURNs(%q, %q) = %q, want %q", tc.path, tc.extra, got, tc.want)
+		}
+	}
+}
+
+func TestExportKeepsCatalogColumns(t *testing.T) {
+	_, h := newTestServer(t)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/texts/export/"+hdt+"1", nil))
+	if rec.Code != 200 {
+		t.Fatalf("export: %d %s", rec.Code, truncate(rec.Body.String()))
+	}
+	want := "#!cexversion\n3.0\n\n" +
+		"#!ctscatalog\n" +
+		"urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang\n" +
+		hdt + "#book.section#Herodotus#Histories#English translation##true#eng\n\n" +
+		"#!ctsdata\n"
+	if got := rec.Body.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("export starts\n%s\nwant\n%s", truncate(got), want)
+	}
+}
+
+func TestExportDelimiter(t *testing.T) {
+	entries := []CatalogEntry{{URN: "urn:cts:a:b.c:", WorkTitle: "W"}}
+	for _, c := range []struct {
+		texts []string
+		title string
+		want  string
+	}{
+		{[]string{"plain", "text"}, "W", "#"},
+		{[]string{"plain", "a # b"}, "W", "|"},
+		{[]string{"a | b", "a # b"}, "W", "\t"},
+		{[]string{"plain"}, "W #1", "|"},
+	} {
+		entries[0].WorkTitle = c.title
+		if got := exportDelimiter(entries, c.texts, []int{0, len(c.texts) - 1}); got != c.want {
+			t.Errorf("exportDelimiter(%q, %q) = %q, want %q", c.title, c.texts, got, c.want)
+		}
+	}
+}
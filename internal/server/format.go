@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// outputFormat is one representation a handler can produce: its ?format= name and the media types
+// that select it in an Accept header. The first media type is the Content-Type sent.
+type outputFormat struct {
+	name  string
+	types []string
+}
+
+var (
+	formatJSON = outputFormat{"json", []string{"application/json"}}
+	formatTEI  = outputFormat{"tei", []string{"application/tei+xml", "application/xml", "text/xml"}}
+)
+
+// The representations of the negotiating endpoints, in order of preference; /openapi.json lists the same.
+var (
+	passageFormats = []outputFormat{formatJSON, formatTEI, formatText, formatMarkdown, formatHTML, formatCSV, formatTSV, formatNDJSON, formatJSONLD}
+	catalogFormats = append([]outputFormat{formatJSON, formatJSONLD, formatTurtle}, tableFormats...)
+	urnFormats     = append([]outputFormat{formatJSON}, tableFormats...)
+	searchFormats  = append([]outputFormat{formatJSON}, tableFormats...)
+)
+
+func (f outputFormat) contentType() string {
+	ct := f.types[0]
+	if strings.HasPrefix(ct, "text/") || strings.HasSuffix(ct, "json") || strings.HasSuffix(ct, "xml") {
+		ct += "; charset=utf-8"
+	}
+	return ct
+}
+
+// negotiateFormat picks one of offers, the first being the default. ?format= wins over Accept; an
+// unknown ?format= is an error. Otherwise the Accept entry with the highest q decides, a more specific
+// media range beating a wildcard at equal q. An Accept header nothing satisfies gets the default.
+func negotiateFormat(w http.ResponseWriter, r *http.Request, offers ...outputFormat) (outputFormat, error) {
+	w.Header().Add("Vary", "Accept")
+	if name := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))); name != "" {
+		var names []string
+		for _, f := range offers {
+			if f.name == name {
+				return f, nil
+			}
+			names = append(names, f.name)
+		}
+		return offers[0], fmt.Errorf("Unsupported format %q; use one of %s.", name, strings.Join(names, ", "))
+	}
+	accept := parseAccept(r.Header.Get("Accept"))
+	if len(accept) == 0 {
+		return offers[0], nil
+	}
+	best, bestQ, bestSpec := offers[0], 0.0, -1
+	for _, f := range offers {
+		q, spec := acceptQuality(accept, f)
+		if q > bestQ || (q == bestQ && q > 0 && spec > bestSpec) {
+			best, bestQ, bestSpec = f, q, spec
+		}
+	}
+	return best, nil
+}
+
+type acceptRange struct {
+	typ, sub string
+	q        float64
+}
+
+func parseAccept(header string) []acceptRange {
+	var out []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		typ, sub, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok {
+			continue
+		}
+		ar := acceptRange{typ: typ, sub: sub, q: 1}
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q >= 0 && q <= 1 {
+					ar.q = q
+				}
+			}
+		}
+		out = append(out, ar)
+	}
+	return out
+}
+
+// acceptQuality returns the q the most specific matching range gives f, and that specificity
+// (2 exact, 1 type/*, 0 */*).
+func acceptQuality(accept []acceptRange, f outputFormat) (float64, int) {
+	q, spec := 0.0, -1
+	for _, mt := range f.types {
+		typ, sub, _ := strings.Cut(mt, "/")
+		for _, ar := range accept {
+			s := -1
+			switch {
+			case ar.typ == typ && ar.sub == sub:
+				s = 2
+			case ar.typ == typ && ar.sub == "*":
+				s = 1
+			case ar.typ == "*" && ar.sub == "*":
+				s = 0
+			}
+			if s > spec || (s == spec && s >= 0 && ar.q > q) {
+				q, spec = ar.q, s
+			}
+		}
+	}
+	return q, spec
+}
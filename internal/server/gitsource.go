@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Git sources read CEX files from a local repository (bare or working copy) at a pinned commit.
+// cex_source "git:cex/" is a directory base inside the repository, "git:cex/iliad.cex" a single file.
+// Every request is served from the commit current when it started; the commit is sent back in
+// the X-Corpus-Commit header so citations can be reproduced.
+
+const (
+	gitScheme    = "git:"
+	gitPinned    = "git@" // "git@<commit>:<path>", a source resolved against one commit
+	commitHeader = "X-Corpus-Commit"
+)
+
+func isGitSource(source string) bool {
+	return strings.HasPrefix(source, gitScheme) || strings.HasPrefix(source, gitPinned)
+}
+
+// gitRepo tracks the configured repository and the commit its ref currently resolves to.
+type gitRepo struct {
+	dir string
+
+	mu     sync.Mutex
+	ref    string
+	commit string // "" until first resolved
+}
+
+func newGitRepo(cfg GitConfig) *gitRepo {
+	if cfg.Repo == "" {
+		return nil
+	}
+	ref := cfg.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return &gitRepo{dir: cfg.Repo, ref: ref}
+}
+
+// current returns the served ref and commit, resolving the configured ref on first use.
+func (g *gitRepo) current(ctx context.Context) (ref, commit string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.commit == "" {
+		c, err := g.resolve(ctx, g.ref)
+		if err != nil {
+			return g.ref, "", err
+		}
+		g.commit = c
+	}
+	return g.ref, g.commit, nil
+}
+
+// checkout switches the served ref. Re-checking out the same branch picks up new commits.
+func (g *gitRepo) checkout(ctx context.Context, ref string) (commit string, err error) {
+	c, err := g.resolve(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	g.ref, g.commit = ref, c
+	g.mu.Unlock()
+	return c, nil
+}
+
+func (g *gitRepo) resolve(ctx context.Context, ref string) (string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") || strings.ContainsAny(ref, " \t\n:") {
+		return "", fmt.Errorf("invalid git ref %q", ref)
+	}
+	out, err := g.run(ctx, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("git ref %q not found in %s", ref, g.dir)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (g *gitRepo) run(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", g.dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// exists reports whether path (a file or directory, "" for the root) exists at commit.
+func (g *gitRepo) exists(ctx context.Context, commit, path string) error {
+	if _, err := g.run(ctx, "cat-file", "-e", commit+":"+path); err != nil {
+		return fmt.Errorf("%s not found at commit %s", gitDisplayPath(path), commit)
+	}
+	return nil
+}
+
+// open streams the blob at commit:path.
+func (g *gitRepo) open(ctx context.Context, commit, path string) (io.ReadCloser, error) {
+	if err := g.exists(ctx, commit, path); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "git", "-C", g.dir, "cat-file", "blob", commit+":"+path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("git: %w", err)
+	}
+	return &gitBlobReader{ReadCloser: out, cmd: cmd, stderr: &stderr}, nil
+}
+
+// gitBlobReader turns a failing git process into a read error instead of a silently short file.
+type gitBlobReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	done   bool
+}
+
+func (b *gitBlobReader) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF // Wait has closed the pipe
+	}
+	n, err := b.ReadCloser.Read(p)
+	if errors.Is(err, io.EOF) && !b.done {
+		b.done = true
+		if werr := b.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("git cat-file: %s", strings.TrimSpace(b.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (b *gitBlobReader) Close() error {
+	b.ReadCloser.Close()
+	if !b.done {
+		b.done = true
+		b.cmd.Process.Kill()
+		b.cmd.Wait()
+	}
+	return nil
+}
+
+// splitGitSource returns the commit ("" when unpinned) and the path inside the repository.
+func splitGitSource(source string) (commit, path string) {
+	if rest, ok := strings.CutPrefix(source, gitPinned); ok {
+		commit, path, _ = strings.Cut(rest, ":")
+	} else {
+		path = strings.TrimPrefix(source, gitScheme)
+	}
+	return commit, strings.Trim(path, "/")
+}
+
+func gitDisplayPath(path string) string {
+	if path == "" {
+		return "repository root"
+	}
+	return path
+}
+
+type commitKey struct{}
+
+// pinSource resolves a "git:" source against the request's commit, so that a cached corpus always
+// belongs to exactly one commit. Other sources are returned unchanged.
+func (s *Server) pinSource(ctx context.Context, source string) (string, error) {
+	if !strings.HasPrefix(source, gitScheme) {
+		return source, nil
+	}
+	if s.git == nil {
+		return "", errors.New("git source configured without git.repo")
+	}
+	commit, _ := ctx.Value(commitKey{}).(string)
+	if commit == "" {
+		var err error
+		if _, commit, err = s.git.current(ctx); err != nil {
+			return "", err
+		}
+	}
+	_, path := splitGitSource(source)
+	return gitPinned + commit + ":" + path, nil
+}
+
+// openGit opens a pinned or unpinned git source.
+func (s *Server) openGit(ctx context.Context, source string) (io.ReadCloser, error) {
+	pinned, err := s.pinSource(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	commit, path := splitGitSource(pinned)
+	return s.git.open(ctx, commit, path)
+}
+
+// withCommit fixes the served commit for the whole request and reports it in X-Corpus-Commit.
+func (s *Server) withCommit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.git != nil {
+			if _, commit, err := s.git.current(r.Context()); err == nil {
+				w.Header().Set(commitHeader, commit)
+				r = r.WithContext(context.WithValue(r.Context(), commitKey{}, commit))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ---- admin ----
+
+// requireAdmin checks the bearer token against admin_token; admin endpoints are off without one.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request, svc string) bool {
+	if s.cfg.AdminToken == "" {
+		s.writeError(w, r, http.StatusForbidden, "", AdminResponse{Status: "Exception", Service: svc, Message: "Admin endpoints are disabled (no admin_token configured)."})
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		s.writeError(w, r, http.StatusUnauthorized, "", AdminResponse{Status: "Exception", Service: svc, Message: "Missing or invalid admin token."})
+		return false
+	}
+	return true
+}
+
+// handleGitRef switches the served ref: POST /admin/git/ref with {"ref": "v2.1"} or ?ref=v2.1.
+func (s *Server) handleGitRef(w http.ResponseWriter, r *http.Request) {
+	svc := "/admin/git/ref"
+	if !s.requireAdmin(w, r, svc) {
+		return
+	}
+	if s.git == nil {
+		s.writeError(w, r, http.StatusNotFound, "", AdminResponse{Status: "Exception", Service: svc, Message: "No git repository configured."})
+		return
+	}
+	ref := r.URL.Query().Get("ref")
+	if ref == "" && r.Body != nil {
+		var body struct {
+			Ref string `json:"ref"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			s.writeError(w, r, http.StatusBadRequest, codeInvalidBody, AdminResponse{Status: "Exception", Service: svc, Message: "Body must be {\"ref\": \"...\"}."})
+			return
+		}
+		ref = body.Ref
+	}
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		s.writeError(w, r, http.StatusBadRequest, "", AdminResponse{Status: "Exception", Service: svc, Message: "Missing ref."})
+		return
+	}
+
+	prevRef, prevCommit, _ := s.git.current(r.Context())
+	commit, err := s.git.checkout(r.Context(), ref)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "", AdminResponse{Status: "Exception", Service: svc, Message: err.Error(), Ref: prevRef, Commit: prevCommit})
+		return
+	}
+	w.Header().Set(commitHeader, commit)
+	writeJSON(w, http.StatusOK, AdminResponse{
+		Status: "Success", Service: svc, Ref: ref, Commit: commit,
+		Message: fmt.Sprintf("Switched from %s (%s).", prevRef, prevCommit),
+	})
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitGitSource(t *testing.T) {
+	for _, c := range []struct {
+		source, commit, path string
+	}{
+		{"git:", "", ""},
+		{"git:cex/", "", "cex"},
+		{"git:/cex/iliad.cex", "", "cex/iliad.cex"},
+		{"git@abc123:cex/iliad.cex", "abc123", "cex/iliad.cex"},
+		{"git@abc123:", "abc123", ""},
+		{"git@abc123", "abc123", ""},
+	} {
+		commit, path := splitGitSource(c.source)
+		if commit != c.commit || path != c.path {
+			t.Errorf("splitGitSource(%q) = %q, %q, want %q, %q", c.source, commit, path, c.commit, c.path)
+		}
+	}
+}
+
+const gitCorpus = `#!ctscatalog
+urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang
+urn:cts:demo:tg.w.v1:#section#Group#Work#Version##true#eng
+
+#!ctsdata
+urn:cts:demo:tg.w.v1:1#%s
+`
+
+// gitFixture creates a repository with two commits of corpus.cex, tagged v1 and v2, and returns
+// its directory and the two commits.
+func gitFixture(t *testing.T) (dir, v1, v2 string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir = t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.org"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	for _, tag := range []string{"v1", "v2"} {
+		if err := os.WriteFile(filepath.Join(dir, "corpus.cex"), []byte(strings.Replace(gitCorpus, "%s", "Text "+tag, 1)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "corpus.cex")
+		git("commit", "-q", "-m", tag)
+		git("tag", tag)
+	}
+	return dir, git("rev-parse", "v1"), git("rev-parse", "v2")
+}
+
+func newGitServer(t *testing.T, repo, ref string) (*Server, http.Handler) {
+	t.Helper()
+	s := NewServer(ServerConfig{
+		Source:     "git:",
+		TestSource: "git:corpus.cex",
+		DataDir:    t.TempDir(),
+		AdminToken: "adm-test",
+		Git:        GitConfig{Repo: repo, Ref: ref},
+	})
+	s.quiet = true
+	h, err := BuildRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, h
+}
+
+func TestPinSource(t *testing.T) {
+	dir, v1, v2 := gitFixture(t)
+	s, _ := newGitServer(t, dir, "v1")
+	ctx := context.Background()
+	for _, c := range []struct {
+		ctx          context.Context
+		source, want string
+	}{
+		{ctx, "git:corpus.cex", gitPinned + v1 + ":corpus.cex"},
+		{ctx, "git:/", gitPinned + v1 + ":"},
+		{context.WithValue(ctx, commitKey{}, v2), "git:corpus.cex", gitPinned + v2 + ":corpus.cex"},
+		{ctx, gitPinned + v2 + ":corpus.cex", gitPinned + v2 + ":corpus.cex"},
+		{ctx, "testdata/demo.cex", "testdata/demo.cex"},
+	} {
+		got, err := s.pinSource(c.ctx, c.source)
+		if err != nil || got != c.want {
+			t.Errorf("pinSource(%q) = %q, %v, want %q", c.source, got, err, c.want)
+		}
+	}
+
+	s, _ = newGitServer(t, "", "")
+	if _, err := s.pinSource(ctx, "git:corpus.cex"); err == nil {
+		t.Error("pinSource without git.repo succeeded")
+	}
+}
+
+func TestGitResolveRejectsBadRefs(t *testing.T) {
+	dir, v1, _ := gitFixture(t)
+	g := newGitRepo(GitConfig{Repo: dir})
+	for _, ref := range []string{"", "-v", "--all", "v1 v2", "v1:corpus.cex", "v1\n", "nosuchref"} {
+		if c, err := g.resolve(context.Background(), ref); err == nil {
+			t.Errorf("resolve(%q) = %q, want an error", ref, c)
+		}
+	}
+	if c, err := g.resolve(context.Background(), "v1"); err != nil || c != v1 {
+		t.Errorf("resolve(v1) = %q, %v, want %q", c, err, v1)
+	}
+}
+
+func TestGitCheckoutSwitchesCommit(t *testing.T) {
+	dir, v1, v2 := gitFixture(t)
+	_, h := newGitServer(t, dir, "v1")
+
+	read := func(want, commit string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/texts/urn:cts:demo:tg.w.v1:1", nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("GET text: %d %s, want 200 with %q", rec.Code, truncate(rec.Body.String()), want)
+		}
+		if got := rec.Header().Get(commitHeader); got != commit {
+			t.Errorf("%s = %q, want %q", commitHeader, got, commit)
+		}
+	}
+	checkout := func(ref string, want int) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/admin/git/ref?ref="+ref, nil)
+		req.Header.Set("Authorization", "Bearer adm-test")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("checkout %s: %d %s, want %d", ref, rec.Code, truncate(rec.Body.String()), want)
+		}
+	}
+
+	read("Text v1", v1)
+	checkout("v2", http.StatusOK)
+	read("Text v2", v2)
+	checkout("nosuchref", http.StatusBadRequest)
+	read("Text v2", v2)
+	checkout(v1, http.StatusOK)
+	read("Text v1", v1)
+}
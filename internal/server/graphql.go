@@ -0,0 +1,812 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GhentCDH/annophis-text-service/cts"
+	"github.com/go-chi/chi/v5"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// The GraphQL API answers from the same corpus cache and cts resolution as the REST endpoints, so a
+// passage has the same text, neighbours and sequence in both.
+
+const graphQLSchema = `
+schema { query: Query }
+
+# Fields without a corpus argument answer from the corpus of the route (/{CEX}/graphql).
+type Query {
+  # A corpus by {CEX} name, optionally pinned as name@version; default: the route's.
+  corpus(name: String): Corpus
+  # A passage or citation container (e.g. a book) by URN; null if absent.
+  passage(urn: String!): Passage
+  # Passages of any URN /texts/{URN} accepts, with the same filters.
+  resolve(urn: String!, substring: String, clip: Boolean, context: Int, maxChars: Int, tail: Boolean): [Passage!]!
+  # Case-insensitive substring or /regex/ search over the passage texts.
+  search(pattern: String!, within: String, context: Int = 40, limit: Int = 100): [SearchHit!]!
+}
+
+type Corpus {
+  # {CEX} name; null for the default source.
+  name: String
+  # Content hash, usable as ?version= while the version is kept.
+  version: String!
+  textGroups: [TextGroup!]!
+  works: [Work!]!
+  versions: [Version!]!
+  work(urn: String!): Work
+  passage(urn: String!): Passage
+  resolve(urn: String!, substring: String, clip: Boolean, context: Int, maxChars: Int, tail: Boolean): [Passage!]!
+  search(pattern: String!, within: String, context: Int = 40, limit: Int = 100): [SearchHit!]!
+}
+
+type TextGroup {
+  urn: String!
+  name: String
+  works: [Work!]!
+}
+
+type Work {
+  urn: String!
+  title: String
+  textGroup: TextGroup!
+  versions: [Version!]!
+}
+
+# An edition or translation; versions without a catalog entry have no labels.
+type Version {
+  urn: String!
+  label: String
+  exemplar: String
+  citationScheme: [String!]!
+  online: Boolean!
+  catalogued: Boolean!
+  work: Work!
+  # Top-level passages or containers, in source order.
+  passages: [Passage!]!
+  first: Passage
+  last: Passage
+}
+
+# A cited passage, or a citation container such as a book, which has no text of its own.
+type Passage {
+  urn: String!
+  ref: String!
+  level: Int!
+  leaf: Boolean!
+  # null for containers; filtered as requested for resolved passages.
+  text: String
+  complete: Boolean!
+  # Position of the passage (or of a container's first passage) in the source.
+  sequence: Int!
+  # Passages follow the source order within their version; containers their neighbours at the same level.
+  previous: Passage
+  next: Passage
+  parent: Passage
+  children: [Passage!]!
+  version: Version!
+  # DSE image regions and ORCA alignments of the passage or, for a container, of the passages in it.
+  annotations: [Annotation!]!
+}
+
+interface Annotation {
+  urn: String!
+  label: String
+  # The annotated passage as recorded in #!citedata.
+  passage: String!
+}
+
+type ImageRegion implements Annotation {
+  urn: String!
+  label: String
+  passage: String!
+  image: String!
+  surface: String
+}
+
+type Alignment implements Annotation {
+  urn: String!
+  label: String
+  passage: String!
+  analysis: String!
+  deformation: String
+  # The analysed span, resolved like /texts/{URN}.
+  span: [Passage!]!
+}
+
+type SearchHit {
+  urn: String!
+  # Anchored URN that resolves back to the hit.
+  anchor: String!
+  text: String!
+  sequence: Int!
+  passage: Passage!
+}
+`
+
+const maxGraphQLBytes = 1 << 20
+
+// newGraphQLSchema is parsed once per router; resolvers find the request's corpora in the context.
+func newGraphQLSchema(s *Server) *graphql.Schema {
+	return graphql.MustParseSchema(graphQLSchema, &gqlRoot{s: s}, graphql.MaxDepth(15))
+}
+
+type gqlKey struct{}
+
+// gqlRequest is the state of one GraphQL request: the route's corpus (from {CEX} or ?cex=) and the
+// corpora loaded so far.
+type gqlRequest struct {
+	cexName string
+	query   url.Values
+	mu      sync.Mutex
+	corpora map[string]*gqlCorpus
+}
+
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+	fail := func(msg string) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"errors": []map[string]string{{"message": msg}}})
+	}
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		params.Query, params.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &params.Variables); err != nil {
+				fail("Invalid variables: " + err.Error())
+				return
+			}
+		}
+	} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBytes)).Decode(&params); err != nil {
+		fail("Invalid request body: " + err.Error())
+		return
+	}
+	if strings.TrimSpace(params.Query) == "" {
+		fail("Missing query.")
+		return
+	}
+
+	req := &gqlRequest{cexName: chi.URLParam(r, "CEX"), query: r.URL.Query(), corpora: map[string]*gqlCorpus{}}
+	ctx := context.WithValue(r.Context(), gqlKey{}, req)
+	writeJSON(w, http.StatusOK, s.graphQL.Exec(ctx, params.Query, params.OperationName, params.Variables))
+}
+
+// gqlError carries a machine-readable code in the error's extensions: the code of the REST problem
+// response, in upper case.
+type gqlError struct {
+	msg, code string
+}
+
+func (e *gqlError) Error() string              { return e.msg }
+func (e *gqlError) Extensions() map[string]any { return map[string]any{"code": e.code} }
+
+func gqlResolveError(err error) error {
+	if status, code := resolveFailure(err); status != http.StatusInternalServerError {
+		return &gqlError{err.Error(), strings.ToUpper(code)}
+	}
+	return err
+}
+
+// ---- root ----
+
+type gqlRoot struct{ s *Server }
+
+type gqlNameArgs struct{ Name *string }
+
+type gqlURNArgs struct{ URN string }
+
+type gqlResolveArgs struct {
+	URN       string
+	Substring *string
+	Clip      *bool
+	Context   *int32
+	MaxChars  *int32
+	Tail      *bool
+}
+
+type gqlSearchArgs struct {
+	Pattern string
+	Within  *string
+	Context int32
+	Limit   int32
+}
+
+func (q *gqlRoot) Corpus(ctx context.Context, args gqlNameArgs) (*gqlCorpus, error) {
+	name := ""
+	if args.Name != nil {
+		name = *args.Name
+	}
+	return q.s.gqlCorpus(ctx, name)
+}
+
+func (q *gqlRoot) Passage(ctx context.Context, args gqlURNArgs) (*gqlPassage, error) {
+	c, err := q.s.gqlCorpus(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return c.Passage(args), nil
+}
+
+func (q *gqlRoot) Resolve(ctx context.Context, args gqlResolveArgs) ([]*gqlPassage, error) {
+	c, err := q.s.gqlCorpus(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return c.Resolve(args)
+}
+
+func (q *gqlRoot) Search(ctx context.Context, args gqlSearchArgs) ([]*gqlHit, error) {
+	c, err := q.s.gqlCorpus(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return c.Search(args)
+}
+
+// gqlCorpus loads a corpus once per request. An empty name is the route's corpus, which is the one
+// the REST handlers of the same request would see.
+func (s *Server) gqlCorpus(ctx context.Context, name string) (*gqlCorpus, error) {
+	req, _ := ctx.Value(gqlKey{}).(*gqlRequest)
+	if req == nil {
+		return nil, errors.New("no GraphQL request in context")
+	}
+	if name == "" {
+		name = req.cexName
+	}
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	if c, ok := req.corpora[name]; ok {
+		return c, nil
+	}
+
+	source := pickSource(s.cfg, name, req.query)
+	var c *corpus
+	if _, v, _ := strings.Cut(name, "@"); v != "" && name != req.cexName {
+		var ok bool
+		if c, ok = s.corpora.version(source, v); !ok {
+			return nil, &gqlError{fmt.Sprintf("Version %s of %s is not available; it was never served or has been dropped.", v, source), strings.ToUpper(codeVersionNotFound)}
+		}
+	} else {
+		var err error
+		if c, err = s.loadCorpus(ctx, source); err != nil {
+			_, code, msg := sourceFailure(err)
+			return nil, &gqlError{msg, strings.ToUpper(code)}
+		}
+	}
+	gc := &gqlCorpus{s: s, name: name, source: source, c: c}
+	// the citedata parsers look the corpus up by source; pin it for them
+	gc.ctx = context.WithValue(ctx, servedKey{}, &servedCorpora{m: map[string]*corpus{source: c}})
+	req.corpora[name] = gc
+	return gc, nil
+}
+
+// ---- corpus ----
+
+// gqlCorpus is a corpus with the catalog and citation hierarchy indexes the resolvers need, built on
+// first use. Resolvers run in parallel, so everything lazy is guarded.
+type gqlCorpus struct {
+	s            *Server
+	name, source string
+	c            *corpus
+	ctx          context.Context
+
+	indexOnce sync.Once
+	pos       map[string]int // passage URN -> index of its first occurrence
+	groups    []*gqlTextGroup
+	works     []*gqlWork
+	versions  []*gqlVersion
+	byStem    map[string]*gqlVersion
+	byWork    map[string]*gqlWork
+
+	mu     sync.Mutex
+	levels map[levelKey][]gqlNode
+
+	annOnce sync.Once
+	dse     map[string][]DSERecord
+	orca    []gqlAlignmentRec
+}
+
+type levelKey struct {
+	v     *gqlVersion
+	level int
+}
+
+// gqlNode is a passage or container at one citation level, with the index of its first passage.
+type gqlNode struct {
+	urn   string
+	first int
+}
+
+type gqlAlignmentRec struct {
+	ORCARecord
+	covers map[string]bool // URNs of the span's passages
+}
+
+func (c *gqlCorpus) Name() *string {
+	if c.name == "" {
+		return nil
+	}
+	return &c.name
+}
+
+func (c *gqlCorpus) Version() string { return c.c.version }
+
+func (c *gqlCorpus) TextGroups() []*gqlTextGroup { c.index(); return c.groups }
+func (c *gqlCorpus) Works() []*gqlWork           { c.index(); return c.works }
+func (c *gqlCorpus) Versions() []*gqlVersion     { c.index(); return c.versions }
+
+func (c *gqlCorpus) Work(args gqlURNArgs) *gqlWork {
+	c.index()
+	return c.byWork[args.URN]
+}
+
+func (c *gqlCorpus) Passage(args gqlURNArgs) *gqlPassage {
+	c.index()
+	if i, ok := c.pos[args.URN]; ok {
+		return c.leaf(i)
+	}
+	stem, _ := splitStem(args.URN)
+	v := c.byStem[stem]
+	if v == nil {
+		return nil
+	}
+	for _, i := range v.leaves {
+		if strings.HasPrefix(c.c.URNs[i], args.URN+".") {
+			return &gqlPassage{c: c, v: v, urn: args.URN, leaf: -1, first: i}
+		}
+	}
+	return nil
+}
+
+func (c *gqlCorpus) Resolve(args gqlResolveArgs) ([]*gqlPassage, error) {
+	opts := cts.Options{Clip: args.Clip}
+	if args.Substring != nil {
+		opts.Substring = *args.Substring
+	}
+	if args.Context != nil {
+		n := int(*args.Context)
+		opts.Context = &n
+	}
+	if args.MaxChars != nil {
+		opts.MaxChars = int(*args.MaxChars)
+	}
+	if args.Tail != nil {
+		opts.Tail = *args.Tail
+	}
+	return c.resolve(args.URN, opts)
+}
+
+func (c *gqlCorpus) resolve(urn string, opts cts.Options) ([]*gqlPassage, error) {
+	nodes, err := c.c.Resolve(urn, opts)
+	if err != nil {
+		return nil, gqlResolveError(err)
+	}
+	c.index()
+	out := make([]*gqlPassage, 0, len(nodes))
+	for _, n := range nodes {
+		p := c.leaf(n.Sequence - 1)
+		if len(n.Text) > 0 {
+			p.text = &n.Text[0]
+		}
+		p.complete = n.Complete
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (c *gqlCorpus) Search(args gqlSearchArgs) ([]*gqlHit, error) {
+	opts := cts.SearchOptions{Context: int(args.Context), Limit: int(args.Limit)}
+	if args.Within != nil {
+		opts.Within = *args.Within
+	}
+	matches, err := c.c.Search(args.Pattern, opts)
+	if err != nil {
+		return nil, gqlResolveError(err)
+	}
+	out := make([]*gqlHit, len(matches))
+	for i, m := range matches {
+		out[i] = &gqlHit{c: c, m: m}
+	}
+	return out, nil
+}
+
+// leaf is the passage at index i of the source.
+func (c *gqlCorpus) leaf(i int) *gqlPassage {
+	stem, _ := splitStem(c.c.URNs[i])
+	return &gqlPassage{c: c, v: c.byStem[stem], urn: c.c.URNs[i], leaf: i, first: i, complete: true}
+}
+
+// index groups the catalog and the passages into text groups, works and versions. Catalogued
+// versions come first, in catalog order, then versions that only occur in #!ctsdata.
+func (c *gqlCorpus) index() {
+	c.indexOnce.Do(func() {
+		c.pos = make(map[string]int, len(c.c.URNs))
+		c.byStem = map[string]*gqlVersion{}
+		c.byWork = map[string]*gqlWork{}
+		byGroup := map[string]*gqlTextGroup{}
+		add := func(stem string) *gqlVersion {
+			if v := c.byStem[stem]; v != nil {
+				return v
+			}
+			groupURN, workURN := workOf(stem)
+			g := byGroup[groupURN]
+			if g == nil {
+				g = &gqlTextGroup{urn: groupURN}
+				byGroup[groupURN] = g
+				c.groups = append(c.groups, g)
+			}
+			w := c.byWork[workURN]
+			if w == nil {
+				w = &gqlWork{urn: workURN, group: g}
+				c.byWork[workURN] = w
+				c.works = append(c.works, w)
+				g.works = append(g.works, w)
+			}
+			v := &gqlVersion{c: c, urn: stem, work: w}
+			c.byStem[stem] = v
+			c.versions = append(c.versions, v)
+			w.versions = append(w.versions, v)
+			return v
+		}
+		for _, e := range c.c.Catalog {
+			v := add(e.URN)
+			v.entry, v.catalogued = e, true
+			if v.work.title == "" {
+				v.work.title = e.WorkTitle
+			}
+			if v.work.group.name == "" {
+				v.work.group.name = e.GroupName
+			}
+		}
+		for i, urn := range c.c.URNs {
+			if _, ok := c.pos[urn]; !ok {
+				c.pos[urn] = i
+			}
+			stem, _ := splitStem(urn)
+			v := add(stem)
+			v.leaves = append(v.leaves, i)
+		}
+		c.levels = map[levelKey][]gqlNode{}
+	})
+}
+
+// atLevel lists the distinct passages and containers of v with level citation components, in
+// source order.
+func (c *gqlCorpus) atLevel(v *gqlVersion, level int) []gqlNode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := levelKey{v, level}
+	if nodes, ok := c.levels[key]; ok {
+		return nodes
+	}
+	nodes := []gqlNode{}
+	seen := map[string]bool{}
+	for _, i := range v.leaves {
+		_, ref := splitStem(c.c.URNs[i])
+		parts := strings.Split(ref, ".")
+		if len(parts) < level {
+			continue
+		}
+		urn := v.urn + strings.Join(parts[:level], ".")
+		if !seen[urn] {
+			seen[urn] = true
+			nodes = append(nodes, gqlNode{urn: urn, first: i})
+		}
+	}
+	c.levels[key] = nodes
+	return nodes
+}
+
+// annotations loads the DSE and ORCA records once, with each alignment's span resolved.
+func (c *gqlCorpus) annotations() {
+	c.annOnce.Do(func() {
+		if recs, err := c.s.parseDSE(c.ctx, c.source); err == nil {
+			c.dse = dseByPassage(recs)
+		}
+		recs, _ := c.s.parseORCA(c.ctx, c.source)
+		for _, rec := range recs {
+			a := gqlAlignmentRec{ORCARecord: rec, covers: map[string]bool{}}
+			nodes, _ := c.c.Resolve(rec.Passage, cts.Options{})
+			for _, n := range nodes {
+				a.covers[n.URN[0]] = true
+			}
+			c.orca = append(c.orca, a)
+		}
+	})
+}
+
+// splitStem splits a passage URN into its version ("urn:cts:ns:tg.work.version:") and reference.
+func splitStem(urn string) (stem, ref string) {
+	i := strings.LastIndex(urn, ":")
+	return urn[:i+1], urn[i+1:]
+}
+
+// workOf returns the text group and work URNs of a version URN.
+func workOf(stem string) (group, work string) {
+	parts := strings.Split(strings.TrimSuffix(stem, ":"), ":")
+	if len(parts) < 4 {
+		return stem, stem
+	}
+	prefix := strings.Join(parts[:3], ":") + ":"
+	comps := strings.Split(parts[3], ".")
+	group = prefix + comps[0] + ":"
+	if len(comps) < 2 {
+		return group, group
+	}
+	return group, prefix + comps[0] + "." + comps[1] + ":"
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// ---- catalog ----
+
+type gqlTextGroup struct {
+	urn, name string
+	works     []*gqlWork
+}
+
+func (g *gqlTextGroup) URN() string       { return g.urn }
+func (g *gqlTextGroup) Name() *string     { return optional(g.name) }
+func (g *gqlTextGroup) Works() []*gqlWork { return g.works }
+
+type gqlWork struct {
+	urn, title string
+	group      *gqlTextGroup
+	versions   []*gqlVersion
+}
+
+func (w *gqlWork) URN() string              { return w.urn }
+func (w *gqlWork) Title() *string           { return optional(w.title) }
+func (w *gqlWork) TextGroup() *gqlTextGroup { return w.group }
+func (w *gqlWork) Versions() []*gqlVersion  { return w.versions }
+
+type gqlVersion struct {
+	c          *gqlCorpus
+	urn        string
+	entry      CatalogEntry
+	catalogued bool
+	work       *gqlWork
+	leaves     []int // indexes of the version's passages, ascending
+}
+
+func (v *gqlVersion) URN() string       { return v.urn }
+func (v *gqlVersion) Label() *string    { return optional(v.entry.VersionLabel) }
+func (v *gqlVersion) Exemplar() *string { return optional(v.entry.ExemplarLabel) }
+func (v *gqlVersion) Online() bool      { return v.entry.Online }
+func (v *gqlVersion) Catalogued() bool  { return v.catalogued }
+func (v *gqlVersion) Work() *gqlWork    { return v.work }
+
+func (v *gqlVersion) CitationScheme() []string {
+	if v.entry.CitationScheme == "" {
+		return []string{}
+	}
+	return strings.Split(v.entry.CitationScheme, ".")
+}
+
+func (v *gqlVersion) Passages() []*gqlPassage {
+	nodes := v.c.atLevel(v, 1)
+	out := make([]*gqlPassage, len(nodes))
+	for i, n := range nodes {
+		out[i] = v.node(n)
+	}
+	return out
+}
+
+func (v *gqlVersion) First() *gqlPassage {
+	if len(v.leaves) == 0 {
+		return nil
+	}
+	return v.c.leaf(v.leaves[0])
+}
+
+func (v *gqlVersion) Last() *gqlPassage {
+	if len(v.leaves) == 0 {
+		return nil
+	}
+	return v.c.leaf(v.leaves[len(v.leaves)-1])
+}
+
+// node is the passage for n: the passage itself when n is cited, a container otherwise.
+func (v *gqlVersion) node(n gqlNode) *gqlPassage {
+	if v.c.c.URNs[n.first] == n.urn {
+		return v.c.leaf(n.first)
+	}
+	return &gqlPassage{c: v.c, v: v, urn: n.urn, leaf: -1, first: n.first}
+}
+
+// ---- passages ----
+
+type gqlPassage struct {
+	c        *gqlCorpus
+	v        *gqlVersion
+	urn      string
+	leaf     int     // index in the source, or -1 for a container
+	first    int     // index of the first passage under a container
+	text     *string // filtered text of a resolved passage
+	complete bool
+}
+
+func (p *gqlPassage) URN() string { return p.urn }
+
+func (p *gqlPassage) Ref() string {
+	_, ref := splitStem(p.urn)
+	return ref
+}
+
+func (p *gqlPassage) Level() int32 { return int32(strings.Count(p.Ref(), ".") + 1) }
+func (p *gqlPassage) Leaf() bool   { return p.leaf >= 0 }
+
+func (p *gqlPassage) Text() *string {
+	if p.leaf < 0 {
+		return nil
+	}
+	if p.text != nil {
+		return p.text
+	}
+	return &p.c.c.Texts[p.leaf]
+}
+
+func (p *gqlPassage) Complete() bool       { return p.leaf < 0 || p.text == nil || p.complete }
+func (p *gqlPassage) Sequence() int32      { return int32(p.first + 1) }
+func (p *gqlPassage) Version() *gqlVersion { return p.v }
+
+func (p *gqlPassage) Previous() *gqlPassage { return p.sibling(-1) }
+func (p *gqlPassage) Next() *gqlPassage     { return p.sibling(1) }
+
+func (p *gqlPassage) sibling(step int) *gqlPassage {
+	if p.leaf >= 0 {
+		k := sort.SearchInts(p.v.leaves, p.leaf) + step
+		if k < 0 || k >= len(p.v.leaves) {
+			return nil
+		}
+		return p.c.leaf(p.v.leaves[k])
+	}
+	nodes := p.c.atLevel(p.v, int(p.Level()))
+	for k, n := range nodes {
+		if n.urn == p.urn {
+			if k+step < 0 || k+step >= len(nodes) {
+				return nil
+			}
+			return p.v.node(nodes[k+step])
+		}
+	}
+	return nil
+}
+
+func (p *gqlPassage) Parent() *gqlPassage {
+	ref := p.Ref()
+	dot := strings.LastIndex(ref, ".")
+	if dot < 0 {
+		return nil
+	}
+	return p.c.Passage(gqlURNArgs{URN: p.v.urn + ref[:dot]})
+}
+
+func (p *gqlPassage) Children() []*gqlPassage {
+	out := []*gqlPassage{}
+	if p.leaf >= 0 {
+		return out
+	}
+	for _, n := range p.c.atLevel(p.v, int(p.Level())+1) {
+		if strings.HasPrefix(n.urn, p.urn+".") {
+			out = append(out, p.v.node(n))
+		}
+	}
+	return out
+}
+
+func (p *gqlPassage) Annotations() []*gqlAnnotation {
+	p.c.annotations()
+	covered := []string{p.urn}
+	if p.leaf < 0 {
+		for _, i := range p.v.leaves {
+			if strings.HasPrefix(p.c.c.URNs[i], p.urn+".") {
+				covered = append(covered, p.c.c.URNs[i])
+			}
+		}
+	}
+	out := []*gqlAnnotation{}
+	for _, urn := range covered {
+		for _, rec := range p.c.dse[urn] {
+			out = append(out, &gqlAnnotation{c: p.c, dse: &rec})
+		}
+	}
+	for i := range p.c.orca {
+		a := &p.c.orca[i]
+		for _, urn := range covered {
+			if a.covers[urn] {
+				out = append(out, &gqlAnnotation{c: p.c, orca: a})
+				break
+			}
+		}
+	}
+	return out
+}
+
+// ---- annotations ----
+
+// gqlAnnotation is either a DSE record (an ImageRegion) or an ORCA alignment.
+type gqlAnnotation struct {
+	c    *gqlCorpus
+	dse  *DSERecord
+	orca *gqlAlignmentRec
+}
+
+func (a *gqlAnnotation) URN() string {
+	if a.dse != nil {
+		return a.dse.URN
+	}
+	return a.orca.URN
+}
+
+func (a *gqlAnnotation) Label() *string {
+	if a.dse != nil {
+		return optional(a.dse.Label)
+	}
+	return optional(a.orca.Label)
+}
+
+func (a *gqlAnnotation) Passage() string {
+	if a.dse != nil {
+		return a.dse.Passage
+	}
+	return a.orca.Passage
+}
+
+func (a *gqlAnnotation) ToImageRegion() (*gqlImageRegion, bool) {
+	return &gqlImageRegion{a}, a.dse != nil
+}
+
+func (a *gqlAnnotation) ToAlignment() (*gqlAlignment, bool) {
+	return &gqlAlignment{a}, a.orca != nil
+}
+
+type gqlImageRegion struct{ *gqlAnnotation }
+
+func (r *gqlImageRegion) Image() string    { return r.dse.ImageROI }
+func (r *gqlImageRegion) Surface() *string { return optional(r.dse.Surface) }
+
+type gqlAlignment struct{ *gqlAnnotation }
+
+func (a *gqlAlignment) Analysis() string     { return a.orca.Analysis }
+func (a *gqlAlignment) Deformation() *string { return optional(a.orca.Deformation) }
+
+func (a *gqlAlignment) Span() []*gqlPassage {
+	span, err := a.c.resolve(a.orca.Passage, cts.Options{})
+	if err != nil {
+		return []*gqlPassage{}
+	}
+	return span
+}
+
+// ---- search ----
+
+type gqlHit struct {
+	c *gqlCorpus
+	m cts.Match
+}
+
+func (h *gqlHit) URN() string     { return h.m.URN }
+func (h *gqlHit) Anchor() string  { return h.m.Anchor }
+func (h *gqlHit) Text() string    { return h.m.Text }
+func (h *gqlHit) Sequence() int32 { return int32(h.m.Sequence) }
+func (h *gqlHit) Passage() *gqlPassage {
+	h.c.index()
+	return h.c.leaf(h.m.Sequence - 1)
+}
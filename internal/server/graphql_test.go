@@ -0,0 +1,265 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type gqlResult struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+// graphQL posts query to target and decodes the data into out; it returns the error codes.
+func graphQL(t *testing.T, h http.Handler, target, query string, out any) []string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"query": query})
+	req := httptest.NewRequest("POST", target, strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var res gqlResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%s: %v: %s", query, err, truncate(rec.Body.String()))
+	}
+	if out != nil && len(res.Data) > 0 {
+		if err := json.Unmarshal(res.Data, out); err != nil {
+			t.Fatalf("%s: %v: %s", query, err, res.Data)
+		}
+	}
+	var codes []string
+	for _, e := range res.Errors {
+		code, _ := e.Extensions["code"].(string)
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+type gqlTestPassage struct {
+	URN      string
+	Ref      string
+	Level    int
+	Leaf     bool
+	Text     *string
+	Complete bool
+	Sequence int
+	Previous *struct{ URN string }
+	Next     *struct{ URN string }
+	Parent   *struct {
+		URN      string
+		Children []struct{ URN string }
+	}
+	Children []struct{ URN string }
+}
+
+func (p gqlTestPassage) neighbours() (prev, next string) {
+	if p.Previous != nil {
+		prev = p.Previous.URN
+	}
+	if p.Next != nil {
+		next = p.Next.URN
+	}
+	return prev, next
+}
+
+const gqlPassageFields = `urn ref level leaf text complete sequence previous { urn } next { urn }
+	parent { urn children { urn } } children { urn }`
+
+func TestGraphQLPassage(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, c := range []struct {
+		urn              string
+		leaf             bool
+		level, sequence  int
+		prev, next       string
+		parent, children []string // parent URN and its children
+		own              []string // the passage's children
+	}{
+		{hdt + "1.1", true, 2, 2, hdt + "1.0", hdt + "1.2", []string{hdt + "1", hdt + "1.0", hdt + "1.1", hdt + "1.2"}, nil, nil},
+		{hdt + "2.1", true, 2, 4, hdt + "1.2", "", []string{hdt + "2", hdt + "2.1"}, nil, nil},
+		{iliad + "1.1", true, 2, 5, "", iliad + "1.2", []string{iliad + "1", iliad + "1.1", iliad + "1.2"}, nil, nil},
+		{hdt + "1", false, 1, 1, "", hdt + "2", nil, nil, []string{hdt + "1.0", hdt + "1.1", hdt + "1.2"}},
+		{hdt + "2", false, 1, 4, hdt + "1", "", nil, nil, []string{hdt + "2.1"}},
+	} {
+		var data struct{ Passage *gqlTestPassage }
+		if codes := graphQL(t, h, "/graphql", `{ passage(urn: "`+c.urn+`") { `+gqlPassageFields+` } }`, &data); codes != nil || data.Passage == nil {
+			t.Errorf("passage %s: %v %+v", c.urn, codes, data.Passage)
+			continue
+		}
+		p := *data.Passage
+		if p.URN != c.urn || p.Leaf != c.leaf || p.Level != c.level || p.Sequence != c.sequence || (p.Text != nil) != c.leaf {
+			t.Errorf("passage %s = %+v", c.urn, p)
+		}
+		if prev, next := p.neighbours(); prev != c.prev || next != c.next {
+			t.Errorf("passage %s: between %q and %q, want %q and %q", c.urn, prev, next, c.prev, c.next)
+		}
+		var parent []string
+		if p.Parent != nil {
+			parent = append(parent, p.Parent.URN)
+			for _, ch := range p.Parent.Children {
+				parent = append(parent, ch.URN)
+			}
+		}
+		if !slices.Equal(parent, c.parent) {
+			t.Errorf("passage %s: parent and its children %q, want %q", c.urn, parent, c.parent)
+		}
+		var own []string
+		for _, ch := range p.Children {
+			own = append(own, ch.URN)
+		}
+		if !slices.Equal(own, c.own) {
+			t.Errorf("passage %s: children %q, want %q", c.urn, own, c.own)
+		}
+	}
+
+	var data struct{ Passage *gqlTestPassage }
+	if codes := graphQL(t, h, "/graphql", `{ passage(urn: "`+hdt+`9.9") { urn } }`, &data); codes != nil || data.Passage != nil {
+		t.Errorf("missing passage: %v %+v, want null", codes, data.Passage)
+	}
+}
+
+func TestGraphQLResolve(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, c := range []struct {
+		query    string
+		urns     []string
+		text     string // of the first passage
+		complete bool
+		sequence []int
+	}{
+		{`resolve(urn: "` + hdt + `1.2@Egypt[1]")`, []string{hdt + "1.2"}, "Egypt", false, []int{3}},
+		{`resolve(urn: "` + hdt + `1.2@/eg.pt;?/[1]")`, []string{hdt + "1.2"}, "Egypt;", false, []int{3}},
+		{`resolve(urn: "` + hdt + `1.2", substring: "Io", clip: true, context: 3)`, []string{hdt + "1.2"}, "So Io wa", false, []int{3}},
+		{`resolve(urn: "` + iliad + `1.1-1.2")`, []string{iliad + "1.1", iliad + "1.2"}, "μῆνιν ἄειδε θεὰ Πηληϊάδεω Ἀχιλῆος", true, []int{5, 6}},
+		{`resolve(urn: "` + hdt + `2")`, []string{hdt + "2.1"}, "After the death of Cyrus, Cambyses inherited his royal power.", true, []int{4}},
+	} {
+		var data struct{ Resolve []gqlTestPassage }
+		if codes := graphQL(t, h, "/graphql", `{ `+c.query+` { `+gqlPassageFields+` } }`, &data); codes != nil {
+			t.Errorf("%s: %v", c.query, codes)
+			continue
+		}
+		var urns []string
+		var seqs []int
+		for _, p := range data.Resolve {
+			urns = append(urns, p.URN)
+			seqs = append(seqs, p.Sequence)
+		}
+		if !slices.Equal(urns, c.urns) || !slices.Equal(seqs, c.sequence) {
+			t.Errorf("%s = %q at %v, want %q at %v", c.query, urns, seqs, c.urns, c.sequence)
+			continue
+		}
+		if p := data.Resolve[0]; p.Text == nil || *p.Text != c.text || p.Complete != c.complete {
+			t.Errorf("%s: first passage %+v, want text %q complete %v", c.query, p, c.text, c.complete)
+		}
+	}
+}
+
+func TestGraphQLSearchHitsResolve(t *testing.T) {
+	_, h := newTestServer(t)
+	var data struct {
+		Search []struct {
+			URN, Anchor, Text string
+			Sequence          int
+			Passage           struct{ URN string }
+		}
+	}
+	if codes := graphQL(t, h, "/graphql", `{ search(pattern: "/pers[a-z]*/", context: 0) { urn anchor text sequence passage { urn } } }`, &data); codes != nil {
+		t.Fatal(codes)
+	}
+	if len(data.Search) != 2 {
+		t.Fatalf("search = %+v, want two hits", data.Search)
+	}
+	for _, hit := range data.Search {
+		if hit.Passage.URN != hit.URN {
+			t.Errorf("hit %s: passage %s", hit.Anchor, hit.Passage.URN)
+		}
+		var res struct{ Resolve []gqlTestPassage }
+		anchor, _ := json.Marshal(hit.Anchor)
+		if codes := graphQL(t, h, "/graphql", `{ resolve(urn: `+string(anchor)+`) { urn text sequence } }`, &res); codes != nil {
+			t.Errorf("resolve %s: %v", hit.Anchor, codes)
+			continue
+		}
+		if len(res.Resolve) != 1 || res.Resolve[0].URN != hit.URN || *res.Resolve[0].Text != hit.Text || res.Resolve[0].Sequence != hit.Sequence {
+			t.Errorf("resolve %s = %+v, want hit %+v", hit.Anchor, res.Resolve, hit)
+		}
+	}
+}
+
+func TestGraphQLVersionPinning(t *testing.T) {
+	s, h := newTestServer(t)
+	var cur struct{ Corpus struct{ Version string } }
+	if codes := graphQL(t, h, "/graphql", `{ corpus(name: "demo") { version } }`, &cur); codes != nil || len(cur.Corpus.Version) < 6 {
+		t.Fatalf("corpus version: %v %+v", codes, cur)
+	}
+	old := cur.Corpus.Version
+
+	edited := strings.Replace(demoCEX(t), "So Io was carried off to Egypt", "So Io was taken to Egypt", 1)
+	c, err := readVersionedCorpus(strings.NewReader(edited), CorpusConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.corpora.replace("testdata/demo.cex", c)
+
+	for _, v := range []struct{ name, version, text string }{
+		{"demo", c.version, "taken"},
+		{"demo@" + old, old, "carried off"},
+		{"demo@" + old[:6], old, "carried off"},
+	} {
+		var data struct {
+			Corpus struct {
+				Version string
+				Passage struct{ Text string }
+			}
+		}
+		q := `{ corpus(name: "` + v.name + `") { version passage(urn: "` + hdt + `1.2") { text } } }`
+		if codes := graphQL(t, h, "/graphql", q, &data); codes != nil {
+			t.Errorf("corpus %s: %v", v.name, codes)
+			continue
+		}
+		if data.Corpus.Version != v.version || !strings.Contains(data.Corpus.Passage.Text, v.text) {
+			t.Errorf("corpus %s = %+v, want version %s with %q", v.name, data.Corpus, v.version, v.text)
+		}
+	}
+}
+
+func TestGraphQLErrorCodes(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, c := range []struct {
+		target, query, code string
+	}{
+		{"/graphql", `{ resolve(urn: "not-a-urn") { urn } }`, "INVALID_URN"},
+		{"/graphql", `{ resolve(urn: "` + hdt + `9.9") { urn } }`, "NOT_FOUND"},
+		{"/graphql", `{ resolve(urn: "` + hdt + `1.1@/(/") { urn } }`, "INVALID_REGEX"},
+		{"/graphql", `{ search(pattern: "/(/") { urn } }`, "INVALID_REGEX"},
+		{"/graphql", `{ corpus(name: "demo@000000") { version } }`, "VERSION_NOT_FOUND"},
+		{"/graphql", `{ corpus(name: "nosuchcorpus") { version } }`, "CORPUS_NOT_FOUND"},
+		{"/nosuchcorpus/graphql", `{ passage(urn: "` + hdt + `1.1") { urn } }`, "CORPUS_NOT_FOUND"},
+		{"/graphql?cex=nosuchcorpus", `{ passage(urn: "` + hdt + `1.1") { urn } }`, "CORPUS_NOT_FOUND"},
+	} {
+		if codes := graphQL(t, h, c.target, c.query, nil); len(codes) != 1 || codes[0] != c.code {
+			t.Errorf("%s %s: codes %v, want %s", c.target, c.query, codes, c.code)
+		}
+	}
+
+	var data struct{ Passage struct{ URN string } }
+	if codes := graphQL(t, h, "/graphql?cex=demo", `{ passage(urn: "`+hdt+`1.1") { urn } }`, &data); codes != nil || data.Passage.URN != hdt+"1.1" {
+		t.Errorf("?cex=demo: %v %+v", codes, data)
+	}
+}
+
+func demoCEX(t *testing.T) string {
+	t.Helper()
+	b, err := os.ReadFile("testdata/demo.cex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/GhentCDH/annophis-text-service/cts"
 	cite "github.com/ThomasK81/gocite"
 	"github.com/go-chi/chi/v5"
 )
@@ -14,6 +15,7 @@ func (s *Server) handleCiteVersion(w http.ResponseWriter, r *http.Request) {
 		Service: "/cite",
 		Versions: Versions{
 			Texts: "1.1.0",
+			ORCA:  "1.0.0",
 		},
 	})
 }
@@ -31,19 +33,38 @@ func (s *Server) handleCatalog(w http.ResponseWriter, r *http.Request) {
 	cexName := chi.URLParam(r, "CEX")
 	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
 
+	format, err := negotiateFormat(w, r, catalogFormats...)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "", CatalogResponse{Status: "Exception", Service: "/texts/catalog", Entries: []CatalogEntry{}, Message: err.Error()})
+		return
+	}
 	entries, err := s.parseCTSCatalog(ctx, source)
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, CatalogResponse{
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, CatalogResponse{
 			Status:  "Exception",
 			Service: "/texts/catalog",
-			Message: "Couldn't read catalog: " + err.Error(),
+			Entries: []CatalogEntry{},
+			Message: msg,
 		})
 		return
 	}
+	switch {
+	case isTableFormat(format):
+		writeCatalogTable(w, r, format, s.servedVersion(ctx, source), entries)
+		return
+	case format.name == formatJSONLD.name || format.name == formatTurtle.name:
+		s.writeCatalogLD(w, r, format, s.servedVersion(ctx, source), entries)
+		return
+	}
+	if entries == nil {
+		entries = []CatalogEntry{}
+	}
 	writeJSON(w, http.StatusOK, CatalogResponse{
 		Status:  "Success",
 		Service: "/texts/catalog",
 		Entries: entries,
+		Version: s.servedVersion(ctx, source),
 	})
 }
 
@@ -54,10 +75,12 @@ func (s *Server) handleWorkURNs(w http.ResponseWriter, r *http.Request) {
 
 	urns, _, err := s.parseCTSData(ctx, source)
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, URNResponse{
-			Status:  "Exception",
-			Service: "/texts",
-			Message: "Couldn't open connection.",
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, URNResponse{
+			RequestUrn: []string{},
+			Status:     "Exception",
+			Service:    "/texts",
+			Message:    msg,
 		})
 		return
 	}
@@ -75,6 +98,7 @@ func (s *Server) handleWorkURNs(w http.ResponseWriter, r *http.Request) {
 		Status:     "Success",
 		Service:    "/texts",
 		URN:        stems,
+		Version:    s.servedVersion(ctx, source),
 	})
 }
 
@@ -85,10 +109,10 @@ func (s *Server) firstOrLast(w http.ResponseWriter, r *http.Request, pickFirst b
 	ctx := r.Context()
 	cexName := chi.URLParam(r, "CEX")
 	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
-	reqURN := chi.URLParam(r, "URN")
+	reqURN := pathURN(r)
 
 	if !cite.IsCTSURN(reqURN) {
-		writeJSON(w, http.StatusBadRequest, NodeResponse{
+		s.writeError(w, r, http.StatusBadRequest, "", NodeResponse{
 			RequestUrn: []string{reqURN}, Status: "Exception", Service: servicePathFirstLast(pickFirst), Message: reqURN + " is not valid CTS.",
 		})
 		return
@@ -96,47 +120,29 @@ func (s *Server) firstOrLast(w http.ResponseWriter, r *http.Request, pickFirst b
 
 	allURNs, allTexts, err := s.parseCTSData(ctx, source)
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, NodeResponse{
-			RequestUrn: []string{reqURN}, Status: "Exception", Service: servicePathFirstLast(pickFirst), Message: "No results for " + reqURN,
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: servicePathFirstLast(pickFirst), Message: msg,
 		})
 		return
 	}
 
-	p := strings.Split(reqURN, ":")
-	if len(p) < 4 {
-		writeJSON(w, http.StatusOK, NodeResponse{
-			RequestUrn: []string{reqURN}, Status: "Exception", Service: servicePathFirstLast(pickFirst), Message: "No results for " + reqURN,
-		})
-		return
+	c := cts.Corpus{URNs: allURNs, Texts: allTexts}
+	first := c.First
+	if !pickFirst {
+		first = c.Last
 	}
-	stem := strings.Join(p[:4], ":") + ":"
-
-	wrk := buildWorkForStem(allURNs, stem)
-	if len(wrk.Passages) == 0 {
-		writeJSON(w, http.StatusOK, NodeResponse{
-			RequestUrn: []string{reqURN}, Status: "Exception", Service: servicePathFirstLast(pickFirst), Message: "No results for " + reqURN,
+	node, err := first(reqURN)
+	if err != nil {
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: servicePathFirstLast(pickFirst), Message: err.Error(),
 		})
 		return
 	}
 
-	var pass cite.Passage
-	if pickFirst {
-		pass = cite.GetFirst(wrk)
-	} else {
-		pass = cite.GetLast(wrk)
-	}
-
-	idx, _ := cite.GetIndexByID(pass.PassageID, wrk)
-	node := Node{
-		URN:      []string{pass.PassageID},
-		Text:     []string{textForID(pass.PassageID, allURNs, allTexts)},
-		Sequence: pass.Index,
-	}
-	ids := idsFromWork(wrk)
-	attachNeighbors(&node, ids, idx)
-
 	writeJSON(w, http.StatusOK, NodeResponse{
-		RequestUrn: []string{reqURN}, Status: "Success", Service: servicePathFirstLast(pickFirst), Nodes: []Node{node},
+		RequestUrn: []string{reqURN}, Status: "Success", Service: servicePathFirstLast(pickFirst), Nodes: []Node{node}, Version: s.servedVersion(ctx, source),
 	})
 }
 
@@ -147,56 +153,48 @@ func (s *Server) prevNext(w http.ResponseWriter, r *http.Request, wantNext bool)
 	ctx := r.Context()
 	cexName := chi.URLParam(r, "CEX")
 	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
-	reqURN := chi.URLParam(r, "URN")
+	reqURN := pathURN(r)
 	svc := "/texts/previous"
 	if wantNext {
 		svc = "/texts/next"
 	}
 
 	if !cite.IsCTSURN(reqURN) {
-		writeJSON(w, http.StatusBadRequest, NodeResponse{
+		s.writeError(w, r, http.StatusBadRequest, "", NodeResponse{
 			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: reqURN + " is not valid CTS.",
 		})
 		return
 	}
 	allURNs, allTexts, err := s.parseCTSData(ctx, source)
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, NodeResponse{
-			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: "No results for " + reqURN,
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: msg,
 		})
 		return
 	}
-	wrk := cite.Work{WorkID: "file"}
-	for i, u := range allURNs {
-		wrk.Passages = append(wrk.Passages, cite.Passage{PassageID: u, Index: i + 1})
-	}
-	wrk, _ = cite.SortPassages(wrk)
-
-	var target cite.Passage
+	c := cts.Corpus{URNs: allURNs, Texts: allTexts}
+	step := c.Prev
 	if wantNext {
-		target = cite.GetNext(reqURN, wrk)
-	} else {
-		target = cite.GetPrev(reqURN, wrk)
+		step = c.Next
 	}
-
-	if target.PassageID == "" {
-		writeJSON(w, http.StatusOK, NodeResponse{
-			RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Nodes: []Node{},
+	node, ok, err := step(reqURN)
+	if err != nil {
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: err.Error(),
 		})
 		return
 	}
-
-	idx, _ := cite.GetIndexByID(target.PassageID, wrk)
-	node := Node{
-		URN:      []string{target.PassageID},
-		Text:     []string{textForID(target.PassageID, allURNs, allTexts)},
-		Sequence: target.Index,
+	if !ok {
+		writeJSON(w, http.StatusOK, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Nodes: []Node{}, Version: s.servedVersion(ctx, source),
+		})
+		return
 	}
-	ids := idsFromWork(wrk)
-	attachNeighbors(&node, ids, idx)
 
 	writeJSON(w, http.StatusOK, NodeResponse{
-		RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Nodes: []Node{node},
+		RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Nodes: []Node{node}, Version: s.servedVersion(ctx, source),
 	})
 }
 
@@ -204,19 +202,31 @@ func (s *Server) handleURNs(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	cexName := chi.URLParam(r, "CEX")
 	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
-	reqURN := chi.URLParam(r, "URN")
+	reqURN := pathURN(r)
 	svc := "/texts/urns"
 
+	format, err := negotiateFormat(w, r, urnFormats...)
+	var pg page
+	if err == nil {
+		pg, err = s.pageOf(r.URL.Query())
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "", URNResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: err.Error(),
+		})
+		return
+	}
 	if !cite.IsCTSURN(reqURN) && !cite.IsRange(reqURN) {
-		writeJSON(w, http.StatusBadRequest, URNResponse{
+		s.writeError(w, r, http.StatusBadRequest, "", URNResponse{
 			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: reqURN + " is not valid CTS.",
 		})
 		return
 	}
 	allURNs, _, err := s.parseCTSData(ctx, source)
 	if err != nil {
-		writeJSON(w, http.StatusBadGateway, URNResponse{
-			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: "No results for " + reqURN,
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, URNResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: msg,
 		})
 		return
 	}
@@ -238,38 +248,36 @@ func (s *Server) handleURNs(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if startIdx == -1 || endIdx == -1 || startIdx > endIdx {
-			writeJSON(w, http.StatusOK, URNResponse{
+			s.writeError(w, r, http.StatusNotFound, "", URNResponse{
 				RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: "Couldn't find URN.",
 			})
 			return
 		}
-		writeJSON(w, http.StatusOK, URNResponse{
-			RequestUrn: []string{reqURN}, Status: "Success", Service: svc, URN: allURNs[startIdx : endIdx+1],
-		})
+		idx := make([]int, 0, endIdx-startIdx+1)
+		for i := startIdx; i <= endIdx; i++ {
+			idx = append(idx, i)
+		}
+		s.writeURNList(w, r, format, s.servedVersion(ctx, source), reqURN, allURNs, idx, pg)
 		return
 	}
 
-	for _, id := range allURNs {
+	for i, id := range allURNs {
 		if id == reqURN {
-			writeJSON(w, http.StatusOK, URNResponse{
-				RequestUrn: []string{reqURN}, Status: "Success", Service: svc, URN: []string{id},
-			})
+			s.writeURNList(w, r, format, s.servedVersion(ctx, source), reqURN, allURNs, []int{i}, pg)
 			return
 		}
 	}
-	var matches []string
-	for _, id := range allURNs {
+	var matches []int
+	for i, id := range allURNs {
 		if strings.HasPrefix(id, reqURN) {
-			matches = append(matches, id)
+			matches = append(matches, i)
 		}
 	}
 	if len(matches) == 0 {
-		writeJSON(w, http.StatusOK, URNResponse{
+		s.writeError(w, r, http.StatusNotFound, "", URNResponse{
 			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: "Couldn't find URN.",
 		})
 		return
 	}
-	writeJSON(w, http.StatusOK, URNResponse{
-		RequestUrn: []string{reqURN}, Status: "Success", Service: svc, URN: matches,
-	})
+	s.writeURNList(w, r, format, s.servedVersion(ctx, source), reqURN, allURNs, matches, pg)
 }
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Every change the write API makes to a passage is appended to <data_dir>/<name>.history.jsonl
+// once the new corpus is stored. The log is the audit trail and also lets handlePassage serve
+// the text as it was at an earlier time (?asOf=).
+
+type historyLog struct {
+	mu      sync.Mutex
+	path    string
+	records []ChangeRecord // in ID order
+}
+
+// historyFor returns the log of a stored corpus, reading it from disk on first use.
+func (s *Server) historyFor(source string) (*historyLog, error) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	if h, ok := s.histories[source]; ok {
+		return h, nil
+	}
+	h := &historyLog{path: strings.TrimSuffix(source, ".cex") + ".history.jsonl"}
+	f, err := os.Open(h.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 0, 64<<10), 16<<20)
+		for sc.Scan() {
+			var rec ChangeRecord
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				return nil, fmt.Errorf("%s: %w", h.path, err)
+			}
+			h.records = append(h.records, rec)
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	s.histories[source] = h
+	return h, nil
+}
+
+// append numbers recs, writes them durably and only then adds them to the in-memory log.
+func (h *historyLog) append(recs []ChangeRecord) error {
+	if len(recs) == 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	next := 1
+	if n := len(h.records); n > 0 {
+		next = h.records[n-1].ID + 1
+	}
+	var buf []byte
+	for i := range recs {
+		recs[i].ID = next + i
+		line, err := json.Marshal(recs[i])
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	h.records = append(h.records, recs...)
+	return nil
+}
+
+// forURN returns the changes to urn, or to every passage under it for a work or prefix URN.
+func (h *historyLog) forURN(urn string) []ChangeRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := []ChangeRecord{}
+	for _, rec := range h.records {
+		if urnWithin(rec.URN, urn) {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+func (h *historyLog) byID(id int) (ChangeRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, rec := range h.records {
+		if rec.ID == id {
+			return rec, true
+		}
+	}
+	return ChangeRecord{}, false
+}
+
+// since returns the changes recorded after t, oldest first.
+func (h *historyLog) since(t time.Time) []ChangeRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []ChangeRecord
+	for _, rec := range h.records {
+		if rec.Time.After(t) {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// textsAsOf rewinds a stored corpus to t by undoing the later changes, newest first: additions are
+// dropped, edits get their previous text back and removed passages are put back after the passage
+// they followed. Removals logged without a position (before it was recorded) stay removed.
+func textsAsOf(h *historyLog, t time.Time, urns, texts []string) ([]string, []string) {
+	recs := h.since(t)
+	if len(recs) == 0 {
+		return urns, texts
+	}
+	urns, texts = slices.Clone(urns), slices.Clone(texts)
+	for i := len(recs) - 1; i >= 0; i-- {
+		rec := recs[i]
+		at := slices.Index(urns, rec.URN)
+		switch {
+		case rec.Previous == nil:
+			if at >= 0 {
+				urns, texts = slices.Delete(urns, at, at+1), slices.Delete(texts, at, at+1)
+			}
+		case at >= 0:
+			texts[at] = *rec.Previous
+		case rec.After != nil:
+			pos := 0
+			if *rec.After != "" {
+				pos = slices.Index(urns, *rec.After) + 1
+				if pos == 0 {
+					pos = len(urns)
+				}
+			}
+			urns, texts = slices.Insert(urns, pos, rec.URN), slices.Insert(texts, pos, *rec.Previous)
+		}
+	}
+	return urns, texts
+}
+
+// parseAsOf accepts an RFC 3339 timestamp or a date (meaning the end of that day, UTC).
+func parseAsOf(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
+	}
+	if d, err := time.Parse("2006-01-02", v); err == nil {
+		return d.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return time.Time{}, fmt.Errorf("asOf %q is not an RFC 3339 timestamp or a YYYY-MM-DD date.", v)
+}
+
+// diffCorpora lists the passages added, edited or removed between old (nil for a new corpus) and c.
+func diffCorpora(old, c *corpus) []ChangeRecord {
+	var recs []ChangeRecord
+	before := map[string]string{}
+	if old != nil {
+		for i, u := range old.URNs {
+			before[u] = old.Texts[i]
+		}
+	}
+	after := make(map[string]bool, len(c.URNs))
+	for i, u := range c.URNs {
+		after[u] = true
+		text := c.Texts[i]
+		prev, ok := before[u]
+		switch {
+		case !ok:
+			recs = append(recs, ChangeRecord{URN: u, Action: "add", Text: &text})
+		case prev != text:
+			recs = append(recs, ChangeRecord{URN: u, Action: "edit", Previous: &prev, Text: &text})
+		}
+	}
+	if old != nil {
+		// a removal records the nearest kept passage before it, so that rewinding the removals of one
+		// change newest first restores their order
+		anchor := ""
+		for i, u := range old.URNs {
+			if after[u] {
+				anchor = u
+				continue
+			}
+			prev, follows := old.Texts[i], anchor
+			recs = append(recs, ChangeRecord{URN: u, Action: "remove", Previous: &prev, After: &follows})
+		}
+	}
+	return recs
+}
+
+// stamp fills in the fields shared by every record of one change.
+func stamp(recs []ChangeRecord, corpusName, author, reason, version string) []ChangeRecord {
+	now := time.Now().UTC()
+	for i := range recs {
+		recs[i].Time = now
+		recs[i].Corpus = corpusName
+		recs[i].Author = author
+		recs[i].Reason = reason
+		recs[i].Version = version
+	}
+	return recs
+}
+
+// handleHistory lists the recorded changes to a passage (or every passage under a work URN).
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	cexName := chi.URLParam(r, "CEX")
+	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
+	reqURN := pathURN(r)
+	svc := "/texts/history"
+
+	if !isStoredSource(s.cfg, source) {
+		writeJSON(w, http.StatusOK, HistoryResponse{
+			RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Changes: []ChangeRecord{},
+			Message: "Source is read-only; it has no edit history.",
+		})
+		return
+	}
+	h, err := s.historyFor(source)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "", HistoryResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Changes: []ChangeRecord{},
+			Message: "Couldn't read history: " + err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, HistoryResponse{
+		RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Changes: h.forURN(reqURN),
+	})
+}
+
+// handleRevert undoes one recorded edit by restoring the passage's previous text:
+// POST /admin/revert/{CEX}/{ID}, optionally with ?reason=.
+func (s *Server) handleRevert(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "CEX")
+	svc := "/admin/revert"
+	if !s.requireAdmin(w, r, svc) {
+		return
+	}
+	source := storedSource(s.cfg, name)
+	id, err := strconv.Atoi(chi.URLParam(r, "ID"))
+	if source == "" || err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: "Expected /admin/revert/{CEX}/{change id}."})
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	h, err := s.historyFor(source)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: "Couldn't read history: " + err.Error()})
+		return
+	}
+	rec, ok := h.byID(id)
+	if !ok {
+		s.writeError(w, r, http.StatusNotFound, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: fmt.Sprintf("No change %d for %s.", id, name)})
+		return
+	}
+	if rec.Previous == nil || rec.Text == nil {
+		s.writeError(w, r, http.StatusConflict, "", WriteResponse{
+			Status: "Exception", Service: svc, Corpus: name, URN: rec.URN,
+			Message: fmt.Sprintf("Change %d %ss the passage; only text changes can be reverted. Upload a corrected corpus instead.", id, rec.Action),
+		})
+		return
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		s.writeError(w, r, http.StatusNotFound, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: "Couldn't read " + name + "."})
+		return
+	}
+	updated, current, err := replacePassage(data, rec.URN, *rec.Previous, s.corpusConfig(source))
+	if err != nil {
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, WriteResponse{Status: "Exception", Service: svc, Corpus: name, URN: rec.URN, Message: err.Error()})
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = fmt.Sprintf("revert of change %d", id)
+	}
+	c, ok := s.commitCorpus(w, r, svc, name, source, updated, func(c *corpus) error {
+		return h.append(stamp([]ChangeRecord{{
+			URN: rec.URN, Action: "revert", Previous: &current, Text: rec.Previous, Reverts: id,
+		}}, name, "admin", reason, c.version))
+	})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, WriteResponse{Status: "Success", Service: svc, Corpus: name, URN: rec.URN, Version: c.version})
+}
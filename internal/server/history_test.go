@@ -0,0 +1,82 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/GhentCDH/annophis-text-service/cts"
+)
+
+func TestTextsAsOfRestoresRemovedPassages(t *testing.T) {
+	versions := []*corpus{
+		{Corpus: cts.Corpus{URNs: []string{"a", "b", "c", "d", "e"}, Texts: []string{"A", "B", "C", "D", "E"}}},
+		{Corpus: cts.Corpus{URNs: []string{"a", "d", "e", "f"}, Texts: []string{"A", "D2", "E", "F"}}}, // b, c removed; f added
+		{Corpus: cts.Corpus{URNs: []string{"d", "f"}, Texts: []string{"D3", "F"}}},                     // a, e removed
+		{}, // corpus deleted
+	}
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	h := &historyLog{}
+	for i := 1; i < len(versions); i++ {
+		recs := diffCorpora(versions[i-1], versions[i])
+		for j := range recs {
+			recs[j].ID = len(h.records) + 1
+			recs[j].Time = start.Add(time.Duration(i) * time.Hour)
+			h.records = append(h.records, recs[j])
+		}
+	}
+
+	last := versions[len(versions)-1]
+	for i, want := range versions {
+		urns, texts := textsAsOf(h, start.Add(time.Duration(i)*time.Hour), last.URNs, last.Texts)
+		if !slices.Equal(urns, want.URNs) || !slices.Equal(texts, want.Texts) {
+			t.Errorf("as of version %d: got %v %v, want %v %v", i, urns, texts, want.URNs, want.Texts)
+		}
+	}
+}
+
+func TestTextsAsOfWithoutRemovalPosition(t *testing.T) {
+	prev := "B"
+	h := &historyLog{records: []ChangeRecord{
+		{ID: 1, Time: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), URN: "b", Action: "remove", Previous: &prev},
+	}}
+	urns, texts := textsAsOf(h, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), []string{"a"}, []string{"A"})
+	if !slices.Equal(urns, []string{"a"}) || !slices.Equal(texts, []string{"A"}) {
+		t.Errorf("got %v %v, want the removal without a position left out", urns, texts)
+	}
+}
+
+func TestHistoryForURN(t *testing.T) {
+	h := &historyLog{}
+	for i, urn := range []string{"urn:cts:g:w.v:1.1", "urn:cts:g:w.v:1.10", "urn:cts:g:w.v:1.1.3", "urn:cts:g:w.v:2.1", "urn:cts:g:x.v:1.1"} {
+		h.records = append(h.records, ChangeRecord{ID: i + 1, URN: urn})
+	}
+	for _, c := range []struct {
+		urn  string
+		want []int
+	}{
+		{"urn:cts:g:w.v:1.1", []int{1, 3}},
+		{"urn:cts:g:w.v:1.", []int{1, 2, 3}},
+		{"urn:cts:g:w.v:1", []int{1, 2, 3}},
+		{"urn:cts:g:w.v:", []int{1, 2, 3, 4}},
+		{"urn:cts:g:w.v:1.1.3", []int{3}},
+		{"urn:cts:g:w.v:3", []int{}},
+	} {
+		ids := []int{}
+		for _, rec := range h.forURN(c.urn) {
+			ids = append(ids, rec.ID)
+		}
+		if !slices.Equal(ids, c.want) {
+			t.Errorf("forURN(%s) = %v, want %v", c.urn, ids, c.want)
+		}
+	}
+}
+
+func TestAsOfNeedsHistory(t *testing.T) {
+	_, h := newTestServer(t)
+	var p map[string]any
+	target := "/texts/" + hdt + "1.1?asOf=2026-03-01"
+	if code := getJSON(t, h, target, &p); code != 400 || p["code"] != codeInvalidParam {
+		t.Errorf("GET %s on a read-only source: %d %v, want 400 %s", target, code, p["code"], codeInvalidParam)
+	}
+}
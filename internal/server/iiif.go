@@ -0,0 +1,403 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const iiifPresentationContext = "http://iiif.io/api/presentation/3/context.json"
+
+// ---- IIIF Presentation 3 shapes (only what the manifest needs) ----
+
+type iiifLabel map[string][]string
+
+type iiifManifest struct {
+	Context string       `json:"@context"`
+	ID      string       `json:"id"`
+	Type    string       `json:"type"`
+	Label   iiifLabel    `json:"label"`
+	Items   []iiifCanvas `json:"items"`
+}
+
+type iiifCanvas struct {
+	ID          string               `json:"id"`
+	Type        string               `json:"type"`
+	Label       iiifLabel            `json:"label"`
+	Width       int                  `json:"width"`
+	Height      int                  `json:"height"`
+	Items       []iiifAnnotationPage `json:"items"`
+	Annotations []iiifAnnotationPage `json:"annotations,omitempty"`
+}
+
+type iiifAnnotationPage struct {
+	ID    string           `json:"id"`
+	Type  string           `json:"type"`
+	Items []iiifAnnotation `json:"items"`
+}
+
+type iiifAnnotation struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Motivation string `json:"motivation"`
+	Body       any    `json:"body"`
+	Target     string `json:"target"`
+}
+
+type iiifImageBody struct {
+	ID      string        `json:"id"`
+	Type    string        `json:"type"`
+	Format  string        `json:"format"`
+	Service []iiifService `json:"service"`
+}
+
+// iiifService uses "@id"/"@type" for Image API 2 services, as Presentation 3 requires.
+type iiifService struct {
+	ID         string `json:"id,omitempty"`
+	Type       string `json:"type,omitempty"`
+	LegacyID   string `json:"@id,omitempty"`
+	LegacyType string `json:"@type,omitempty"`
+	Profile    string `json:"profile"`
+}
+
+type iiifTextBody struct {
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	Format string `json:"format"`
+}
+
+// ---- CITE2 image URN → IIIF Image API ----
+
+// iiifTarget is a CITE2 image (without ROI) mapped onto its IIIF image service.
+type iiifTarget struct {
+	image   string
+	service string
+	roi     [4]float64 // fractional x, y, w, h
+	hasROI  bool
+}
+
+// iiifTargetFor maps "urn:cite2:ns:coll.v:obj@x,y,w,h" using the longest matching prefix in cfg.IIIF.Images.
+func (s *Server) iiifTargetFor(imageROI string) (iiifTarget, bool) {
+	image, roi, _ := strings.Cut(imageROI, "@")
+	base := ""
+	prefix := ""
+	for p, b := range s.cfg.IIIF.Images {
+		if strings.HasPrefix(image, p) && len(p) > len(prefix) {
+			prefix, base = p, b
+		}
+	}
+	if base == "" {
+		return iiifTarget{}, false
+	}
+	obj := image[strings.LastIndex(image, ":")+1:]
+	if obj == "" {
+		return iiifTarget{}, false
+	}
+	t := iiifTarget{
+		image:   image,
+		service: strings.TrimRight(base, "/") + "/" + url.PathEscape(obj),
+	}
+	if roi != "" {
+		parts := strings.Split(roi, ",")
+		if len(parts) == 4 {
+			ok := true
+			for i, p := range parts {
+				v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+				if err != nil {
+					ok = false
+					break
+				}
+				t.roi[i] = v
+			}
+			t.hasROI = ok
+		}
+	}
+	return t, true
+}
+
+func (t iiifTarget) region() string {
+	if !t.hasROI {
+		return "full"
+	}
+	pct := func(v float64) string {
+		return strconv.FormatFloat(math.Round(v*10000)/100, 'f', -1, 64)
+	}
+	return "pct:" + pct(t.roi[0]) + "," + pct(t.roi[1]) + "," + pct(t.roi[2]) + "," + pct(t.roi[3])
+}
+
+// iiifInfo is the part of an image's info.json the manifest needs.
+type iiifInfo struct {
+	width, height int
+	v2            bool
+	profile       string // "level0", "level1" or "level2"; "" if not declared
+}
+
+var iiifLevelRe = regexp.MustCompile(`level[012]`)
+
+// fetchIIIFInfo reads {service}/info.json (through the content cache); on failure it falls back to configured canvas size.
+func (s *Server) fetchIIIFInfo(ctx context.Context, service string) iiifInfo {
+	info := iiifInfo{width: s.cfg.IIIF.CanvasWidth, height: s.cfg.IIIF.CanvasHeight}
+	if info.width <= 0 || info.height <= 0 {
+		info.width, info.height = 1000, 1000
+	}
+	body, err := s.getContent(ctx, service+"/info.json")
+	if err != nil {
+		return info
+	}
+	var raw struct {
+		Context any    `json:"@context"`
+		Type    string `json:"type"`
+		Width   int    `json:"width"`
+		Height  int    `json:"height"`
+		Profile any    `json:"profile"` // "level2" (Image API 3) or ["http://iiif.io/api/image/2/level2.json", {...}]
+	}
+	if json.Unmarshal(body, &raw) != nil {
+		return info
+	}
+	if raw.Width > 0 && raw.Height > 0 {
+		info.width, info.height = raw.Width, raw.Height
+	}
+	info.v2 = raw.Type == "" && strings.Contains(fmt.Sprint(raw.Context), "/image/2/")
+	if p, ok := raw.Profile.([]any); ok && len(p) > 0 {
+		raw.Profile = p[0]
+	}
+	if p, ok := raw.Profile.(string); ok {
+		info.profile = iiifLevelRe.FindString(p)
+	}
+	return info
+}
+
+func (t iiifTarget) imageURL(region string, v2 bool) string {
+	size := "max"
+	if v2 {
+		size = "full"
+	}
+	return t.service + "/" + region + "/" + size + "/0/default.jpg"
+}
+
+// serviceRef describes the image service with the configured profile, else the one its info.json declares.
+func (s *Server) serviceRef(t iiifTarget, info iiifInfo) iiifService {
+	profile := s.cfg.IIIF.Profile
+	if profile == "" {
+		profile = info.profile
+	}
+	if profile == "" {
+		profile = "level1"
+	}
+	if info.v2 {
+		return iiifService{LegacyID: t.service, LegacyType: "ImageService2", Profile: profile}
+	}
+	return iiifService{ID: t.service, Type: "ImageService3", Profile: profile}
+}
+
+// ---- handlers ----
+
+// manifestLocation is the canonical URL of a manifest: base is the /{CEX}/iiif/manifest/{URN} route,
+// query keeps a pinned version of the default corpus, which has no {CEX} to carry it.
+type manifestLocation struct {
+	base, query string
+}
+
+func (l manifestLocation) String() string {
+	if l.query == "" {
+		return l.base
+	}
+	return l.base + "?" + l.query
+}
+
+// manifestURL is where the manifest of r dereferences to, also when the corpus was chosen with ?cex=.
+func (s *Server) manifestURL(r *http.Request) manifestLocation {
+	q := r.URL.Query()
+	cexName := chi.URLParam(r, "CEX")
+	if cexName == "" {
+		cexName = q.Get("cex")
+	}
+	path := "/iiif/manifest/" + url.PathEscape(pathURN(r))
+	v := strings.TrimSpace(q.Get("version"))
+	var query string
+	switch {
+	case cexName != "" && v != "" && !strings.Contains(cexName, "@"):
+		cexName += "@" + v
+	case cexName == "" && v != "":
+		query = url.Values{"version": {v}}.Encode()
+	}
+	if cexName != "" {
+		path = "/" + url.PathEscape(cexName) + path
+	}
+	return manifestLocation{base: publicBaseURL(s.cfg, r) + path, query: query}
+}
+
+// passageDSE resolves {URN} like /texts/{URN} and loads the DSE records of the same source.
+func (s *Server) passageDSE(r *http.Request) ([]Node, map[string][]DSERecord, error) {
+	ctx := r.Context()
+	cexName := chi.URLParam(r, "CEX")
+	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
+	reqURN := pathURN(r)
+
+	allURNs, allTexts, err := s.parseCTSData(ctx, source)
+	if err != nil {
+		return nil, nil, errSource(err)
+	}
+	nodes, err := resolvePassage(r, reqURN, allURNs, allTexts)
+	if err != nil {
+		return nil, nil, err
+	}
+	recs, err := s.parseDSE(ctx, source)
+	if err != nil {
+		return nil, nil, errSource(err)
+	}
+	return nodes, dseByPassage(recs), nil
+}
+
+func (s *Server) handleIIIFImages(w http.ResponseWriter, r *http.Request) {
+	reqURN := pathURN(r)
+	svc := "/iiif/images"
+
+	nodes, byPassage, err := s.passageDSE(r)
+	if err != nil {
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, ImageResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: err.Error(),
+		})
+		return
+	}
+
+	infos := map[string]iiifInfo{}
+	images := []IIIFImage{}
+	for _, n := range nodes {
+		for _, rec := range byPassage[n.URN[0]] {
+			t, ok := s.iiifTargetFor(rec.ImageROI)
+			if !ok {
+				continue
+			}
+			info, seen := infos[t.service]
+			if !seen {
+				info = s.fetchIIIFInfo(r.Context(), t.service)
+				infos[t.service] = info
+			}
+			images = append(images, IIIFImage{
+				Passage: n.URN[0],
+				Image:   rec.ImageROI,
+				Region:  t.region(),
+				Service: t.service,
+				URL:     t.imageURL(t.region(), info.v2),
+			})
+		}
+	}
+	if len(images) == 0 {
+		s.writeError(w, r, http.StatusNotFound, "", ImageResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: "No IIIF images for " + reqURN,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, ImageResponse{
+		RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Images: images,
+	})
+}
+
+// handleIIIFManifest builds a Presentation 3 manifest with one canvas per image, in passage
+// order, and the passage texts as commenting annotations on their image regions.
+func (s *Server) handleIIIFManifest(w http.ResponseWriter, r *http.Request) {
+	reqURN := pathURN(r)
+	svc := "/iiif/manifest"
+
+	nodes, byPassage, err := s.passageDSE(r)
+	if err != nil {
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: err.Error(),
+		})
+		return
+	}
+
+	manifestURL := s.manifestURL(r)
+	m := iiifManifest{
+		Context: iiifPresentationContext,
+		ID:      manifestURL.String(),
+		Type:    "Manifest",
+		Label:   iiifLabel{"none": {reqURN}},
+		Items:   []iiifCanvas{},
+	}
+	canvasFor := map[string]int{} // image URN → index in m.Items
+	infos := map[int]iiifInfo{}
+
+	for _, n := range nodes {
+		text := ""
+		if len(n.Text) > 0 {
+			text = n.Text[0]
+		}
+		for _, rec := range byPassage[n.URN[0]] {
+			t, ok := s.iiifTargetFor(rec.ImageROI)
+			if !ok {
+				continue
+			}
+			ci, seen := canvasFor[t.image]
+			if !seen {
+				ci = len(m.Items)
+				canvasFor[t.image] = ci
+				info := s.fetchIIIFInfo(r.Context(), t.service)
+				infos[ci] = info
+				canvasID := fmt.Sprintf("%s/canvas/%d", manifestURL.base, ci+1)
+				m.Items = append(m.Items, iiifCanvas{
+					ID:     canvasID,
+					Type:   "Canvas",
+					Label:  iiifLabel{"none": {t.image}},
+					Width:  info.width,
+					Height: info.height,
+					Items: []iiifAnnotationPage{{
+						ID:   canvasID + "/page",
+						Type: "AnnotationPage",
+						Items: []iiifAnnotation{{
+							ID:         canvasID + "/page/image",
+							Type:       "Annotation",
+							Motivation: "painting",
+							Body: iiifImageBody{
+								ID:      t.imageURL("full", info.v2),
+								Type:    "Image",
+								Format:  "image/jpeg",
+								Service: []iiifService{s.serviceRef(t, info)},
+							},
+							Target: canvasID,
+						}},
+					}},
+				})
+			}
+
+			c := &m.Items[ci]
+			if len(c.Annotations) == 0 {
+				c.Annotations = []iiifAnnotationPage{{ID: c.ID + "/annotations", Type: "AnnotationPage"}}
+			}
+			target := c.ID
+			if t.hasROI {
+				info := infos[ci]
+				target += fmt.Sprintf("#xywh=%d,%d,%d,%d",
+					int(t.roi[0]*float64(info.width)), int(t.roi[1]*float64(info.height)),
+					int(t.roi[2]*float64(info.width)), int(t.roi[3]*float64(info.height)))
+			}
+			page := &c.Annotations[0]
+			page.Items = append(page.Items, iiifAnnotation{
+				ID:         fmt.Sprintf("%s/%d", page.ID, len(page.Items)+1),
+				Type:       "Annotation",
+				Motivation: "commenting",
+				Body:       iiifTextBody{Type: "TextualBody", Value: text, Format: "text/plain"},
+				Target:     target,
+			})
+		}
+	}
+
+	if len(m.Items) == 0 {
+		s.writeError(w, r, http.StatusNotFound, "", NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: "No IIIF images for " + reqURN,
+		})
+		return
+	}
+	writeJSONAs(w, http.StatusOK, `application/ld+json;profile="`+iiifPresentationContext+`"`, m)
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Linked data renderings: JSON-LD for /texts/catalog and /texts/{URN} using the DTS vocabulary with
+// Dublin Core and Schema.org terms, and Turtle for the whole catalog. CTS URNs are the @ids; every
+// resource also carries a dereferenceable url on this service.
+
+var (
+	formatJSONLD = outputFormat{"jsonld", []string{"application/ld+json"}}
+	formatTurtle = outputFormat{"turtle", []string{"text/turtle"}}
+)
+
+const (
+	nsDTS    = "https://w3id.org/dts/api#"
+	nsDC     = "http://purl.org/dc/terms/"
+	nsSchema = "https://schema.org/"
+	nsHydra  = "http://www.w3.org/ns/hydra/core#"
+)
+
+// ldContext maps the response keys onto the vocabularies; keys it does not name fall in DTS.
+var ldContext = map[string]any{
+	"@vocab":     nsDTS,
+	"dts":        nsDTS,
+	"dc":         nsDC,
+	"schema":     nsSchema,
+	"hydra":      nsHydra,
+	"title":      "dc:title",
+	"creator":    "dc:creator",
+	"edition":    "schema:bookEdition",
+	"isPartOf":   "dc:isPartOf",
+	"url":        map[string]string{"@id": "schema:url", "@type": "@id"},
+	"text":       "schema:text",
+	"position":   "schema:position",
+	"previous":   map[string]string{"@id": "schema:previousItem", "@type": "@id"},
+	"next":       map[string]string{"@id": "schema:nextItem", "@type": "@id"},
+	"parent":     map[string]string{"@id": "dts:parent", "@type": "@id"},
+	"resource":   map[string]string{"@id": "dts:resource", "@type": "@id"},
+	"member":     "hydra:member",
+	"totalItems": "hydra:totalItems",
+	"version":    "schema:version",
+}
+
+// ---- JSON-LD shapes ----
+
+type ldCatalog struct {
+	Context    map[string]any `json:"@context"`
+	ID         string         `json:"@id"`
+	Type       string         `json:"@type"`
+	Title      string         `json:"title"`
+	TotalItems int            `json:"totalItems"`
+	Member     []ldText       `json:"member"`
+	Version    string         `json:"version,omitempty"`
+}
+
+// ldText is a catalogued version (or exemplar) of a work.
+type ldText struct {
+	ID            string            `json:"@id"`
+	Type          string            `json:"@type"`
+	Title         string            `json:"title"`
+	Creator       string            `json:"creator,omitempty"`
+	Edition       string            `json:"edition,omitempty"`
+	URL           string            `json:"url"`
+	Online        bool              `json:"online"`
+	CiteStructure []ldCiteStructure `json:"citeStructure,omitempty"`
+	IsPartOf      *ldCollection     `json:"isPartOf,omitempty"`
+}
+
+// ldCollection is a work or textgroup.
+type ldCollection struct {
+	ID       string        `json:"@id"`
+	Type     string        `json:"@type"`
+	Title    string        `json:"title,omitempty"`
+	IsPartOf *ldCollection `json:"isPartOf,omitempty"`
+}
+
+type ldCiteStructure struct {
+	Type          string            `json:"@type"`
+	CiteType      string            `json:"citeType"`
+	CiteStructure []ldCiteStructure `json:"citeStructure,omitempty"`
+}
+
+// ldNavigation is the envelope of a passage request; its members are streamed.
+type ldNavigation struct {
+	Context  map[string]any `json:"@context"`
+	ID       string         `json:"@id"`
+	Type     string         `json:"@type"`
+	Resource string         `json:"resource,omitempty"`
+}
+
+type ldCitableUnit struct {
+	ID         string `json:"@id"`
+	Type       string `json:"@type"`
+	Identifier string `json:"identifier"`
+	Level      int    `json:"level"`
+	CiteType   string `json:"citeType,omitempty"`
+	Parent     string `json:"parent,omitempty"`
+	URL        string `json:"url"`
+	Text       string `json:"text"`
+	Position   int    `json:"position"`
+	Previous   string `json:"previous,omitempty"`
+	Next       string `json:"next,omitempty"`
+	Complete   bool   `json:"complete"`
+}
+
+// ---- URN hierarchy ----
+
+// ctsLevels returns the textgroup, work, version and exemplar URNs a work-level URN belongs to,
+// as far as its work component goes ("urn:cts:greekLit:tlg0016.tlg001.eng:" has three).
+func ctsLevels(stem string) []string {
+	p := strings.Split(stem, ":")
+	if len(p) < 4 || p[3] == "" {
+		return nil
+	}
+	parts := strings.Split(p[3], ".")
+	out := make([]string, len(parts))
+	for i := range parts {
+		out[i] = strings.Join(p[:3], ":") + ":" + strings.Join(parts[:i+1], ".") + ":"
+	}
+	return out
+}
+
+func citeStructure(scheme string) []ldCiteStructure {
+	if scheme == "" {
+		return nil
+	}
+	levels := strings.Split(scheme, ".")
+	var cs []ldCiteStructure
+	for i := len(levels) - 1; i >= 0; i-- {
+		cs = []ldCiteStructure{{Type: "CiteStructure", CiteType: levels[i], CiteStructure: cs}}
+	}
+	return cs
+}
+
+// ldTextFor describes a catalog entry with its work and textgroup.
+func ldTextFor(e CatalogEntry, link func(urn string) string) ldText {
+	t := ldText{
+		ID: e.URN, Type: "Resource", Title: e.WorkTitle, Creator: e.GroupName,
+		Edition: strings.TrimSpace(e.VersionLabel + " " + e.ExemplarLabel),
+		URL:     link(e.URN), Online: e.Online, CiteStructure: citeStructure(e.CitationScheme),
+	}
+	levels := ctsLevels(e.URN)
+	if len(levels) >= 2 {
+		t.IsPartOf = &ldCollection{
+			ID: levels[1], Type: "Collection", Title: e.WorkTitle,
+			IsPartOf: &ldCollection{ID: levels[0], Type: "Collection", Title: e.GroupName},
+		}
+		if len(levels) == 4 {
+			t.IsPartOf = &ldCollection{ID: levels[2], Type: "Resource", Title: e.VersionLabel, IsPartOf: t.IsPartOf}
+		}
+	}
+	return t
+}
+
+// corpusBaseURL is the external URL of the corpus the request addresses, without a trailing slash.
+func (s *Server) corpusBaseURL(r *http.Request) string {
+	base := publicBaseURL(s.cfg, r)
+	if cexName := chi.URLParam(r, "CEX"); cexName != "" {
+		base += "/" + url.PathEscape(cexName)
+	}
+	return base
+}
+
+// textLink returns a function giving the dereferenceable URL of a URN on this corpus.
+func (s *Server) textLink(r *http.Request) func(urn string) string {
+	base := s.corpusBaseURL(r) + "/texts/"
+	return func(urn string) string { return base + url.PathEscape(urn) }
+}
+
+// ---- handlers ----
+
+// writeCatalogLD sends the catalog as a JSON-LD collection or as Turtle.
+func (s *Server) writeCatalogLD(w http.ResponseWriter, r *http.Request, format outputFormat, version string, entries []CatalogEntry) {
+	link := s.textLink(r)
+	id := s.corpusBaseURL(r) + "/texts/catalog"
+	if version != "" {
+		w.Header().Set("X-Corpus-Version", version)
+	}
+	if format.name == formatTurtle.name {
+		w.Header().Set("Content-Type", format.contentType())
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(catalogTurtle(id, version, entries, link)))
+		return
+	}
+	doc := ldCatalog{
+		Context: ldContext, ID: id, Type: "Collection", Title: "Catalog",
+		TotalItems: len(entries), Member: make([]ldText, 0, len(entries)), Version: version,
+	}
+	for _, e := range entries {
+		doc.Member = append(doc.Member, ldTextFor(e, link))
+	}
+	writeJSONAs(w, http.StatusOK, format.contentType(), doc)
+}
+
+// streamPassageLD streams the passages each resolves reqURN to as DTS citable units.
+func (s *Server) streamPassageLD(w http.ResponseWriter, r *http.Request, source, reqURN string, each func(emit func(Node) error) error) {
+	ctx := r.Context()
+	svc := "/texts"
+	version := s.servedVersion(ctx, source)
+	link := s.textLink(r)
+	catalog, _ := s.parseCTSCatalog(ctx, source)
+	schemes := map[string][]string{}
+	for _, e := range catalog {
+		if e.CitationScheme != "" {
+			schemes[e.URN] = strings.Split(e.CitationScheme, ".")
+		}
+	}
+
+	var list *jsonListWriter
+	err := each(func(n Node) error {
+		if list == nil {
+			if version != "" {
+				w.Header().Set("X-Corpus-Version", version)
+			}
+			var err error
+			list, err = startJSONListAs(w, http.StatusOK, formatJSONLD.contentType(), ldNavigation{
+				Context: ldContext, ID: link(reqURN), Type: "Navigation", Resource: workStem(reqURN),
+			}, "member")
+			if err != nil {
+				return err
+			}
+		}
+		return list.item(citableUnit(n, schemes, link))
+	})
+	switch {
+	case list != nil:
+		list.close(version)
+	case err != nil:
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: err.Error(),
+		})
+	default:
+		writeJSON(w, http.StatusOK, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Version: version,
+		})
+	}
+}
+
+func citableUnit(n Node, schemes map[string][]string, link func(urn string) string) ldCitableUnit {
+	urn := n.URN[0]
+	ref := passageRef(urn)
+	u := ldCitableUnit{
+		ID: urn, Type: "CitableUnit", Identifier: strings.Join(ref, "."), Level: len(ref),
+		URL: link(urn), Text: strings.Join(n.Text, " "), Position: n.Sequence, Complete: n.Complete,
+	}
+	if scheme := schemes[workStem(urn)]; len(ref) > 0 && len(ref) <= len(scheme) {
+		u.CiteType = scheme[len(ref)-1]
+	}
+	if len(ref) > 1 {
+		u.Parent = workStem(urn) + strings.Join(ref[:len(ref)-1], ".")
+	}
+	if len(n.Previous) > 0 {
+		u.Previous = n.Previous[0]
+	}
+	if len(n.Next) > 0 {
+		u.Next = n.Next[0]
+	}
+	return u
+}
+
+// ---- Turtle ----
+
+// catalogTurtle describes every textgroup, work and catalogued version with its citation scheme.
+func catalogTurtle(id, version string, entries []CatalogEntry, link func(urn string) string) string {
+	var b strings.Builder
+	for _, p := range [][2]string{{"dts", nsDTS}, {"dc", nsDC}, {"schema", nsSchema}, {"hydra", nsHydra}} {
+		fmt.Fprintf(&b, "@prefix %s: <%s> .\n", p[0], p[1])
+	}
+	b.WriteString("\n" + ttlIRI(id) + " a dts:Collection ;\n    dc:title \"Catalog\"")
+	if version != "" {
+		b.WriteString(" ;\n    schema:version " + ttlString(version))
+	}
+	for _, e := range entries {
+		b.WriteString(" ;\n    hydra:member " + ttlIRI(e.URN))
+	}
+	b.WriteString(" .\n")
+
+	seen := map[string]bool{}
+	for _, e := range entries {
+		levels := ctsLevels(e.URN)
+		titles := []string{e.GroupName, e.WorkTitle, e.VersionLabel, e.ExemplarLabel}
+		for i, lv := range levels {
+			if seen[lv] {
+				continue
+			}
+			seen[lv] = true
+			b.WriteString("\n" + ttlIRI(lv))
+			if lv == e.URN {
+				b.WriteString(" a dts:Resource")
+			} else {
+				b.WriteString(" a dts:Collection")
+			}
+			if i < len(titles) && titles[i] != "" {
+				b.WriteString(" ;\n    dc:title " + ttlString(titles[i]))
+			}
+			if i > 0 {
+				b.WriteString(" ;\n    dc:isPartOf " + ttlIRI(levels[i-1]))
+			}
+			if lv == e.URN {
+				if e.WorkTitle != "" && i > 1 {
+					b.WriteString(" ;\n    schema:name " + ttlString(e.WorkTitle))
+				}
+				if e.GroupName != "" {
+					b.WriteString(" ;\n    dc:creator " + ttlString(e.GroupName))
+				}
+				if ed := strings.TrimSpace(e.VersionLabel + " " + e.ExemplarLabel); ed != "" {
+					b.WriteString(" ;\n    schema:bookEdition " + ttlString(ed))
+				}
+				b.WriteString(" ;\n    schema:url " + ttlIRI(link(e.URN)))
+				fmt.Fprintf(&b, " ;\n    dts:online %t", e.Online)
+				if cs := citeStructure(e.CitationScheme); cs != nil {
+					b.WriteString(" ;\n    dts:citeStructure " + ttlCiteStructure(cs[0], 1))
+				}
+			}
+			b.WriteString(" .\n")
+		}
+	}
+	return b.String()
+}
+
+func ttlCiteStructure(cs ldCiteStructure, depth int) string {
+	pad := strings.Repeat("    ", depth+1)
+	out := "[\n" + pad + "a dts:CiteStructure ;\n" + pad + "dts:citeType " + ttlString(cs.CiteType)
+	if len(cs.CiteStructure) > 0 {
+		out += " ;\n" + pad + "dts:citeStructure " + ttlCiteStructure(cs.CiteStructure[0], depth+1)
+	}
+	return out + "\n" + strings.Repeat("    ", depth) + "]"
+}
+
+// ttlIRI writes an IRI reference, percent-encoding what Turtle does not allow inside <>.
+func ttlIRI(iri string) string {
+	var b strings.Builder
+	b.WriteByte('<')
+	for _, r := range iri {
+		if r <= 0x20 || strings.ContainsRune(`<>"{}|^`+"`"+`\`, r) {
+			fmt.Fprintf(&b, "%%%02X", r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+var ttlEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func ttlString(s string) string { return `"` + ttlEscaper.Replace(s) + `"` }
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Local answers read requests in-process against a single CEX source, without a listener. The
+// command-line tool goes through it so that its output is exactly what the service would send.
+type Local struct {
+	h http.Handler
+}
+
+// NewLocal serves source (a path, URL or archive member, as for cex_source) as the default corpus.
+func NewLocal(source string, cc CorpusConfig) (*Local, error) {
+	s := NewServer(ServerConfig{TestSource: source, Delimiter: cc.Delimiter, Encoding: cc.Encoding})
+	s.quiet = true
+	h, err := BuildRouter(s)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{h: h}, nil
+}
+
+// Get requests path and returns the status, headers and body as the service would answer them.
+// path is escaped as in a request line, e.g. "/texts/" + url.PathEscape(urn), so that URNs with a
+// "/" or "?" in an anchor reach the handlers whole.
+func (l *Local) Get(ctx context.Context, path string, q url.Values) (int, http.Header, []byte) {
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		return http.StatusBadRequest, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, []byte(err.Error())
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	req.URL = &url.URL{Path: unescaped, RawPath: path, RawQuery: q.Encode()}
+	req.RequestURI = req.URL.RequestURI()
+	rec := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	l.h.ServeHTTP(rec, req)
+	return rec.status, rec.header, rec.body.Bytes()
+}
@@ -0,0 +1,656 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// OpenAPI 3.1 description of the API, served at /openapi.json. Operations are listed in apiOperations;
+// their JSON schemas are generated from the response types in types.go, so the field names and
+// optionality in the spec are the ones the handlers actually encode. BuildRouter returns an error when
+// a registered route is missing from the spec (or the other way round), and with check_responses set
+// every JSON response is validated against its schema before it is sent.
+
+// apiOperation documents one route. Corpus routes are served both at the base and under /{CEX}.
+type apiOperation struct {
+	method, path string
+	corpus       bool
+	summary      string
+	params       []apiParam
+	body         map[string]any // request body media type -> schema
+	envelope     any            // JSON envelope of successes and errors; nil for an untyped object
+	formats      []outputFormat // representations of a success, if not just the JSON envelope
+	negotiated   bool           // formats are chosen by ?format= or Accept
+	created      bool           // may answer 201
+	security     string         // "writeToken" or "adminToken"
+}
+
+type apiParam struct {
+	name, in, typ, desc string
+}
+
+var (
+	urnParam = apiParam{"URN", "path", "string", "CTS URN: a passage, prefix, range or anchored URN."}
+
+	textFilterParams = []apiParam{
+		{"substring", "query", "string", "With clip=true, return a window around the first case-insensitive match."},
+		{"clip", "query", "boolean", "Return a snippet instead of the whole passage (default true for anchored URNs)."},
+		{"context", "query", "integer", "Runes of context around the match."},
+		{"maxChars", "query", "integer", "Hard cap on the text length; truncated passages have complete=false."},
+		{"tail", "query", "boolean", "With anchored URNs, return from the match to the end of the passage."},
+	}
+
+	pageParams = []apiParam{
+		{"limit", "query", "integer", "Return at most this many items (capped by max_page_size); the response then has total and next."},
+		{"offset", "query", "integer", "Skip this many items."},
+		{"cursor", "query", "string", "Continue a paged listing; taken from the next link."},
+	}
+
+	exportFormats = []outputFormat{
+		{"cex", []string{"text/plain"}},
+		{"epub", []string{"application/epub+zip"}},
+		{"print", []string{"text/html"}},
+	}
+
+	passageEditSchema = map[string]any{
+		"type":     "object",
+		"required": []string{"text"},
+		"properties": map[string]any{
+			"text":   map[string]any{"type": "string"},
+			"reason": map[string]any{"type": "string"},
+		},
+	}
+
+	batchItemSchema = map[string]any{
+		"type":     "object",
+		"required": []string{"urn"},
+		"properties": map[string]any{
+			"urn":       map[string]any{"type": "string"},
+			"substring": map[string]any{"type": "string"},
+			"clip":      map[string]any{"type": "boolean"},
+			"context":   map[string]any{"type": "integer"},
+			"maxChars":  map[string]any{"type": "integer"},
+			"tail":      map[string]any{"type": "boolean"},
+		},
+	}
+
+	graphQLRequestSchema = map[string]any{
+		"type":     "object",
+		"required": []string{"query"},
+		"properties": map[string]any{
+			"query":         map[string]any{"type": "string"},
+			"operationName": map[string]any{"type": "string"},
+			"variables":     map[string]any{"type": "object"},
+		},
+	}
+)
+
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/", summary: "Service and data versions", envelope: CITEResponse{}},
+	{method: "GET", path: "/cite", summary: "Service and data versions", envelope: CITEResponse{}},
+	{method: "GET", path: "/texts/version", summary: "Text service version", envelope: VersionResponse{}},
+	{method: "GET", path: "/openapi.json", summary: "This OpenAPI description"},
+	{method: "GET", path: "/healthz", summary: "Whether the default source is reachable", envelope: HealthResponse{}},
+
+	{method: "GET", path: "/texts", corpus: true, summary: "Work stems", envelope: URNResponse{}},
+	{method: "GET", path: "/texts/catalog", corpus: true, summary: "The #!ctscatalog entries", envelope: CatalogResponse{}, formats: catalogFormats, negotiated: true},
+	{method: "GET", path: "/texts/validate", corpus: true, summary: "Line-numbered CEX diagnostics", envelope: ValidationResponse{}},
+	{method: "GET", path: "/texts/first/{URN}", corpus: true, summary: "First passage of a work", params: []apiParam{urnParam}, envelope: NodeResponse{}},
+	{method: "GET", path: "/texts/last/{URN}", corpus: true, summary: "Last passage of a work", params: []apiParam{urnParam}, envelope: NodeResponse{}},
+	{method: "GET", path: "/texts/previous/{URN}", corpus: true, summary: "Passage before a URN", params: []apiParam{urnParam}, envelope: NodeResponse{}},
+	{method: "GET", path: "/texts/next/{URN}", corpus: true, summary: "Passage after a URN", params: []apiParam{urnParam}, envelope: NodeResponse{}},
+	{method: "GET", path: "/texts/urns/{URN}", corpus: true, summary: "Concrete URNs of a URN or range", params: append([]apiParam{urnParam}, pageParams...), envelope: URNResponse{}, formats: urnFormats, negotiated: true},
+	{method: "GET", path: "/texts/history/{URN}", corpus: true, summary: "Recorded changes to a passage or work", params: []apiParam{urnParam}, envelope: HistoryResponse{}},
+	{method: "GET", path: "/texts/export/{URN}", corpus: true, summary: "A URN selection as CEX, EPUB or print HTML",
+		params: []apiParam{
+			{"URN", "path", "string", "Comma-separated CTS URNs."},
+			{"urn", "query", "array", "Further URNs to export."},
+			{"lang", "query", "string", "Document language of EPUB and print exports (default und)."},
+		},
+		envelope: NodeResponse{}, formats: exportFormats, negotiated: true},
+	{method: "GET", path: "/texts/search", corpus: true, summary: "Search the passage texts",
+		params: append([]apiParam{
+			{"q", "query", "string", "Case-insensitive substring, or a regular expression written /.../."},
+			{"within", "query", "string", "Only passages whose URN starts with this prefix."},
+			{"context", "query", "integer", "Runes of context either side of a hit (default 40)."},
+		}, pageParams...),
+		envelope: SearchResponse{}, formats: searchFormats, negotiated: true},
+	{method: "GET", path: "/texts/{URN}", corpus: true, summary: "Passages of a URN",
+		params: append([]apiParam{urnParam,
+			{"asOf", "query", "string", "Serve the passage as it was at this time (RFC 3339 timestamp or date)."},
+			{"labels", "query", "string", "Text format only: true or ref to prefix each passage with its reference, urn for its URN."},
+		}, append(textFilterParams, pageParams...)...),
+		envelope: NodeResponse{}, formats: passageFormats, negotiated: true},
+	{method: "POST", path: "/texts/batch", corpus: true, summary: "Passages of many URNs from one corpus version",
+		body: map[string]any{"application/json": map[string]any{
+			"type": "array", "maxItems": maxBatchItems,
+			"items": map[string]any{"anyOf": []any{
+				map[string]any{"type": "string", "description": "A CTS URN."},
+				batchItemSchema,
+			}},
+		}},
+		envelope: BatchResponse{}},
+	{method: "PUT", path: "/texts/{URN}", corpus: true, summary: "Add or replace a passage of a stored corpus",
+		params:   []apiParam{urnParam, {"reason", "query", "string", "Reason recorded in the edit history."}},
+		body:     map[string]any{"application/json": passageEditSchema, "text/plain": map[string]any{"type": "string"}},
+		envelope: WriteResponse{}, security: "writeToken"},
+	{method: "PATCH", path: "/texts/{URN}", corpus: true, summary: "Add or replace a passage of a stored corpus",
+		params:   []apiParam{urnParam, {"reason", "query", "string", "Reason recorded in the edit history."}},
+		body:     map[string]any{"application/json": passageEditSchema, "text/plain": map[string]any{"type": "string"}},
+		envelope: WriteResponse{}, security: "writeToken"},
+	{method: "GET", path: "/iiif/manifest/{URN}", corpus: true, summary: "IIIF Presentation 3 manifest of a passage's images", params: []apiParam{urnParam},
+		envelope: NodeResponse{}, formats: []outputFormat{{"iiif", []string{"application/ld+json"}}, formatJSON}},
+	{method: "GET", path: "/iiif/images/{URN}", corpus: true, summary: "IIIF image regions of a passage", params: []apiParam{urnParam}, envelope: ImageResponse{}},
+	{method: "GET", path: "/orca/text/{URN}", corpus: true, summary: "ORCA alignments of a text passage", params: []apiParam{urnParam}, envelope: ORCAResponse{}},
+	{method: "GET", path: "/orca/analysis/{URN}", corpus: true, summary: "ORCA alignment by analysis URN", params: []apiParam{urnParam}, envelope: ORCAResponse{}},
+	{method: "GET", path: "/graphql", corpus: true, summary: "GraphQL query over the catalog, passages and annotations",
+		params: []apiParam{
+			{"query", "query", "string", "GraphQL query document."},
+			{"operationName", "query", "string", "Operation to run when the document has several."},
+			{"variables", "query", "string", "Variables as a JSON object."},
+		}},
+	{method: "POST", path: "/graphql", corpus: true, summary: "GraphQL query over the catalog, passages and annotations",
+		body: map[string]any{"application/json": graphQLRequestSchema}},
+
+	{method: "PUT", path: "/corpora/{CEX}", summary: "Upload or replace a stored corpus",
+		params:   []apiParam{{"CEX", "path", "string", "Corpus name."}},
+		body:     map[string]any{"text/plain": map[string]any{"type": "string", "description": "The CEX."}},
+		envelope: WriteResponse{}, created: true, security: "writeToken"},
+	{method: "DELETE", path: "/corpora/{CEX}", summary: "Delete a stored corpus",
+		params:   []apiParam{{"CEX", "path", "string", "Corpus name."}},
+		envelope: WriteResponse{}, security: "writeToken"},
+	{method: "POST", path: "/admin/git/ref", summary: "Switch the git repository to another ref",
+		params: []apiParam{{"ref", "query", "string", "Branch, tag or commit; may be given in the body instead."}},
+		body: map[string]any{"application/json": map[string]any{
+			"type": "object", "properties": map[string]any{"ref": map[string]any{"type": "string"}},
+		}},
+		envelope: AdminResponse{}, security: "adminToken"},
+	{method: "POST", path: "/admin/revert/{CEX}/{ID}", summary: "Undo a recorded change",
+		params: []apiParam{
+			{"CEX", "path", "string", "Corpus name."},
+			{"ID", "path", "integer", "Change id from the edit history."},
+			{"reason", "query", "string", "Reason recorded in the edit history."},
+		},
+		envelope: WriteResponse{}, security: "adminToken"},
+}
+
+// ---- document ----
+
+// buildOpenAPI returns the OpenAPI document for ops.
+func buildOpenAPI(ops []apiOperation) map[string]any {
+	g := &schemaGen{defs: map[string]any{}}
+	paths := map[string]any{}
+	add := func(path string, op map[string]any, method string) {
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(method)] = op
+	}
+	for _, o := range ops {
+		if !o.corpus {
+			add(o.path, g.operation(o, nil), o.method)
+			continue
+		}
+		add(o.path, g.operation(o, []apiParam{
+			{"cex", "query", "string", "Corpus name under a directory base (instead of /{CEX})."},
+			{"version", "query", "string", "Serve a kept earlier corpus version."},
+		}), o.method)
+		add("/{CEX}"+o.path, g.operation(o, []apiParam{
+			{"CEX", "path", "string", "Corpus name under a directory base, optionally pinned as name@version."},
+			{"version", "query", "string", "Serve a kept earlier corpus version."},
+		}), o.method)
+	}
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "annophis-text-service",
+			"version":     "1.0",
+			"description": "CTS texts from CEX files. Responses are JSON envelopes with status Success; errors are application/problem+json (RFC 7807), or Exception envelopes with API-Version: 1 or legacy_errors.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.defs,
+			"securitySchemes": map[string]any{
+				"writeToken": map[string]any{"type": "http", "scheme": "bearer", "description": "A token from write_tokens."},
+				"adminToken": map[string]any{"type": "http", "scheme": "bearer", "description": "The admin_token."},
+			},
+		},
+	}
+}
+
+func (g *schemaGen) operation(o apiOperation, corpusParams []apiParam) map[string]any {
+	var envelope map[string]any
+	if o.envelope != nil {
+		envelope = g.schema(reflect.TypeOf(o.envelope))
+	} else {
+		envelope = map[string]any{"type": "object"}
+	}
+	problem := g.problem(reflect.TypeOf(o.envelope))
+
+	params := append(slices.Clone(corpusParams), o.params...)
+	formats := o.formats
+	if o.negotiated {
+		var names []string
+		for _, f := range formats {
+			names = append(names, f.name)
+		}
+		params = append(params, apiParam{"format", "query", "string", "Representation; the Accept header is used when absent. One of " + strings.Join(names, ", ") + "."})
+	}
+	if formats == nil {
+		formats = []outputFormat{formatJSON}
+	}
+	content := map[string]any{}
+	for _, f := range formats {
+		schema := map[string]any{"type": "string"}
+		switch {
+		case f.name == formatJSON.name:
+			schema = envelope
+		case strings.HasSuffix(f.types[0], "json"):
+			schema = map[string]any{"type": "object"}
+		}
+		content[f.types[0]] = map[string]any{"schema": schema}
+	}
+	success := map[string]any{"description": "Success", "content": content}
+	if o.corpus {
+		success["headers"] = map[string]any{
+			"X-Corpus-Version": map[string]any{"description": "Version of the corpus the response was served from.", "schema": map[string]any{"type": "string"}},
+		}
+	}
+	envelopeJSON := map[string]any{"application/json": map[string]any{"schema": envelope}}
+	responses := map[string]any{
+		"200": success,
+		"default": map[string]any{"description": "Error, as a problem or (legacy) the JSON envelope", "content": map[string]any{
+			"application/problem+json": map[string]any{"schema": problem},
+			"application/json":         map[string]any{"schema": envelope},
+		}},
+	}
+	if o.created {
+		responses["201"] = map[string]any{"description": "Created", "content": envelopeJSON}
+	}
+	if o.corpus {
+		responses["404"] = map[string]any{"description": "Unknown corpus, corpus version or URN", "content": map[string]any{
+			"application/problem+json": map[string]any{"schema": problem},
+			"application/json":         map[string]any{"schema": map[string]any{"anyOf": []any{g.schema(reflect.TypeOf(ErrorResponse{})), envelope}}},
+		}}
+	}
+
+	op := map[string]any{
+		"operationId": operationID(o.method, o.path, corpusParams),
+		"summary":     o.summary,
+		"responses":   responses,
+	}
+	if len(params) > 0 {
+		var ps []any
+		for _, p := range params {
+			ps = append(ps, p.spec())
+		}
+		op["parameters"] = ps
+	}
+	if o.body != nil {
+		body := map[string]any{}
+		for mt, schema := range o.body {
+			body[mt] = map[string]any{"schema": schema}
+		}
+		op["requestBody"] = map[string]any{"required": o.method != "POST", "content": body}
+	}
+	if o.security != "" {
+		op["security"] = []any{map[string]any{o.security: []string{}}}
+	}
+	return op
+}
+
+func (p apiParam) spec() map[string]any {
+	schema := map[string]any{"type": p.typ}
+	if p.typ == "array" {
+		schema["items"] = map[string]any{"type": "string"}
+	}
+	return map[string]any{
+		"name":        p.name,
+		"in":          p.in,
+		"required":    p.in == "path",
+		"description": p.desc,
+		"schema":      schema,
+	}
+}
+
+// operationID is e.g. getTextsUrns, or getCorpusTextsUrns for the /{CEX} variant.
+func operationID(method, path string, corpusParams []apiParam) string {
+	id := strings.ToLower(method)
+	if len(corpusParams) > 0 && corpusParams[0].in == "path" {
+		id += "Corpus"
+	}
+	for _, seg := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '.' || r == '{' || r == '}' }) {
+		id += strings.ToUpper(seg[:1]) + strings.ToLower(seg[1:])
+	}
+	if path == "/" {
+		id += "Root"
+	}
+	return id
+}
+
+// ---- schemas from Go types ----
+
+type schemaGen struct {
+	defs map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the JSON Schema of t as encoding/json writes it; named structs go to components.
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		s := g.schema(t.Elem())
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+			return s
+		}
+		return map[string]any{"anyOf": []any{s, map[string]any{"type": "null"}}}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = map[string]any{} // placeholder for recursive types
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// problem returns the application/problem+json schema of an operation whose envelope is t (nil when
+// it has none): Problem, with the envelope's other fields as optional extension members.
+func (g *schemaGen) problem(t reflect.Type) map[string]any {
+	base := g.object(reflect.TypeOf(Problem{}))
+	name := "Problem"
+	if t != nil {
+		name = t.Name() + "Problem"
+		props := base["properties"].(map[string]any)
+		for k, s := range g.object(t)["properties"].(map[string]any) {
+			if _, ok := props[k]; !ok && k != "service" && k != "message" {
+				props[k] = s
+			}
+		}
+	}
+	if _, ok := g.defs[name]; !ok {
+		g.defs[name] = base
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := g.schema(f.Type)
+		if enum := f.Tag.Get("enum"); enum != "" {
+			s["enum"] = strings.Split(enum, ",")
+		}
+		props[name] = s
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			required = append(required, name)
+		}
+	}
+	obj := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// ---- route coverage ----
+
+// checkOpenAPICoverage compares the routes registered on r with the paths of the spec.
+func checkOpenAPICoverage(r chi.Routes, spec map[string]any) error {
+	documented := map[string]bool{}
+	for path, item := range spec["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	var missing []string
+	err := chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if len(route) > 1 {
+			route = strings.TrimSuffix(route, "/")
+		}
+		key := method + " " + route
+		if !documented[key] {
+			missing = append(missing, key)
+		}
+		delete(documented, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var extra []string
+	for key := range documented {
+		extra = append(extra, key)
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	switch {
+	case len(missing) > 0:
+		return fmt.Errorf("routes missing from the OpenAPI spec: %s", strings.Join(missing, ", "))
+	case len(extra) > 0:
+		return fmt.Errorf("OpenAPI spec documents unregistered routes: %s", strings.Join(extra, ", "))
+	}
+	return nil
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.openAPI)
+}
+
+// ---- response checking ----
+
+// checkResponses buffers each response and, when it is JSON, validates it against the schema the spec
+// gives for its route and status. A mismatch is logged and replaces the response with a 500, so a
+// client or smoke test sees it. Responses are not streamed in this mode; it is meant for development
+// and CI.
+func (s *Server) checkResponses(mux *chi.Mux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			// the route as a whole, also when a group middleware answered before routing finished
+			pattern := mux.Find(chi.NewRouteContext(), r.Method, r.URL.Path)
+			if problems := s.contractProblems(r.Method, pattern, rec); len(problems) > 0 {
+				msg := fmt.Sprintf("%s %s (%s) answered %d, which does not match /openapi.json: %s",
+					r.Method, r.URL.Path, pattern, rec.status, strings.Join(problems, "; "))
+				log.Print("contract: " + msg)
+				writeJSON(w, http.StatusInternalServerError, ErrorResponse{Status: "Exception", Service: r.URL.Path, Message: msg})
+				return
+			}
+			for k, v := range rec.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+		})
+	}
+}
+
+type bufferedResponse struct {
+	header http.Header
+	status int
+	wrote  bool
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if !b.wrote {
+		b.status, b.wrote = status, true
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wrote = true
+	return b.body.Write(p)
+}
+
+// contractProblems lists how a recorded JSON or problem response differs from the spec; other media types
+// are only checked for being documented.
+func (s *Server) contractProblems(method, pattern string, rec *bufferedResponse) []string {
+	if pattern == "" {
+		return nil // not routed (404/405 from chi)
+	}
+	item, _ := s.openAPI["paths"].(map[string]any)[pattern].(map[string]any)
+	op, _ := item[strings.ToLower(method)].(map[string]any)
+	if op == nil {
+		return []string{"route is not documented"}
+	}
+	responses := op["responses"].(map[string]any)
+	resp, ok := responses[fmt.Sprint(rec.status)].(map[string]any)
+	if !ok {
+		resp = responses["default"].(map[string]any)
+	}
+	mt := strings.ToLower(strings.TrimSpace(strings.Split(rec.header.Get("Content-Type"), ";")[0]))
+	content, _ := resp["content"].(map[string]any)
+	media, ok := content[mt].(map[string]any)
+	if !ok {
+		if rec.body.Len() == 0 {
+			return nil
+		}
+		return []string{fmt.Sprintf("content type %q is not documented", mt)}
+	}
+	if mt != "application/json" && mt != "application/problem+json" {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(rec.body.Bytes(), &v); err != nil {
+		return []string{"invalid JSON: " + err.Error()}
+	}
+	return s.validateSchema(media["schema"].(map[string]any), v, "$", nil)
+}
+
+// validateSchema checks v (as decoded by encoding/json) against the subset of JSON Schema the
+// generator produces.
+func (s *Server) validateSchema(schema map[string]any, v any, at string, problems []string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		def, _ := s.openAPI["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+		return s.validateSchema(def, v, at, problems)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		for _, alt := range anyOf {
+			if len(s.validateSchema(alt.(map[string]any), v, at, nil)) == 0 {
+				return problems
+			}
+		}
+		return append(problems, at+": matches none of the alternatives")
+	}
+	var types []string
+	switch t := schema["type"].(type) {
+	case string:
+		types = []string{t}
+	case []string:
+		types = t
+	}
+	if len(types) > 0 && !slices.Contains(types, jsonType(v, slices.Contains(types, "integer"))) {
+		return append(problems, fmt.Sprintf("%s: expected %s, got %s", at, strings.Join(types, " or "), jsonType(v, false)))
+	}
+	if enum, ok := schema["enum"].([]string); ok {
+		if str, _ := v.(string); !slices.Contains(enum, str) {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %s", at, v, strings.Join(enum, ", ")))
+		}
+	}
+	switch v := v.(type) {
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, e := range v {
+				problems = s.validateSchema(items, e, fmt.Sprintf("%s[%d]", at, i), problems)
+			}
+		}
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, ok := v[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing %q", at, name))
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, ok := props[k].(map[string]any); ok {
+				problems = s.validateSchema(p, v[k], at+"."+k, problems)
+			} else if extra, ok := schema["additionalProperties"].(map[string]any); ok {
+				problems = s.validateSchema(extra, v[k], at+"."+k, problems)
+			} else if schema["additionalProperties"] == false {
+				problems = append(problems, fmt.Sprintf("%s: undocumented field %q", at, k))
+			}
+		}
+	}
+	return problems
+}
+
+// jsonType names the JSON Schema type of a decoded value; whole numbers are "integer" when preferred.
+func jsonType(v any, preferInteger bool) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if preferInteger && v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
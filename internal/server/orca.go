@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/cts"
+	cite "github.com/ThomasK81/gocite"
+	"github.com/go-chi/chi/v5"
+)
+
+// parseORCA collects ORCA alignments from every #!citedata block whose header
+// carries "passage" and "analysis" columns (urn#label#passage#analysis#deformation).
+func (s *Server) parseORCA(ctx context.Context, source string) ([]ORCARecord, error) {
+	blocks, err := s.parseCiteData(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	var out []ORCARecord
+	for _, b := range blocks {
+		iPassage := b.Col("passage", "text")
+		iAnalysis := b.Col("analysis")
+		if iPassage < 0 || iAnalysis < 0 {
+			continue
+		}
+		iURN := b.Col("urn")
+		iLabel := b.Col("label")
+		iDeform := b.Col("deformation", "derivedtext", "derived")
+		for _, row := range b.Rows {
+			rec := ORCARecord{
+				URN:         field(row, iURN),
+				Label:       field(row, iLabel),
+				Passage:     field(row, iPassage),
+				Analysis:    field(row, iAnalysis),
+				Deformation: field(row, iDeform),
+			}
+			if rec.Passage == "" || rec.Analysis == "" {
+				continue
+			}
+			out = append(out, rec)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errNoORCA
+	}
+	return out, nil
+}
+
+var errNoORCA = errors.New("no ORCA records in #!citedata")
+
+// analysisMatches reports whether an alignment's analysis URN is reqURN: the same object (possibly
+// with a subreference), or any object of a collection when reqURN ends in ":" or ".".
+func analysisMatches(analysis, reqURN string) bool {
+	if strings.HasSuffix(reqURN, ":") || strings.HasSuffix(reqURN, ".") {
+		return strings.HasPrefix(analysis, reqURN)
+	}
+	return analysis == reqURN || strings.HasPrefix(analysis, reqURN+"@")
+}
+
+// resolveORCA fills rec.Nodes with the analysed span, using the same anchor logic as /texts/{URN}.
+// Spans are resolved with the engine defaults, whatever text filters the request carries.
+func resolveORCA(rec ORCARecord, allURNs, allTexts []string) ORCARecord {
+	c := cts.Corpus{URNs: allURNs, Texts: allTexts}
+	nodes, err := c.Resolve(rec.Passage, cts.Options{})
+	if err != nil {
+		rec.Message = err.Error()
+		return rec
+	}
+	rec.Nodes = nodes
+	return rec
+}
+
+func (s *Server) handleORCAByText(w http.ResponseWriter, r *http.Request) { s.orcaQuery(w, r, true) }
+func (s *Server) handleORCAByAnalysis(w http.ResponseWriter, r *http.Request) {
+	s.orcaQuery(w, r, false)
+}
+
+func (s *Server) orcaQuery(w http.ResponseWriter, r *http.Request, byText bool) {
+	ctx := r.Context()
+	cexName := chi.URLParam(r, "CEX")
+	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
+	reqURN := pathURN(r)
+	svc := "/orca/analysis"
+	if byText {
+		svc = "/orca/text"
+	}
+
+	if byText && !cite.IsCTSURN(reqURN) && !cite.IsRange(reqURN) {
+		s.writeError(w, r, http.StatusBadRequest, "", ORCAResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: reqURN + " is not valid CTS.",
+		})
+		return
+	}
+	if !byText && !cite.IsCITEURN(reqURN) {
+		s.writeError(w, r, http.StatusBadRequest, "", ORCAResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: reqURN + " is not a valid CITE2 URN.",
+		})
+		return
+	}
+
+	allURNs, allTexts, err := s.parseCTSData(ctx, source)
+	if err != nil {
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, ORCAResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: msg,
+		})
+		return
+	}
+	recs, err := s.parseORCA(ctx, source)
+	if err != nil {
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, ORCAResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: msg,
+		})
+		return
+	}
+
+	var out []ORCARecord
+	if byText {
+		// the passages covered by the request, resolved the same way /texts/{URN} does
+		nodes, err := resolvePassage(r, reqURN, allURNs, allTexts)
+		if err != nil {
+			status, code := resolveFailure(err)
+			s.writeError(w, r, status, code, ORCAResponse{
+				RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: err.Error(),
+			})
+			return
+		}
+		covered := make(map[string]struct{}, len(nodes))
+		for _, n := range nodes {
+			covered[n.URN[0]] = struct{}{}
+		}
+		stem := workStem(reqURN)
+		for _, rec := range recs {
+			if workStem(rec.Passage) != stem {
+				continue
+			}
+			rec = resolveORCA(rec, allURNs, allTexts)
+			if len(rec.Nodes) == 0 {
+				// unresolvable span: kept with its message when its passage is under the request
+				passage, _, _ := strings.Cut(rec.Passage, "@")
+				req, _, _ := strings.Cut(reqURN, "@")
+				if _, ok := covered[passage]; ok || urnWithin(passage, req) {
+					out = append(out, rec)
+				}
+				continue
+			}
+			for _, n := range rec.Nodes {
+				if _, ok := covered[n.URN[0]]; ok {
+					out = append(out, rec)
+					break
+				}
+			}
+		}
+	} else {
+		for _, rec := range recs {
+			if analysisMatches(rec.Analysis, reqURN) {
+				out = append(out, resolveORCA(rec, allURNs, allTexts))
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		s.writeError(w, r, http.StatusNotFound, "", ORCAResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: "No alignments for " + reqURN,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, ORCAResponse{
+		RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Alignments: out,
+	})
+}
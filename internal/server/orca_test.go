@@ -0,0 +1,48 @@
+package server
+
+import "testing"
+
+func TestORCAViewsAgree(t *testing.T) {
+	_, h := newTestServer(t)
+
+	var byText, byAnalysis ORCAResponse
+	if code := getJSON(t, h, "/orca/text/"+hdt+"1.1", &byText); code != 200 {
+		t.Fatalf("by text: status %d", code)
+	}
+	if code := getJSON(t, h, "/orca/analysis/urn:cite2:demo:syntax.v1:s1.obj", &byAnalysis); code != 200 {
+		t.Fatalf("by analysis: status %d", code)
+	}
+	lost := byAnalysis.Alignments[0]
+	if lost.Message == "" || len(lost.Nodes) != 0 {
+		t.Fatalf("by analysis: %+v, want an unresolved span with a message", lost)
+	}
+	var found bool
+	for _, rec := range byText.Alignments {
+		if rec.URN == lost.URN {
+			found = true
+			if rec.Message != lost.Message {
+				t.Errorf("by text: message %q, by analysis %q", rec.Message, lost.Message)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("by text: %s missing from %+v", lost.URN, byText.Alignments)
+	}
+}
+
+func TestORCASpansIgnoreTextFilters(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, target := range []string{
+		"/orca/analysis/urn:cite2:demo:syntax.v1:s1.subj",
+		"/orca/analysis/urn:cite2:demo:syntax.v1:s1.subj?substring=learned&maxChars=3&clip=false",
+	} {
+		var res ORCAResponse
+		if code := getJSON(t, h, target, &res); code != 200 {
+			t.Fatalf("%s: status %d", target, code)
+		}
+		nodes := res.Alignments[0].Nodes
+		if len(nodes) != 1 || nodes[0].Text[0] != "The Persian learned men" {
+			t.Errorf("%s: span %+v, want %q", target, nodes, "The Persian learned men")
+		}
+	}
+}
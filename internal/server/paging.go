@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// page is the part of a passage or URN listing a request asked for with limit and offset, or with
+// the cursor of a next link. A zero page is the whole listing.
+type page struct {
+	offset, limit int // limit 0: everything from offset
+}
+
+// pageOf reads limit, offset and cursor, capping limit at the configured max_page_size.
+func (s *Server) pageOf(q url.Values) (page, error) {
+	var p page
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("limit %q is not a positive integer.", v)
+		}
+		p.limit = n
+	}
+	if most := s.cfg.MaxPageSize; most > 0 && (p.limit == 0 || p.limit > most) {
+		p.limit = most
+	}
+	offset, cursor := q.Get("offset"), q.Get("cursor")
+	switch {
+	case offset != "" && cursor != "":
+		return p, errors.New("Use either offset or cursor.")
+	case offset != "":
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("offset %q is not a non-negative integer.", offset)
+		}
+		p.offset = n
+	case cursor != "":
+		n, ok := decodeCursor(cursor)
+		if !ok {
+			return p, errors.New("Invalid cursor.")
+		}
+		p.offset = n
+	}
+	return p, nil
+}
+
+// paged reports whether the listing is cut into pages, so that responses carry total and next.
+func (p page) paged() bool { return p.limit > 0 || p.offset > 0 }
+
+// bounds is the slice [start:end] of a listing of n items that is on the page.
+func (p page) bounds(n int) (start, end int) {
+	start = min(p.offset, n)
+	end = n
+	if p.limit > 0 {
+		end = min(start+p.limit, n)
+	}
+	return start, end
+}
+
+// nextPage is the URL of the page after p in a listing of total items, or "" for the last page. It
+// pins the corpus version, so a client paging through a listing never sees two versions of it.
+func (s *Server) nextPage(r *http.Request, p page, total int, version string) string {
+	if _, end := p.bounds(total); end >= total {
+		return ""
+	}
+	q := r.URL.Query()
+	q.Del("offset")
+	q.Set("cursor", encodeCursor(p.offset+p.limit))
+	q.Set("limit", strconv.Itoa(p.limit))
+	if version != "" {
+		q.Set("version", version)
+	}
+	return publicBaseURL(s.cfg, r) + r.URL.EscapedPath() + "?" + q.Encode()
+}
+
+// setPageHeaders repeats total and next as headers, for the formats without an envelope.
+func setPageHeaders(w http.ResponseWriter, total int, next string) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != "" {
+		w.Header().Set("Link", "<"+next+`>; rel="next"`)
+	}
+}
+
+// Cursors are opaque to clients; today they only carry the offset.
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(c string) (int, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(b), "o"))
+	if err != nil || n < 0 || !strings.HasPrefix(string(b), "o") {
+		return 0, false
+	}
+	return n, true
+}
+
+// resolvePage resolves reqURN like resolvePassage but keeps only the nodes on p, and counts them all.
+func resolvePage(r *http.Request, reqURN string, allURNs, allTexts []string, p page) ([]Node, int, error) {
+	var nodes []Node
+	total := 0
+	err := resolvePassageEach(r, reqURN, allURNs, allTexts, func(n Node) error {
+		if total >= p.offset && (p.limit == 0 || total < p.offset+p.limit) {
+			nodes = append(nodes, n)
+		}
+		total++
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return nodes, total, nil
+}
+
+// writePassagePage answers a paged /texts/{URN}: the JSON envelope carries total and next, and
+// every format repeats them in the X-Total-Count and Link headers.
+func (s *Server) writePassagePage(w http.ResponseWriter, r *http.Request, format outputFormat, source, reqURN string, allURNs, allTexts []string, p page) {
+	svc := "/texts"
+	nodes, total, err := resolvePage(r, reqURN, allURNs, allTexts, p)
+	if err != nil {
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: err.Error(),
+		})
+		return
+	}
+	version := s.servedVersion(r.Context(), source)
+	next := s.nextPage(r, p, total, version)
+	setPageHeaders(w, total, next)
+
+	each := func(emit func(Node) error) error {
+		for _, n := range nodes {
+			if err := emit(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch {
+	case format.name == formatJSON.name:
+		writeJSON(w, http.StatusOK, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Success", Service: svc,
+			Nodes: nodes, Total: total, Next: next, Version: version,
+		})
+	case isTableFormat(format):
+		s.streamPassage(w, r, format, source, reqURN, each)
+	case format.name == formatJSONLD.name:
+		s.streamPassageLD(w, r, source, reqURN, each)
+	default:
+		s.writeRendered(w, r, format, source, reqURN, nodes)
+	}
+}
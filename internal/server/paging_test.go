@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPageOf(t *testing.T) {
+	for _, c := range []struct {
+		query   string
+		max     int
+		want    page
+		wantErr bool
+	}{
+		{query: "", want: page{}},
+		{query: "limit=2", want: page{limit: 2}},
+		{query: "offset=3", want: page{offset: 3}},
+		{query: "limit=2&offset=0", want: page{limit: 2}},
+		{query: "cursor=" + encodeCursor(7) + "&limit=5", want: page{offset: 7, limit: 5}},
+		{query: "", max: 3, want: page{limit: 3}},
+		{query: "limit=10", max: 3, want: page{limit: 3}},
+		{query: "limit=2", max: 3, want: page{limit: 2}},
+		{query: "offset=4", max: 3, want: page{offset: 4, limit: 3}},
+		{query: "limit=0", wantErr: true},
+		{query: "limit=-1", wantErr: true},
+		{query: "limit=x", wantErr: true},
+		{query: "offset=-1", wantErr: true},
+		{query: "offset=1&cursor=" + encodeCursor(1), wantErr: true},
+		{query: "cursor=!!", wantErr: true},
+		{query: "cursor=" + encodeCursor(-1), wantErr: true},
+	} {
+		s := &Server{cfg: ServerConfig{MaxPageSize: c.max}}
+		q, _ := url.ParseQuery(c.query)
+		got, err := s.pageOf(q)
+		if (err != nil) != c.wantErr || err == nil && got != c.want {
+			t.Errorf("pageOf(%q) with max_page_size %d = %+v, %v, want %+v (error %v)", c.query, c.max, got, err, c.want, c.wantErr)
+		}
+	}
+}
+
+func TestPageBounds(t *testing.T) {
+	for _, c := range []struct {
+		p                page
+		n                int
+		start, end       int
+		paged, morePages bool
+	}{
+		{page{}, 5, 0, 5, false, false},
+		{page{limit: 2}, 5, 0, 2, true, true},
+		{page{offset: 2, limit: 2}, 5, 2, 4, true, true},
+		{page{offset: 4, limit: 2}, 5, 4, 5, true, false},
+		{page{offset: 3}, 5, 3, 5, true, false},
+		{page{offset: 9, limit: 2}, 5, 5, 5, true, false},
+		{page{limit: 2}, 0, 0, 0, true, false},
+	} {
+		start, end := c.p.bounds(c.n)
+		if start != c.start || end != c.end || c.p.paged() != c.paged {
+			t.Errorf("%+v over %d: [%d:%d] paged %v, want [%d:%d] paged %v", c.p, c.n, start, end, c.p.paged(), c.start, c.end, c.paged)
+		}
+		s := &Server{}
+		r := httptest.NewRequest("GET", "/texts/urns/"+hdt, nil)
+		if next := s.nextPage(r, c.p, c.n, ""); (next != "") != c.morePages {
+			t.Errorf("%+v over %d: next page %q", c.p, c.n, next)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 17, 1 << 30} {
+		c := encodeCursor(n)
+		if got, ok := decodeCursor(c); !ok || got != n {
+			t.Errorf("decodeCursor(encodeCursor(%d)) = %d, %v", n, got, ok)
+		}
+		if strings.ContainsAny(c, "+/=") {
+			t.Errorf("cursor %q is not URL-safe", c)
+		}
+	}
+	for _, c := range []string{"", "o1", "!!", encodeCursor(-1), "eDE"} {
+		if n, ok := decodeCursor(c); ok {
+			t.Errorf("decodeCursor(%q) = %d, want an error", c, n)
+		}
+	}
+}
+
+func TestNextPage(t *testing.T) {
+	s := &Server{cfg: ServerConfig{PublicURL: "https://texts.example.org/"}}
+	r := httptest.NewRequest("GET", "/demo/texts/"+url.PathEscape(hdt+"1.1@/a b/")+"?format=csv&offset=2&limit=2", nil)
+	next := s.nextPage(r, page{offset: 2, limit: 2}, 10, "abc123")
+	u, err := url.Parse(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme+"://"+u.Host != "https://texts.example.org" || u.EscapedPath() != r.URL.EscapedPath() {
+		t.Errorf("next page %s, want the same path on public_url", next)
+	}
+	q := u.Query()
+	if off, _ := decodeCursor(q.Get("cursor")); off != 4 || q.Has("offset") || q.Get("limit") != "2" || q.Get("version") != "abc123" || q.Get("format") != "csv" {
+		t.Errorf("next page query %v, want cursor at 4, limit 2, version abc123 and format kept", q)
+	}
+}
+
+var linkNext = regexp.MustCompile(`^<([^>]+)>; rel="next"$`)
+
+// pageThrough follows a listing's Link headers from target, returning the first CSV column of every
+// page and the X-Total-Count of each.
+func pageThrough(t *testing.T, h http.Handler, target string) (urns []string, totals []string) {
+	t.Helper()
+	for range 20 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", target, rec.Code, truncate(rec.Body.String()))
+		}
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows[1:] {
+			urns = append(urns, row[0])
+		}
+		totals = append(totals, rec.Header().Get("X-Total-Count"))
+		m := linkNext.FindStringSubmatch(rec.Header().Get("Link"))
+		if m == nil {
+			return urns, totals
+		}
+		target = m[1]
+	}
+	t.Fatalf("no last page after 20 pages of %s", target)
+	return nil, nil
+}
+
+func TestPagedListings(t *testing.T) {
+	s, h := newTestServer(t)
+	all := []string{hdt + "1.0", hdt + "1.1", hdt + "1.2", hdt + "2.1"}
+	for _, c := range []struct {
+		target string
+		max    int
+		want   []string // nil for the same rows as the unpaged listing
+		pages  int
+	}{
+		{"/texts/" + hdt + "?format=csv&limit=3", 0, all, 2},
+		{"/texts/urns/" + hdt + "?format=csv&limit=1", 0, all, 4},
+		{"/demo/texts/urns/" + hdt + "?format=csv&offset=1&limit=2", 0, all[1:], 2},
+		{"/texts/search?q=the&within=" + hdt + "&format=csv&limit=5", 0, nil, 5},
+		{"/texts/urns/" + hdt + "?format=csv", 3, all, 2},
+		{"/texts/urns/" + hdt + "?format=csv&limit=50", 3, all, 2},
+		{"/texts/urns/" + hdt + "?format=csv&limit=1", 3, all, 4},
+	} {
+		if c.want == nil {
+			s.cfg.MaxPageSize = 0
+			c.want, _ = pageThrough(t, h, strings.Replace(c.target, "&limit=", "&nolimit=", 1))
+		}
+		s.cfg.MaxPageSize = c.max
+		urns, totals := pageThrough(t, h, c.target)
+		if len(totals) != c.pages || !slices.Equal(urns, c.want) {
+			t.Errorf("%s (max_page_size %d): %d pages of %q, want %d pages of %q", c.target, c.max, len(totals), urns, c.pages, c.want)
+		}
+		for _, total := range totals {
+			if total != totals[0] || total == "" {
+				t.Errorf("%s: X-Total-Count %q, want the same total on every page", c.target, totals)
+				break
+			}
+		}
+	}
+	s.cfg.MaxPageSize = 0
+
+	var res NodeResponse
+	if code := getJSON(t, h, "/texts/"+hdt+"?limit=3", &res); code != 200 || res.Total != 4 || len(res.Nodes) != 3 || res.Next == "" {
+		t.Errorf("JSON page: %d total %d with %d nodes, next %q", code, res.Total, len(res.Nodes), res.Next)
+	}
+	res = NodeResponse{}
+	if code := getJSON(t, h, "/texts/"+hdt, &res); code != 200 || res.Total != 0 || res.Next != "" || len(res.Nodes) != 4 {
+		t.Errorf("unpaged JSON: %d total %d with %d nodes, next %q", code, res.Total, len(res.Nodes), res.Next)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/texts/urns/"+hdt+"?format=csv", nil))
+	if rec.Header().Get("X-Total-Count") != "" || rec.Header().Get("Link") != "" {
+		t.Errorf("unpaged listing has paging headers %v", rec.Header())
+	}
+}
@@ -2,106 +2,303 @@ package server
 
 import (
 	"context"
-	"encoding/csv"
-	"errors"
-	"regexp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/GhentCDH/annophis-text-service/cts"
+	"github.com/go-chi/chi/v5"
 )
 
-var reLineComments = regexp.MustCompile(`(?m)[\r\n]*^//.*$`)
+// corpus is a parsed CEX source with its provenance. It is immutable once loaded and shared between requests.
+type corpus struct {
+	cts.Corpus
+	version string // content hash of the decoded source
+	commit  string // git commit it was read from, if any
+}
 
-func (s *Server) parseCTSData(ctx context.Context, source string) (urns, texts []string, err error) {
-	data, err := s.getContent(ctx, source)
+// readCorpus parses a CEX stream with the per-corpus delimiter and encoding.
+func readCorpus(r io.Reader, cc CorpusConfig) (*corpus, error) {
+	c, err := cts.Read(r, cts.ReadOptions{Delimiter: cc.Delimiter, Encoding: cc.Encoding})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	str := string(data)
-	parts := strings.Split(str, "#!ctsdata")
-	if len(parts) < 2 {
-		return nil, nil, errors.New("missing #!ctsdata")
+	return &corpus{Corpus: *c}, nil
+}
+
+// readVersionedCorpus is readCorpus plus the corpus version, a hash of the bytes read.
+func readVersionedCorpus(r io.Reader, cc CorpusConfig) (*corpus, error) {
+	h := sha256.New()
+	c, err := readCorpus(io.TeeReader(r, h), cc)
+	if err != nil {
+		return nil, err
 	}
-	section := strings.Split(parts[1], "#!")[0]
-	section = reLineComments.ReplaceAllString(section, "")
+	c.version = hex.EncodeToString(h.Sum(nil))[:16]
+	return c, nil
+}
 
-	r := csv.NewReader(strings.NewReader(section))
-	r.Comma = '#'
-	r.LazyQuotes = true
-	r.FieldsPerRecord = 2
+// ---- parsed-corpus cache ----
 
-	for {
-		line, err := r.Read()
-		if err != nil {
-			if errors.Is(err, csv.ErrFieldCount) {
-				// keep going
-			}
-			if err.Error() == "EOF" || errors.Is(err, context.Canceled) {
-				break
-			}
-			if err != nil && err.Error() == "EOF" {
-				break
-			}
-			if err != nil {
-				break
-			}
-		}
-		if len(line) != 2 {
-			if len(line) == 0 {
-				break
-			}
-			continue
-		}
-		urns = append(urns, strings.TrimSpace(line[0]))
-		texts = append(texts, line[1])
+type corpusCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	entries  map[string]corpusEntry
+	inflight map[string]*corpusLoad
+	history  map[string][]*corpus // per source, newest first; kept regardless of TTL
+	keep     int                  // versions in history
+}
+
+type corpusEntry struct {
+	c  *corpus
+	at time.Time
+}
+
+// corpusLoad is a parse in progress; concurrent requests for the same source wait on it.
+type corpusLoad struct {
+	done chan struct{}
+	c    *corpus
+	err  error
+}
+
+// loadCorpus returns the parsed corpus for source, parsing it at most once per TTL. The parse runs
+// detached from ctx so that a request timing out does not waste a long load; ctx only bounds the wait.
+// Git sources are cached per commit. Within one request the same corpus is always returned.
+func (s *Server) loadCorpus(ctx context.Context, source string) (*corpus, error) {
+	memo, _ := ctx.Value(servedKey{}).(*servedCorpora)
+	if c := memo.get(source); c != nil {
+		return c, nil
+	}
+	c, err := s.loadLatest(ctx, source)
+	if err == nil {
+		memo.set(source, c)
 	}
-	return urns, texts, nil
+	return c, err
 }
 
-func (s *Server) parseCTSCatalog(ctx context.Context, source string) ([]CatalogEntry, error) {
-	data, err := s.getContent(ctx, source)
+func (s *Server) loadLatest(ctx context.Context, source string) (*corpus, error) {
+	if strings.HasPrefix(source, virtualScheme) {
+		return s.loadVirtual(ctx, source)
+	}
+	key, err := s.pinSource(ctx, source)
 	if err != nil {
 		return nil, err
 	}
-	str := string(data)
-	parts := strings.Split(str, "#!ctscatalog")
-	if len(parts) < 2 {
-		return nil, errors.New("missing #!ctscatalog")
+	cache := s.corpora
+	cache.mu.Lock()
+	if e, ok := cache.entries[key]; ok && time.Since(e.at) <= cache.ttl {
+		cache.mu.Unlock()
+		return e.c, nil
+	}
+	load, ok := cache.inflight[key]
+	if !ok {
+		load = &corpusLoad{done: make(chan struct{})}
+		cache.inflight[key] = load
+		go s.runCorpusLoad(source, key, load)
 	}
-	section := strings.Split(parts[1], "#!")[0]
-	section = reLineComments.ReplaceAllString(section, "")
+	cache.mu.Unlock()
 
-	r := csv.NewReader(strings.NewReader(section))
-	r.Comma = '#'
-	r.LazyQuotes = true
+	select {
+	case <-load.done:
+		return load.c, load.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-	var out []CatalogEntry
-	row := 0
-	for {
-		fields, err := r.Read()
-		if err != nil {
-			break
+// runCorpusLoad reads key (source, pinned to a commit for git) with the settings configured for source.
+func (s *Server) runCorpusLoad(source, key string, load *corpusLoad) {
+	defer close(load.done)
+	rc, err := s.openSource(context.Background(), key)
+	if err == nil {
+		load.c, err = readVersionedCorpus(rc, s.corpusConfig(source))
+		rc.Close()
+		if err == nil {
+			load.c.commit, _ = splitGitSource(key)
+			if n := len(load.c.Skipped); n > 0 {
+				log.Printf("%s: left out %d malformed rows (first on line %d); see /texts/validate", source, n, load.c.Skipped[0])
+			}
 		}
-		row++
-		if row == 1 && len(fields) > 0 && strings.EqualFold(strings.TrimSpace(fields[0]), "urn") {
+	}
+	load.err = err
+
+	cache := s.corpora
+	cache.mu.Lock()
+	delete(cache.inflight, key)
+	if err == nil {
+		load.c = cache.remember(source, load.c)
+		cache.entries[key] = corpusEntry{c: load.c, at: time.Now()}
+	}
+	cache.mu.Unlock()
+}
+
+// remember moves c to the front of the source's history, reusing an identical earlier parse.
+// Callers hold cache.mu.
+func (cache *corpusCache) remember(source string, c *corpus) *corpus {
+	hist := []*corpus{c}
+	for _, old := range cache.history[source] {
+		if old.version == c.version {
+			hist[0] = old
 			continue
 		}
-		if len(fields) < 4 {
-			continue
+		if len(hist) < cache.keep {
+			hist = append(hist, old)
 		}
-		var entry CatalogEntry
-		entry.URN = strings.TrimSpace(fields[0])
-		entry.CitationScheme = strings.TrimSpace(fields[1])
-		entry.GroupName = strings.TrimSpace(fields[2])
-		entry.WorkTitle = strings.TrimSpace(fields[3])
-		if len(fields) > 4 {
-			entry.VersionLabel = strings.TrimSpace(fields[4])
+	}
+	cache.history[source] = hist
+	return hist[0]
+}
+
+// skipped counts, per current corpus with any, the malformed rows its parse left out.
+func (cache *corpusCache) skipped() map[string]int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	var out map[string]int
+	for key, e := range cache.entries {
+		if n := len(e.c.Skipped); n > 0 {
+			if out == nil {
+				out = map[string]int{}
+			}
+			out[key] = n
 		}
-		if len(fields) > 5 {
-			entry.ExemplarLabel = strings.TrimSpace(fields[5])
+	}
+	return out
+}
+
+// replace makes c the current corpus of source at once, as after a write.
+func (cache *corpusCache) replace(source string, c *corpus) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries[source] = corpusEntry{c: cache.remember(source, c), at: time.Now()}
+}
+
+// forget drops the current corpus of source; kept versions stay available.
+func (cache *corpusCache) forget(source string) {
+	cache.mu.Lock()
+	delete(cache.entries, source)
+	cache.mu.Unlock()
+}
+
+// version returns the kept corpus of source whose version starts with v (at least 6 characters).
+func (cache *corpusCache) version(source, v string) (*corpus, bool) {
+	if len(v) < 6 {
+		return nil, false
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for _, c := range cache.history[source] {
+		if strings.HasPrefix(c.version, v) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// ---- per-request pinning ----
+
+type servedKey struct{}
+
+// servedCorpora records the corpus each source resolved to for one request; a nil map is inert.
+type servedCorpora struct {
+	mu sync.Mutex
+	m  map[string]*corpus
+}
+
+func (sc *servedCorpora) get(source string) *corpus {
+	if sc == nil {
+		return nil
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.m[source]
+}
+
+func (sc *servedCorpora) set(source string, c *corpus) {
+	if sc == nil {
+		return
+	}
+	sc.mu.Lock()
+	sc.m[source] = c
+	sc.mu.Unlock()
+}
+
+// servedVersion is the version of the corpus this request was served from ("" if none was loaded).
+func (s *Server) servedVersion(ctx context.Context, source string) string {
+	memo, _ := ctx.Value(servedKey{}).(*servedCorpora)
+	if c := memo.get(source); c != nil {
+		return c.version
+	}
+	return ""
+}
+
+// withVersion pins the request to a kept corpus version given as /{CEX}@{version}/... or ?version=,
+// and makes every handler see a single corpus per source for the rest of the request.
+func (s *Server) withVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		memo := &servedCorpora{m: map[string]*corpus{}}
+		r = r.WithContext(context.WithValue(r.Context(), servedKey{}, memo))
+
+		cexName := chi.URLParam(r, "CEX")
+		_, v, _ := strings.Cut(cexName, "@")
+		if q := strings.TrimSpace(r.URL.Query().Get("version")); q != "" {
+			v = q
 		}
-		if len(fields) > 6 {
-			entry.Online = strings.EqualFold(strings.TrimSpace(fields[6]), "true")
+		if v != "" {
+			source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
+			c, ok := s.corpora.version(source, v)
+			if !ok {
+				s.writeError(w, r, http.StatusNotFound, codeVersionNotFound, ErrorResponse{
+					Status:  "Exception",
+					Service: r.URL.Path,
+					Message: fmt.Sprintf("Version %s of %s is not available; it was never served or has been dropped.", v, source),
+				})
+				return
+			}
+			memo.set(source, c)
+			if c.commit != "" {
+				w.Header().Set(commitHeader, c.commit)
+			}
 		}
-		out = append(out, entry)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ---- views used by the handlers ----
+
+func (s *Server) parseCTSData(ctx context.Context, source string) (urns, texts []string, err error) {
+	c, err := s.loadCorpus(ctx, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !c.Sections["ctsdata"] {
+		return nil, nil, missingBlockError("ctsdata")
+	}
+	return c.URNs, c.Texts, nil
+}
+
+func (s *Server) parseCTSCatalog(ctx context.Context, source string) ([]CatalogEntry, error) {
+	c, err := s.loadCorpus(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Sections["ctscatalog"] {
+		return nil, missingBlockError("ctscatalog")
+	}
+	return c.Catalog, nil
+}
+
+func (s *Server) parseCiteData(ctx context.Context, source string) ([]cts.CiteData, error) {
+	c, err := s.loadCorpus(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.CiteData) == 0 {
+		return nil, missingBlockError("citedata")
 	}
-	return out, nil
+	return c.CiteData, nil
 }
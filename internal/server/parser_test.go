@@ -0,0 +1,117 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func testCorpus(version string) *corpus {
+	return &corpus{version: version}
+}
+
+func TestCorpusCacheRemember(t *testing.T) {
+	cache := &corpusCache{history: map[string][]*corpus{}, keep: 2}
+	history := func() string {
+		var vs []string
+		for _, c := range cache.history["src"] {
+			vs = append(vs, c.version)
+		}
+		return strings.Join(vs, ",")
+	}
+
+	a := testCorpus("aaaaaaaa")
+	if got := cache.remember("src", a); got != a {
+		t.Fatal("remember returned another corpus for a new version")
+	}
+	cache.remember("src", testCorpus("bbbbbbbb"))
+	cache.remember("src", testCorpus("cccccccc"))
+	if got := history(); got != "cccccccc,bbbbbbbb" {
+		t.Errorf("history after three versions with keep_versions 2 = %s", got)
+	}
+
+	b := cache.history["src"][1]
+	if got := cache.remember("src", testCorpus("bbbbbbbb")); got != b {
+		t.Error("an identical parse was not replaced by the kept corpus")
+	}
+	if got := history(); got != "bbbbbbbb,cccccccc" {
+		t.Errorf("history after re-reading b = %s, want b moved to the front", got)
+	}
+	if len(cache.history["other"]) != 0 {
+		t.Error("history leaked into another source")
+	}
+}
+
+func TestCorpusCacheVersion(t *testing.T) {
+	cache := &corpusCache{history: map[string][]*corpus{}, keep: 2}
+	for _, v := range []string{"0123456789abcdef", "fedcba9876543210", "0123ffffffffffff"} {
+		cache.remember("src", testCorpus(v))
+	}
+	for _, c := range []struct {
+		source, v string
+		want      string // "" when not found
+	}{
+		{"src", "fedcba9876543210", "fedcba9876543210"},
+		{"src", "fedcba", "fedcba9876543210"},
+		{"src", "0123ff", "0123ffffffffffff"},
+		{"src", "fedcb", ""},             // too short to be a version
+		{"src", "012345", ""},            // dropped from the history
+		{"other", "fedcba", ""},          // versions are per source
+		{"src", "fedcba98765432100", ""}, // longer than any version
+	} {
+		got, ok := cache.version(c.source, c.v)
+		if ok != (c.want != "") || ok && got.version != c.want {
+			t.Errorf("version(%q, %q) = %v, %v, want %q", c.source, c.v, got, ok, c.want)
+		}
+	}
+}
+
+func TestWithVersionPinsKeptCorpus(t *testing.T) {
+	s, h := newTestServer(t)
+	const target = "/texts/" + hdt + "1.2"
+
+	var current NodeResponse
+	if code := getJSON(t, h, target, &current); code != 200 {
+		t.Fatalf("GET %s: %d", target, code)
+	}
+	old := current.Version
+	if len(old) < 6 {
+		t.Fatalf("response version = %q", old)
+	}
+
+	raw, err := os.ReadFile("testdata/demo.cex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(raw), "So Io was carried off to Egypt", "So Io was taken to Egypt", 1)
+	c, err := readVersionedCorpus(strings.NewReader(edited), CorpusConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.corpora.replace("testdata/demo.cex", c)
+
+	for _, c := range []struct {
+		target, version, text string
+	}{
+		{target, c.version, "taken"},
+		{target + "?version=" + old[:6], old, "carried off"},
+		{"/demo@" + old + target, old, "carried off"},
+		{"/demo" + target + "?version=" + c.version[:8], c.version, "taken"},
+	} {
+		var got NodeResponse
+		if code := getJSON(t, h, c.target, &got); code != 200 {
+			t.Errorf("GET %s: %d", c.target, code)
+			continue
+		}
+		if got.Version != c.version || len(got.Nodes) != 1 || !strings.Contains(got.Nodes[0].Text[0], c.text) {
+			t.Errorf("GET %s: version %s %+v, want version %s with %q", c.target, got.Version, got.Nodes, c.version, c.text)
+		}
+	}
+
+	for _, bad := range []string{target + "?version=000000", "/demo@000000" + target, target + "?version=" + old[:5]} {
+		var p map[string]any
+		if code := getJSON(t, h, bad, &p); code != 404 || p["code"] != codeVersionNotFound {
+			t.Errorf("GET %s: %d %v, want 404 %s", bad, code, p["code"], codeVersionNotFound)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"github.com/GhentCDH/annophis-text-service/cts"
+)
+
+// Error codes of failed requests. A problem's type is problemType + code.
+const (
+	codeInvalidURN      = "invalid_urn"
+	codeInvalidAnchor   = "invalid_anchor"
+	codeInvalidRegex    = "invalid_regex"
+	codeInvalidParam    = "invalid_parameter"
+	codeInvalidBody     = "invalid_body"
+	codeNotFound        = "not_found"
+	codeCorpusNotFound  = "corpus_not_found"
+	codeVersionNotFound = "version_not_found"
+	codeMissingBlock    = "missing_block"
+	codeUnauthorized    = "unauthorized"
+	codeForbidden       = "forbidden"
+	codeConflict        = "conflict"
+	codeTooLarge        = "too_large"
+	codeUnsupportedType = "unsupported_media_type"
+	codeRejected        = "validation_failed"
+	codeSourceFailed    = "source_unavailable"
+	codeSourceTooLarge  = "source_too_large"
+	codeSourceTimeout   = "source_timeout"
+	codeInternal        = "internal_error"
+)
+
+const problemType = "urn:annophis-text-service:problem:"
+
+var problemTitles = map[string]string{
+	codeInvalidURN:      "Invalid CTS URN",
+	codeInvalidAnchor:   "Malformed anchor",
+	codeInvalidRegex:    "Invalid regular expression",
+	codeInvalidParam:    "Invalid parameter",
+	codeInvalidBody:     "Invalid request body",
+	codeNotFound:        "Not found",
+	codeCorpusNotFound:  "Corpus not found",
+	codeVersionNotFound: "Corpus version not available",
+	codeMissingBlock:    "Source lacks a CEX block",
+	codeUnauthorized:    "Missing or invalid token",
+	codeForbidden:       "Disabled",
+	codeConflict:        "Conflict",
+	codeTooLarge:        "Request too large",
+	codeUnsupportedType: "Unsupported media type",
+	codeRejected:        "Validation failed",
+	codeSourceFailed:    "Source unavailable",
+	codeSourceTooLarge:  "Source too large",
+	codeSourceTimeout:   "Source timed out",
+	codeInternal:        "Internal error",
+}
+
+// defaultCodes are the codes of statuses that call sites don't qualify further.
+var defaultCodes = map[int]string{
+	http.StatusBadRequest:            codeInvalidParam,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusConflict:              codeConflict,
+	http.StatusRequestEntityTooLarge: codeTooLarge,
+	http.StatusUnsupportedMediaType:  codeUnsupportedType,
+	http.StatusUnprocessableEntity:   codeRejected,
+	http.StatusBadGateway:            codeSourceFailed,
+	http.StatusServiceUnavailable:    codeSourceTimeout,
+}
+
+// legacyErrors reports whether r gets the pre-problem Exception envelopes: with legacy_errors, unless
+// the request sends "API-Version: 2", or for requests sending "API-Version: 1".
+func (s *Server) legacyErrors(r *http.Request) bool {
+	switch r.Header.Get(apiVersionHeader) {
+	case "1":
+		return true
+	case "2":
+		return false
+	}
+	return s.cfg.LegacyErrors
+}
+
+const apiVersionHeader = "API-Version"
+
+// writeError answers a failed request. envelope is the endpoint's response type with Status
+// "Exception"; code qualifies status and may be "" for the status's usual code.
+//
+// The body is an application/problem+json document (RFC 7807): the envelope's message becomes its
+// detail and the envelope's other fields become extension members, so requestUrn, corpus or
+// diagnostics are kept. Clients that want the legacy envelope get it with its legacy status.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, status int, code string, envelope any) {
+	if s.legacyErrors(r) {
+		writeJSON(w, legacyStatus(r, status, code), envelope)
+		return
+	}
+	writeJSONAs(w, status, "application/problem+json", problemFor(r, status, code, envelope))
+}
+
+// legacyStatus is the status an Exception envelope had before problems: a read that found nothing
+// answered 200, and a source that couldn't be loaded 502.
+func legacyStatus(r *http.Request, status int, code string) int {
+	if code == "" {
+		code = defaultCodes[status]
+	}
+	switch code {
+	case codeNotFound:
+		if r.Method == http.MethodGet {
+			return http.StatusOK
+		}
+	case codeCorpusNotFound, codeMissingBlock, codeSourceTimeout:
+		return http.StatusBadGateway
+	}
+	return status
+}
+
+func problemFor(r *http.Request, status int, code string, envelope any) map[string]any {
+	if code == "" {
+		code = defaultCodes[status]
+	}
+	if code == "" {
+		code = codeInternal
+	}
+	title := problemTitles[code]
+	if title == "" {
+		title = http.StatusText(status)
+	}
+
+	p := map[string]any{}
+	if b, err := json.Marshal(envelope); err == nil {
+		_ = json.Unmarshal(b, &p)
+	}
+	detail, _ := p["message"].(string)
+	delete(p, "status")
+	delete(p, "service")
+	delete(p, "message")
+	for k, v := range p {
+		if list, ok := v.([]any); v == nil || ok && len(list) == 0 {
+			delete(p, k) // an Exception envelope's empty nodes, entries or results
+		}
+	}
+	p["type"] = problemType + code
+	p["title"] = title
+	p["status"] = status
+	p["code"] = code
+	p["instance"] = r.URL.Path
+	if detail != "" {
+		p["detail"] = detail
+	}
+	return p
+}
+
+// resolveFailure is the status and code of a failed URN resolution.
+func resolveFailure(err error) (int, string) {
+	var pe *passageError
+	switch {
+	case errors.As(err, &pe):
+		return pe.status, pe.code
+	case errors.Is(err, cts.ErrInvalidAnchor):
+		return http.StatusBadRequest, codeInvalidAnchor
+	case errors.Is(err, cts.ErrInvalidRegex):
+		return http.StatusBadRequest, codeInvalidRegex
+	case errors.Is(err, cts.ErrInvalid):
+		return http.StatusBadRequest, codeInvalidURN
+	case errors.Is(err, cts.ErrNotFound):
+		return http.StatusNotFound, codeNotFound
+	}
+	return http.StatusInternalServerError, codeInternal
+}
+
+// missingBlockError is a source without a block an endpoint needs, e.g. missingBlockError("ctsdata").
+type missingBlockError string
+
+func (e missingBlockError) Error() string { return "missing #!" + string(e) }
+
+// errNoSource is a remote source that does not exist.
+var errNoSource = errors.New("no such source")
+
+// errBadCorpusName is a requested corpus name with path separators or "..".
+var errBadCorpusName = errors.New("invalid corpus name")
+
+// errSource is sourceFailure as an error, for helpers that hand it to resolveFailure.
+func errSource(err error) error {
+	status, code, msg := sourceFailure(err)
+	return &passageError{status: status, code: code, msg: msg}
+}
+
+// sourceFailure is the status, code and message of a corpus that couldn't be loaded. The message
+// gives the cause.
+func sourceFailure(err error) (int, string, string) {
+	var mb missingBlockError
+	switch {
+	case errors.Is(err, errBadCorpusName):
+		return http.StatusBadRequest, codeInvalidParam, "Couldn't open source: " + err.Error()
+	case errors.As(err, &mb):
+		return http.StatusNotFound, codeMissingBlock, "The source has no #!" + string(mb) + " block."
+	case errors.Is(err, errNoDSE) || errors.Is(err, errNoORCA):
+		return http.StatusNotFound, codeNotFound, "The source has " + err.Error() + "."
+	case errors.Is(err, fs.ErrNotExist) || errors.Is(err, errNoSource):
+		return http.StatusNotFound, codeCorpusNotFound, "Couldn't open source: " + err.Error()
+	case errors.Is(err, errSourceTooLarge):
+		return http.StatusBadGateway, codeSourceTooLarge, "Couldn't load source: " + err.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, codeSourceTimeout, "Timed out loading source: " + err.Error()
+	}
+	return http.StatusBadGateway, codeSourceFailed, "Couldn't load source: " + err.Error()
+}
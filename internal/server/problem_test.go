@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLegacyErrorStatuses(t *testing.T) {
+	_, h := newTestServer(t)
+	for _, c := range []struct {
+		method, target string
+		token          string
+		want, legacy   int
+	}{
+		{method: "GET", target: "/texts/" + hdt + "9.9", want: 404, legacy: 200},
+		{method: "GET", target: "/texts/urns/" + hdt + "9", want: 404, legacy: 200},
+		{method: "GET", target: "/texts/previous/" + hdt + "9.9", want: 404, legacy: 200},
+		{method: "GET", target: "/iiif/images/" + iliad + "1.3", want: 404, legacy: 200},
+		{method: "GET", target: "/texts/not-a-urn", want: 400, legacy: 400},
+		{method: "GET", target: "/nosuchcorpus/texts/catalog", want: 404, legacy: 502},
+		{method: "DELETE", target: "/corpora/nosuchcorpus", token: "tok-test", want: 404, legacy: 404},
+	} {
+		for _, version := range []string{"", "1"} {
+			req := httptest.NewRequest(c.method, c.target, nil)
+			if version != "" {
+				req.Header.Set(apiVersionHeader, version)
+			}
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			want, wantType, wantStatus := c.want, "application/problem+json", any(float64(c.want))
+			if version == "1" {
+				want, wantType, wantStatus = c.legacy, "application/json", "Exception"
+			}
+			var body map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%s %s (API-Version %q): %v", c.method, c.target, version, err)
+			}
+			if rec.Code != want || !strings.HasPrefix(rec.Header().Get("Content-Type"), wantType) || body["status"] != wantStatus {
+				t.Errorf("%s %s (API-Version %q): %d %s with status %v, want %d %s with status %v",
+					c.method, c.target, version, rec.Code, rec.Header().Get("Content-Type"), body["status"], want, wantType, wantStatus)
+			}
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Reading renderings of /texts/{URN}: plain text, Markdown and a minimal HTML page. Like TEI they
+// work on the resolved nodes grouped by work, using the catalog for titles and level names.
+
+var (
+	formatText     = outputFormat{"text", []string{"text/plain"}}
+	formatMarkdown = outputFormat{"markdown", []string{"text/markdown", "text/x-markdown"}}
+	formatHTML     = outputFormat{"html", []string{"text/html", "application/xhtml+xml"}}
+)
+
+// workNodes is one work's nodes in response order, with what its catalog entry says about it.
+type workNodes struct {
+	stem    string
+	scheme  []string // citation levels, e.g. ["book", "line"]; nil if uncatalogued
+	title   string   // workTitle, or the work URN
+	author  string   // groupName
+	edition string   // versionLabel and exemplarLabel
+	lang    string   // catalog language, "" if not recorded
+	nodes   []Node
+}
+
+// groupWorks splits nodes by work, in order of first appearance, and attaches catalog entries.
+func groupWorks(nodes []Node, catalog []CatalogEntry) []*workNodes {
+	var works []*workNodes
+	byStem := map[string]*workNodes{}
+	for _, n := range nodes {
+		stem := workStem(n.URN[0])
+		wn, ok := byStem[stem]
+		if !ok {
+			wn = &workNodes{stem: stem, title: stem}
+			if i := catalogIndex(catalog, stem); i >= 0 {
+				e := catalog[i]
+				if e.CitationScheme != "" {
+					wn.scheme = strings.Split(e.CitationScheme, ".")
+				}
+				if e.WorkTitle != "" {
+					wn.title = e.WorkTitle
+				}
+				wn.author = e.GroupName
+				wn.edition = strings.TrimSpace(e.VersionLabel + " " + e.ExemplarLabel)
+				wn.lang = e.Lang
+			}
+			byStem[stem] = wn
+			works = append(works, wn)
+		}
+		wn.nodes = append(wn.nodes, n)
+	}
+	return works
+}
+
+// passageRef splits the passage reference of urn into its components (nil for a work-level URN).
+func passageRef(urn string) []string {
+	p := strings.Split(urn, ":")
+	if len(p) < 5 || p[4] == "" {
+		return nil
+	}
+	return strings.Split(p[4], ".")
+}
+
+func isVerseLevel(scheme []string, level int) bool {
+	if level >= len(scheme) {
+		return false
+	}
+	switch strings.ToLower(scheme[level]) {
+	case "line", "verse", "l":
+		return true
+	}
+	return false
+}
+
+// levelHeading is "Book 1.2" for ref[:level+1], or just the reference when the level has no name.
+func levelHeading(scheme []string, ref []string, level int) string {
+	label := strings.Join(ref[:level+1], ".")
+	if level < len(scheme) && scheme[level] != "" {
+		name := scheme[level]
+		first, size := utf8.DecodeRuneInString(name)
+		return string(unicode.ToUpper(first)) + name[size:] + " " + label
+	}
+	return label
+}
+
+// newLevels returns how many leading parent levels of ref are unchanged from the previous passage,
+// and records ref's parents as the new previous.
+func newLevels(prev *[]string, ref []string) int {
+	parents := ref[:max(len(ref)-1, 0)]
+	common := 0
+	for common < len(*prev) && common < len(parents) && (*prev)[common] == parents[common] {
+		common++
+	}
+	*prev = parents
+	return common
+}
+
+func byline(wn *workNodes) string {
+	var parts []string
+	for _, p := range []string{wn.author, wn.edition} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ---- text/plain ----
+
+// renderText joins the passages with sep (default a newline; "\n" and "\t" may be written escaped).
+// labels=true (or ref) prefixes each passage with its reference, labels=urn with its full URN.
+func renderText(nodes []Node, q url.Values) []byte {
+	sep := "\n"
+	if v, ok := q["sep"]; ok {
+		sep = strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(v[0])
+	}
+	labels := strings.ToLower(q.Get("labels"))
+	var b strings.Builder
+	for i, n := range nodes {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		switch labels {
+		case "urn":
+			b.WriteString(n.URN[0] + " ")
+		case "true", "1", "ref":
+			if ref := passageRef(n.URN[0]); ref != nil {
+				b.WriteString(strings.Join(ref, ".") + " ")
+			}
+		}
+		b.WriteString(strings.Join(n.Text, " "))
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// ---- Markdown ----
+
+// renderMarkdown writes a "#" heading per work and one heading level per citation level below it.
+// Passages at a line/verse level are hard-broken lines prefixed with their number.
+func renderMarkdown(nodes []Node, catalog []CatalogEntry) []byte {
+	var b strings.Builder
+	for i, wn := range groupWorks(nodes, catalog) {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("# " + markdownInline(wn.title) + "\n\n")
+		if by := byline(wn); by != "" {
+			b.WriteString("*" + markdownInline(by) + "*\n\n")
+		}
+		var prev []string
+		inVerse := false
+		for _, n := range wn.nodes {
+			ref := passageRef(n.URN[0])
+			text := markdownInline(strings.Join(n.Text, " "))
+			if ref == nil {
+				b.WriteString(markdownLineStart(text) + "\n\n")
+				continue
+			}
+			common := newLevels(&prev, ref)
+			if common < len(ref)-1 && inVerse {
+				b.WriteString("\n")
+				inVerse = false
+			}
+			for level := common; level < len(ref)-1; level++ {
+				b.WriteString(strings.Repeat("#", min(level+2, 6)) + " " + markdownInline(levelHeading(wn.scheme, ref, level)) + "\n\n")
+			}
+			last := len(ref) - 1
+			if isVerseLevel(wn.scheme, last) {
+				b.WriteString("**" + ref[last] + "** " + text + "  \n")
+				inVerse = true
+				continue
+			}
+			if inVerse {
+				b.WriteString("\n")
+				inVerse = false
+			}
+			b.WriteString(strings.Repeat("#", min(last+2, 6)) + " " + markdownInline(levelHeading(wn.scheme, ref, last)) + "\n\n")
+			b.WriteString(markdownLineStart(text) + "\n\n")
+		}
+	}
+	return []byte(strings.TrimRight(b.String(), "\n") + "\n")
+}
+
+// markdownEscaper backslash-escapes the characters that start emphasis, code, links or HTML.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`)
+
+// markdownInline escapes passage text and catalog labels so that they render literally.
+func markdownInline(s string) string { return markdownEscaper.Replace(s) }
+
+// markdownLineStart additionally escapes what would make an escaped text starting a line a heading,
+// quote, list item, setext underline or table row.
+func markdownLineStart(s string) string {
+	trimmed := strings.TrimLeft(s, " ")
+	lead := s[:len(s)-len(trimmed)]
+	if trimmed == "" {
+		return s
+	}
+	switch trimmed[0] {
+	case '#', '>', '-', '+', '=', '|', '~':
+		return lead + `\` + trimmed
+	}
+	digits := len(trimmed) - len(strings.TrimLeft(trimmed, "0123456789"))
+	if digits > 0 && digits < len(trimmed) && (trimmed[digits] == '.' || trimmed[digits] == ')') {
+		return lead + trimmed[:digits] + `\` + trimmed[digits:]
+	}
+	return s
+}
+
+// ---- HTML ----
+
+const readingCSS = `body{font-family:Georgia,serif;line-height:1.6;max-width:42em;margin:2em auto;padding:0 1em;color:#222}` +
+	`nav{display:flex;justify-content:space-between;font-family:sans-serif;font-size:.9em;margin:1em 0}` +
+	`.ref{color:#888;font-family:sans-serif;font-size:.75em;text-decoration:none;margin-right:.5em}` +
+	`.byline{font-style:italic;color:#555}.l{margin:0}footer{color:#888;font-family:sans-serif;font-size:.8em;margin-top:3em}`
+
+// renderHTML is a reading view of the passages with links to the neighbouring passages.
+func renderHTML(reqURN, version string, nodes []Node, catalog []CatalogEntry, link func(urn string) string) []byte {
+	works := groupWorks(nodes, catalog)
+	title := reqURN
+	if len(works) == 1 {
+		title = works[0].title
+		if ref := passageRef(reqURN); ref != nil {
+			title += " " + strings.Join(ref, ".")
+		}
+	}
+	var prev, next string
+	if len(nodes) > 0 {
+		if p := nodes[0].Previous; len(p) > 0 {
+			prev = p[0]
+		}
+		if n := nodes[len(nodes)-1].Next; len(n) > 0 {
+			next = n[0]
+		}
+	}
+	nav := func() string {
+		var b strings.Builder
+		b.WriteString("<nav>")
+		if prev != "" {
+			b.WriteString(`<a rel="prev" href="` + html.EscapeString(link(prev)) + `">&larr; ` + html.EscapeString(refLabel(prev)) + "</a>")
+		} else {
+			b.WriteString("<span></span>")
+		}
+		if next != "" {
+			b.WriteString(`<a rel="next" href="` + html.EscapeString(link(next)) + `">` + html.EscapeString(refLabel(next)) + " &rarr;</a>")
+		}
+		b.WriteString("</nav>\n")
+		return b.String()
+	}
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1">` + "\n")
+	b.WriteString("<title>" + html.EscapeString(title) + "</title>\n")
+	if prev != "" {
+		b.WriteString(`<link rel="prev" href="` + html.EscapeString(link(prev)) + "\">\n")
+	}
+	if next != "" {
+		b.WriteString(`<link rel="next" href="` + html.EscapeString(link(next)) + "\">\n")
+	}
+	b.WriteString("<style>" + readingCSS + "</style>\n</head>\n<body>\n")
+	b.WriteString(nav())
+	b.WriteString("<main>\n")
+	for _, wn := range works {
+		b.WriteString("<article>\n<h1>" + html.EscapeString(wn.title) + "</h1>\n")
+		if by := byline(wn); by != "" {
+			b.WriteString(`<p class="byline">` + html.EscapeString(by) + "</p>\n")
+		}
+		htmlPassages(&b, wn, wn.nodes, "", func(urn, label string) string {
+			return `<a class="ref" href="` + html.EscapeString(link(urn)) + `">` + html.EscapeString(label) + "</a>"
+		})
+		b.WriteString("</article>\n")
+	}
+	b.WriteString("</main>\n")
+	b.WriteString(nav())
+	b.WriteString("<footer>" + html.EscapeString(reqURN))
+	if version != "" {
+		b.WriteString(" &middot; corpus version " + html.EscapeString(version))
+	}
+	b.WriteString("</footer>\n</body>\n</html>\n")
+	return []byte(b.String())
+}
+
+// htmlHeading is a citation-level heading written by htmlPassages.
+type htmlHeading struct {
+	level int // 0 for the top citation level
+	id    string
+	text  string
+}
+
+// htmlPassages appends nodes (all of wn) as a heading per citation level above the passages and one
+// paragraph per passage, in markup that is also valid XHTML. Paragraph ids are idPrefix plus the
+// reference, heading ids idPrefix plus "h" and the reference; refMark renders the reference label.
+func htmlPassages(b *strings.Builder, wn *workNodes, nodes []Node, idPrefix string, refMark func(urn, label string) string) []htmlHeading {
+	var headings []htmlHeading
+	var prevParents []string
+	for _, n := range nodes {
+		ref := passageRef(n.URN[0])
+		text := html.EscapeString(strings.Join(n.Text, " "))
+		if ref == nil {
+			b.WriteString("<p>" + text + "</p>\n")
+			continue
+		}
+		for level := newLevels(&prevParents, ref); level < len(ref)-1; level++ {
+			hd := htmlHeading{level: level, id: idPrefix + "h" + strings.Join(ref[:level+1], "."), text: levelHeading(wn.scheme, ref, level)}
+			headings = append(headings, hd)
+			h := fmt.Sprint(min(level+2, 6))
+			b.WriteString("<h" + h + ` id="` + html.EscapeString(hd.id) + `">` + html.EscapeString(hd.text) + "</h" + h + ">\n")
+		}
+		label := strings.Join(ref, ".")
+		class := ""
+		if isVerseLevel(wn.scheme, len(ref)-1) {
+			class = ` class="l"`
+		}
+		b.WriteString(`<p` + class + ` id="` + html.EscapeString(idPrefix+label) + `">` + refMark(n.URN[0], label) + text + "</p>\n")
+	}
+	return headings
+}
+
+// refLabel is the passage reference of urn, or urn itself for a work-level URN.
+func refLabel(urn string) string {
+	if ref := passageRef(urn); ref != nil {
+		return strings.Join(ref, ".")
+	}
+	return urn
+}
+
+// passageLink returns a function linking to other passages of the same corpus, keeping the query
+// parameters that select the corpus, its version and the rendering.
+func (s *Server) passageLink(r *http.Request) func(urn string) string {
+	base := s.corpusBaseURL(r)
+	keep := url.Values{}
+	for _, k := range []string{"cex", "version", "asOf", "format"} {
+		if v := r.URL.Query().Get(k); v != "" {
+			keep.Set(k, v)
+		}
+	}
+	query := ""
+	if len(keep) > 0 {
+		query = "?" + keep.Encode()
+	}
+	return func(urn string) string {
+		return base + "/texts/" + url.PathEscape(urn) + query
+	}
+}
+
+// writeRendered sends a non-JSON rendering of a resolved passage request.
+func (s *Server) writeRendered(w http.ResponseWriter, r *http.Request, format outputFormat, source, reqURN string, nodes []Node) {
+	ctx := r.Context()
+	// works missing from (or without) a #!ctscatalog are titled by their URN
+	catalog, _ := s.parseCTSCatalog(ctx, source)
+	version := s.servedVersion(ctx, source)
+
+	var body []byte
+	switch format.name {
+	case formatTEI.name:
+		body = renderTEI(reqURN, version, nodes, catalog)
+	case formatText.name:
+		body = renderText(nodes, r.URL.Query())
+	case formatMarkdown.name:
+		body = renderMarkdown(nodes, catalog)
+	case formatHTML.name:
+		body = renderHTML(reqURN, version, nodes, catalog, s.passageLink(r))
+	}
+	if version != "" {
+		w.Header().Set("X-Corpus-Version", version)
+	}
+	w.Header().Set("Content-Type", format.contentType())
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
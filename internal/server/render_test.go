@@ -0,0 +1,33 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLevelHeadingUpperCasesFirstRune(t *testing.T) {
+	if got := levelHeading([]string{"βιβλίον", "line"}, []string{"1", "2"}, 0); got != "Βιβλίον 1" {
+		t.Errorf("levelHeading = %q, want %q", got, "Βιβλίον 1")
+	}
+}
+
+func TestRenderMarkdownEscapesPassageText(t *testing.T) {
+	nodes := []Node{
+		{URN: []string{"urn:cts:x:g.w.v:1"}, Text: []string{"# not a heading"}},
+		{URN: []string{"urn:cts:x:g.w.v:2"}, Text: []string{"> not a quote, *not* _emphasis_"}},
+		{URN: []string{"urn:cts:x:g.w.v:3"}, Text: []string{"- not a list"}},
+		{URN: []string{"urn:cts:x:g.w.v:4"}, Text: []string{"1984. not a list either"}},
+	}
+	got := string(renderMarkdown(nodes, []CatalogEntry{{URN: "urn:cts:x:g.w.v:", CitationScheme: "section", WorkTitle: "W*"}}))
+	for _, want := range []string{
+		"# W\\*\n",
+		"\n\\# not a heading\n",
+		"\n\\> not a quote, \\*not\\* \\_emphasis\\_\n",
+		"\n\\- not a list\n",
+		"\n1984\\. not a list either\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("markdown lacks %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/GhentCDH/annophis-text-service/cts"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultSearchContext is the runes of context either side of a hit, as in GraphQL and the CLI.
+const defaultSearchContext = 40
+
+// handleSearch finds a substring or /regex/ in the passage texts:
+// GET /texts/search?q=<pattern>[&within=<URN prefix>][&context=<runes>], paged like /texts/urns, as
+// JSON or a table of urn, anchor, sequence and text.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	source := pickSourceFromReq(s.cfg, chi.URLParam(r, "CEX"), q)
+	pattern := q.Get("q")
+	svc := "/texts/search"
+
+	format, err := negotiateFormat(w, r, searchFormats...)
+	var pg page
+	if err == nil {
+		pg, err = s.pageOf(q)
+	}
+	opts := cts.SearchOptions{Within: q.Get("within"), Context: defaultSearchContext}
+	if v := q.Get("context"); err == nil && v != "" {
+		if opts.Context, err = strconv.Atoi(v); err != nil || opts.Context < 0 {
+			err = fmt.Errorf("context %q is not a non-negative integer.", v)
+		}
+	}
+	if err == nil && pattern == "" {
+		err = errors.New("Give a substring or /regex/ to search for as ?q=.")
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "", SearchResponse{
+			Pattern: pattern, Status: "Exception", Service: svc, Message: err.Error(),
+		})
+		return
+	}
+
+	allURNs, allTexts, err := s.parseCTSData(ctx, source)
+	if err != nil {
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, SearchResponse{
+			Pattern: pattern, Status: "Exception", Service: svc, Message: msg,
+		})
+		return
+	}
+	hits, err := (&cts.Corpus{URNs: allURNs, Texts: allTexts}).Search(pattern, opts)
+	if err != nil {
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, SearchResponse{
+			Pattern: pattern, Status: "Exception", Service: svc, Message: err.Error(),
+		})
+		return
+	}
+
+	version := s.servedVersion(ctx, source)
+	var total int
+	var next string
+	if pg.paged() {
+		total = len(hits)
+		next = s.nextPage(r, pg, total, version)
+		setPageHeaders(w, total, next)
+		start, end := pg.bounds(total)
+		hits = hits[start:end]
+	}
+	if isTableFormat(format) {
+		t := startTable(w, format, downloadName(chi.URLParam(r, "CEX"), "search"), version, "urn", "anchor", "sequence", "text")
+		for _, h := range hits {
+			if t.row(h.URN, h.Anchor, h.Sequence, h.Text) != nil {
+				return
+			}
+		}
+		t.close()
+		return
+	}
+	list, err := startJSONList(w, http.StatusOK, SearchResponse{
+		Pattern: pattern, Status: "Success", Service: svc, Total: total, Next: next,
+	}, "hits")
+	if err != nil {
+		return
+	}
+	for _, h := range hits {
+		if list.item(h) != nil {
+			return
+		}
+	}
+	list.close(version)
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -15,12 +16,22 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	graphql "github.com/graph-gophers/graphql-go"
 )
 
 type Server struct {
-	cfg        ServerConfig
-	httpClient *http.Client
-	cache      *cexCache
+	cfg          ServerConfig
+	httpClient   *http.Client // short requests: health checks, IIIF info.json
+	sourceClient *http.Client // CEX downloads; no overall timeout so large sources can stream
+	cache        *cexCache
+	corpora      *corpusCache
+	git          *gitRepo   // nil unless git.repo is configured
+	writeMu      sync.Mutex // serialises changes to data_dir
+	historyMu    sync.Mutex
+	histories    map[string]*historyLog // by stored source
+	openAPI      map[string]any         // served at /openapi.json
+	graphQL      *graphql.Schema        // served at /graphql
+	quiet        bool                   // no request log (in-process use by the CLI)
 }
 
 type cexCache struct {
@@ -47,15 +58,38 @@ func LoadConfiguration(file string) (ServerConfig, error) {
 }
 
 func NewServer(cfg ServerConfig) *Server {
+	ttl := 2 * time.Minute
+	if d, err := time.ParseDuration(cfg.CacheTTL); err == nil && d > 0 {
+		ttl = d
+	}
+	keep := cfg.KeepVersions
+	if keep <= 0 {
+		keep = 3
+	}
 	return &Server{
 		cfg: cfg,
 		httpClient: &http.Client{
 			Timeout: 15 * time.Second,
 		},
+		sourceClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: 15 * time.Second,
+			},
+		},
 		cache: &cexCache{
 			data: make(map[string]cacheEntry),
-			ttl:  2 * time.Minute,
+			ttl:  ttl,
 		},
+		corpora: &corpusCache{
+			ttl:      ttl,
+			entries:  make(map[string]corpusEntry),
+			inflight: make(map[string]*corpusLoad),
+			history:  make(map[string][]*corpus),
+			keep:     keep,
+		},
+		git:       newGitRepo(cfg.Git),
+		histories: make(map[string]*historyLog),
 	}
 }
 
@@ -79,8 +113,20 @@ func pickSource(cfg ServerConfig, cex string, q url.Values) string {
 	if cex == "" {
 		cex = strings.TrimSpace(q.Get("cex"))
 	}
+	cex, _, _ = strings.Cut(cex, "@") // "{CEX}@{version}"
+	if _, ok := cfg.Virtual[cex]; ok && cex != "" {
+		return virtualScheme + cex
+	}
+	if cex != "" && !validCEXName(cex) {
+		return invalidScheme + cex
+	}
+	if p := storedSource(cfg, cex); p != "" {
+		if _, err := os.Stat(p); err == nil {
+			return p // uploaded over HTTP
+		}
+	}
 	base := strings.TrimSpace(cfg.Source)
-	if strings.HasSuffix(strings.ToLower(base), ".cex") {
+	if isCEXFileName(base) {
 		return base
 	}
 	if base != "" {
@@ -88,14 +134,55 @@ func pickSource(cfg ServerConfig, cex string, q url.Values) string {
 			base += "/"
 		}
 		if cex != "" {
-			return base + cex + ".cex"
+			if isCEXFileName(cex) {
+				// e.g. "iliad.cex.gz" or "bundle.zip#iliad.cex"
+				return base + cex
+			}
+			ext := cfg.Extension
+			if ext == "" {
+				ext = ".cex"
+			}
+			return base + cex + ext
 		}
 	}
 	return cfg.TestSource
 }
 
-// checkSourceReachable tries HEAD first then a 1-byte GET. Live (no cache).
+// invalidScheme marks a requested corpus name that would leave the corpus directory; opening it fails
+// with errBadCorpusName instead of falling back to another corpus.
+const invalidScheme = "invalid:"
+
+// validCEXName reports whether a requested corpus name is a plain name, as for stored corpora, or an
+// archive with its members ("bundle.zip#books/iliad.cex").
+func validCEXName(name string) bool {
+	container, members := splitArchiveSource(name)
+	if !corpusNameRe.MatchString(container) || strings.Contains(container, "..") {
+		return false
+	}
+	return !strings.Contains(members, `\`) && !slices.Contains(strings.Split(members, "/"), "..")
+}
+
+// checkSourceReachable tries HEAD first then a 1-byte GET; local sources are stat'ed. Live (no cache).
 func (s *Server) checkSourceReachable(ctx context.Context, u string) error {
+	if strings.HasPrefix(u, virtualScheme) {
+		return s.checkVirtualReachable(ctx, u)
+	}
+	if name, ok := strings.CutPrefix(u, invalidScheme); ok {
+		return fmt.Errorf("%q: %w", name, errBadCorpusName)
+	}
+	u, _ = splitArchiveSource(u)
+	if isGitSource(u) {
+		pinned, err := s.pinSource(ctx, u)
+		if err != nil {
+			return err
+		}
+		commit, path := splitGitSource(pinned)
+		return s.git.exists(ctx, commit, path)
+	}
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		_, err := os.Stat(strings.TrimPrefix(u, "file://"))
+		return err
+	}
 	ua := "annophis-text-service/1.0"
 	if req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil); err == nil {
 		req.Header.Set("User-Agent", ua)
@@ -149,15 +236,27 @@ func (s *Server) getContent(ctx context.Context, u string) ([]byte, error) {
 	return body, nil
 }
 
-func BuildRouter(s *Server) http.Handler {
+// BuildRouter registers every route of s. It fails when the routes and /openapi.json disagree.
+func BuildRouter(s *Server) (http.Handler, error) {
 	r := chi.NewRouter()
-	r.Use(middleware.RequestID, middleware.RealIP, middleware.Logger, middleware.Recoverer, middleware.Timeout(30*time.Second))
+	r.Use(middleware.RequestID, middleware.RealIP)
+	if !s.quiet {
+		r.Use(middleware.Logger)
+	}
+	r.Use(middleware.Recoverer, middleware.Timeout(30*time.Second))
+	r.Use(s.withCommit)
+	s.openAPI = buildOpenAPI(apiOperations)
+	s.graphQL = newGraphQLSchema(s)
+	if s.cfg.CheckResponses {
+		r.Use(s.checkResponses(r))
+	}
 
 	origins := strings.Split(strings.TrimSpace(os.Getenv("ORIGIN_ALLOWED")), ",")
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   origins,
-		AllowedMethods:   []string{"GET", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Content-Type", "X-Requested-With"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Requested-With", apiVersionHeader},
+		ExposedHeaders:   []string{commitHeader, "X-Corpus-Version", "Content-Disposition", "X-Total-Count", "Link"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
@@ -166,55 +265,103 @@ func BuildRouter(s *Server) http.Handler {
 	r.Get("/", s.handleCiteVersion)
 	r.Get("/cite", s.handleCiteVersion)
 	r.Get("/texts/version", s.handleTextsVersion)
+	r.Get("/openapi.json", s.handleOpenAPI)
 
 	// Base (no explicit CEX) — uses pickSource fallback logic
-	r.Get("/texts", s.handleWorkURNs)
-	r.Get("/texts/catalog", s.handleCatalog)
-	r.Get("/texts/first/{URN}", s.handleFirst)
-	r.Get("/texts/last/{URN}", s.handleLast)
-	r.Get("/texts/previous/{URN}", s.handlePrev)
-	r.Get("/texts/next/{URN}", s.handleNext)
-	r.Get("/texts/urns/{URN}", s.handleURNs)
-	r.Get("/texts/{URN}", s.handlePassage)
-
-	// With {CEX} directory base
+	r.Group(func(r chi.Router) {
+		r.Use(s.withVersion)
+		r.Get("/texts", s.handleWorkURNs)
+		r.Get("/texts/catalog", s.handleCatalog)
+		r.Get("/texts/validate", s.handleValidate)
+		r.Get("/texts/first/{URN}", s.handleFirst)
+		r.Get("/texts/last/{URN}", s.handleLast)
+		r.Get("/texts/previous/{URN}", s.handlePrev)
+		r.Get("/texts/next/{URN}", s.handleNext)
+		r.Get("/texts/urns/{URN}", s.handleURNs)
+		r.Get("/texts/history/{URN}", s.handleHistory)
+		r.Get("/texts/export/{URN}", s.handleExport)
+		r.Get("/texts/search", s.handleSearch)
+		r.Post("/texts/batch", s.handleBatch)
+		r.Get("/texts/{URN}", s.handlePassage)
+		r.Put("/texts/{URN}", s.handlePutPassage)
+		r.Patch("/texts/{URN}", s.handlePutPassage)
+		r.Get("/iiif/manifest/{URN}", s.handleIIIFManifest)
+		r.Get("/iiif/images/{URN}", s.handleIIIFImages)
+		r.Get("/orca/text/{URN}", s.handleORCAByText)
+		r.Get("/orca/analysis/{URN}", s.handleORCAByAnalysis)
+		r.Get("/graphql", s.handleGraphQL)
+		r.Post("/graphql", s.handleGraphQL)
+	})
+
+	// With {CEX} directory base, optionally pinned as {CEX}@{version}
 	r.Route("/{CEX}", func(r chi.Router) {
+		r.Use(s.withVersion)
 		r.Get("/texts", s.handleWorkURNs)
 		r.Get("/texts/catalog", s.handleCatalog)
+		r.Get("/texts/validate", s.handleValidate)
 		r.Get("/texts/first/{URN}", s.handleFirst)
 		r.Get("/texts/last/{URN}", s.handleLast)
 		r.Get("/texts/previous/{URN}", s.handlePrev)
 		r.Get("/texts/next/{URN}", s.handleNext)
 		r.Get("/texts/urns/{URN}", s.handleURNs)
+		r.Get("/texts/history/{URN}", s.handleHistory)
+		r.Get("/texts/export/{URN}", s.handleExport)
+		r.Get("/texts/search", s.handleSearch)
+		r.Post("/texts/batch", s.handleBatch)
 		r.Get("/texts/{URN}", s.handlePassage)
+		r.Put("/texts/{URN}", s.handlePutPassage)
+		r.Patch("/texts/{URN}", s.handlePutPassage)
+		r.Get("/iiif/manifest/{URN}", s.handleIIIFManifest)
+		r.Get("/iiif/images/{URN}", s.handleIIIFImages)
+		r.Get("/orca/text/{URN}", s.handleORCAByText)
+		r.Get("/orca/analysis/{URN}", s.handleORCAByAnalysis)
+		r.Get("/graphql", s.handleGraphQL)
+		r.Post("/graphql", s.handleGraphQL)
 	})
 
+	// write API
+	r.Put("/corpora/{CEX}", s.handlePutCorpus)
+	r.Delete("/corpora/{CEX}", s.handleDeleteCorpus)
+
+	// admin
+	r.Post("/admin/git/ref", s.handleGitRef)
+	r.Post("/admin/revert/{CEX}/{ID}", s.handleRevert)
+
 	// healthz
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		src := pickSource(s.cfg, "", r.URL.Query())
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
+		body := HealthResponse{Source: src}
+		if s.git != nil {
+			body.Ref, body.Commit, _ = s.git.current(ctx)
+		}
 		if err := s.checkSourceReachable(ctx, src); err != nil {
-			writeJSON(w, http.StatusServiceUnavailable, map[string]string{
-				"status":  "unhealthy",
-				"source":  src,
-				"message": err.Error(),
-			})
+			body.Status, body.Message = "unhealthy", err.Error()
+			writeJSON(w, http.StatusServiceUnavailable, body)
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]string{
-			"status": "ok",
-			"source": src,
-		})
+		body.Status = "ok"
+		if body.SkippedRows = s.corpora.skipped(); body.SkippedRows != nil {
+			body.Status, body.Message = "degraded", "Some loaded corpora have malformed rows that were left out."
+		}
+		writeJSON(w, http.StatusOK, body)
 	})
 
-	return r
+	if err := checkOpenAPICoverage(r, s.openAPI); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 // ---- small shared helpers (kept here so all handlers can use them) ----
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writeJSONAs(w, status, "application/json; charset=utf-8", v)
+}
+
+func writeJSONAs(w http.ResponseWriter, status int, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(v)
 }
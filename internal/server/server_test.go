@@ -0,0 +1,38 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealthzReportsSkippedRows(t *testing.T) {
+	dir := t.TempDir()
+	cex := "#!ctsdata\nurn:cts:demo:g.w.v:1.1#a\nurn:cts:demo:g.w.v:1.2 b\n"
+	if err := os.WriteFile(filepath.Join(dir, "broken.cex"), []byte(cex), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(ServerConfig{Source: dir + "/", TestSource: "testdata/demo.cex"})
+	s.quiet = true
+	h, err := BuildRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var health HealthResponse
+	if code := getJSON(t, h, "/healthz", &health); code != 200 || health.Status != "ok" {
+		t.Fatalf("before loading: %d %+v", code, health)
+	}
+	var res URNResponse
+	if code := getJSON(t, h, "/broken/texts/urns/urn:cts:demo:g.w.v:", &res); code != 200 || len(res.URN) != 1 {
+		t.Fatalf("urns: %d %+v", code, res)
+	}
+	if code := getJSON(t, h, "/healthz", &health); code != 200 || health.Status != "degraded" || len(health.SkippedRows) != 1 {
+		t.Errorf("after loading: %d %+v, want degraded with one source", code, health)
+	}
+	for source, n := range health.SkippedRows {
+		if n != 1 || filepath.Base(source) != "broken.cex" {
+			t.Errorf("skippedRows[%s] = %d, want 1 for broken.cex", source, n)
+		}
+	}
+}
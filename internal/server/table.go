@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Tabular downloads (CSV, TSV, NDJSON) and the streaming JSON writer. Rows are written as they are
+// produced, so a million-passage prefix never becomes one response value in memory.
+
+var (
+	formatCSV    = outputFormat{"csv", []string{"text/csv"}}
+	formatTSV    = outputFormat{"tsv", []string{"text/tab-separated-values"}}
+	formatNDJSON = outputFormat{"ndjson", []string{"application/x-ndjson", "application/ndjson", "application/jsonl"}}
+
+	tableFormats = []outputFormat{formatCSV, formatTSV, formatNDJSON}
+)
+
+func isTableFormat(f outputFormat) bool {
+	for _, t := range tableFormats {
+		if t.name == f.name {
+			return true
+		}
+	}
+	return false
+}
+
+// tableWriter writes rows of one fixed set of columns as CSV/TSV (with a header row) or as one JSON
+// object per line.
+type tableWriter struct {
+	bw   *bufio.Writer
+	csv  *csv.Writer // nil for NDJSON
+	keys [][]byte    // NDJSON: `"column":` per column
+	line []byte
+}
+
+// startTable sends the response headers and the header row. name is the download's file name
+// without extension.
+func startTable(w http.ResponseWriter, format outputFormat, name, version string, columns ...string) *tableWriter {
+	ext := map[string]string{"csv": ".csv", "tsv": ".tsv", "ndjson": ".ndjson"}[format.name]
+	w.Header().Set("Content-Type", format.contentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+ext+`"`)
+	if version != "" {
+		w.Header().Set("X-Corpus-Version", version)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	t := &tableWriter{bw: bufio.NewWriterSize(w, 64<<10)}
+	if format.name == formatNDJSON.name {
+		for _, c := range columns {
+			k, _ := json.Marshal(c)
+			t.keys = append(t.keys, append(k, ':'))
+		}
+		return t
+	}
+	t.csv = csv.NewWriter(t.bw)
+	if format.name == formatTSV.name {
+		t.csv.Comma = '\t'
+	}
+	t.csv.Write(columns)
+	return t
+}
+
+// row writes one row; values are strings, ints or bools in column order.
+func (t *tableWriter) row(values ...any) error {
+	if t.csv != nil {
+		rec := make([]string, len(values))
+		for i, v := range values {
+			switch v := v.(type) {
+			case string:
+				rec[i] = v
+			case int:
+				rec[i] = strconv.Itoa(v)
+			case bool:
+				rec[i] = strconv.FormatBool(v)
+			default:
+				rec[i] = fmt.Sprint(v)
+			}
+		}
+		if err := t.csv.Write(rec); err != nil {
+			return err
+		}
+		return t.csv.Error()
+	}
+	t.line = append(t.line[:0], '{')
+	for i, v := range values {
+		if i > 0 {
+			t.line = append(t.line, ',')
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		t.line = append(append(t.line, t.keys[i]...), b...)
+	}
+	t.line = append(t.line, '}', '\n')
+	_, err := t.bw.Write(t.line)
+	return err
+}
+
+func (t *tableWriter) close() error {
+	if t.csv != nil {
+		t.csv.Flush()
+		if err := t.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return t.bw.Flush()
+}
+
+// jsonListWriter streams a response envelope whose list field is written item by item. The output is
+// what encoding the envelope with the list (and version) filled in would give.
+type jsonListWriter struct {
+	bw      *bufio.Writer
+	buf     bytes.Buffer
+	enc     *json.Encoder // into buf, reused across batches
+	pending []any
+	n       int
+}
+
+// jsonListBatch is how many items are encoded per Encode call, amortising its per-call overhead.
+const jsonListBatch = 256
+
+// startJSONList sends the headers and envelope, which must have its list and version fields empty,
+// up to the opening bracket of the list under key.
+func startJSONList(w http.ResponseWriter, status int, envelope any, key string) (*jsonListWriter, error) {
+	return startJSONListAs(w, status, formatJSON.contentType(), envelope, key)
+}
+
+func startJSONListAs(w http.ResponseWriter, status int, contentType string, envelope any, key string) (*jsonListWriter, error) {
+	head, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	l := &jsonListWriter{bw: bufio.NewWriterSize(w, 64<<10)}
+	l.enc = json.NewEncoder(&l.buf)
+	l.bw.Write(head[:len(head)-1])
+	l.bw.WriteString(`,"` + key + `":[`)
+	return l, nil
+}
+
+func (l *jsonListWriter) item(v any) error {
+	l.pending = append(l.pending, v)
+	if len(l.pending) < jsonListBatch {
+		return nil
+	}
+	return l.flushItems()
+}
+
+// flushItems encodes the pending items as an array and writes its elements.
+func (l *jsonListWriter) flushItems() error {
+	if len(l.pending) == 0 {
+		return nil
+	}
+	l.buf.Reset()
+	if err := l.enc.Encode(l.pending); err != nil {
+		return err
+	}
+	clear(l.pending)
+	l.pending = l.pending[:0]
+	elems := bytes.TrimSuffix(l.buf.Bytes(), []byte("\n"))
+	if l.n > 0 {
+		l.bw.WriteByte(',')
+	}
+	l.n++
+	_, err := l.bw.Write(elems[1 : len(elems)-1])
+	return err
+}
+
+// close ends the list and adds the version field, if any.
+func (l *jsonListWriter) close(version string) error {
+	if err := l.flushItems(); err != nil {
+		return err
+	}
+	l.bw.WriteByte(']')
+	if version != "" {
+		v, _ := json.Marshal(version)
+		l.bw.WriteString(`,"version":`)
+		l.bw.Write(v)
+	}
+	l.bw.WriteString("}\n")
+	return l.bw.Flush()
+}
+
+// streamPassage streams the nodes each resolves reqURN to as JSON or a table. The response is only
+// started by the first node, so a resolution error is still sent as a NodeResponse exception.
+func (s *Server) streamPassage(w http.ResponseWriter, r *http.Request, format outputFormat, source, reqURN string, each func(emit func(Node) error) error) {
+	svc := "/texts"
+	version := s.servedVersion(r.Context(), source)
+	var (
+		list  *jsonListWriter
+		table *tableWriter
+	)
+	err := each(func(n Node) error {
+		if isTableFormat(format) {
+			if table == nil {
+				table = startTable(w, format, downloadName(chi.URLParam(r, "CEX"), urnNameParts([]string{reqURN})...), version,
+					"urn", "sequence", "text", "complete")
+			}
+			return table.row(n.URN[0], n.Sequence, strings.Join(n.Text, " "), n.Complete)
+		}
+		if list == nil {
+			var err error
+			list, err = startJSONList(w, http.StatusOK, NodeResponse{
+				RequestUrn: []string{reqURN}, Status: "Success", Service: svc,
+			}, "nodes")
+			if err != nil {
+				return err
+			}
+		}
+		return list.item(n)
+	})
+	switch {
+	case table != nil:
+		table.close()
+	case list != nil:
+		list.close(version)
+	case err != nil:
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Exception", Service: svc, Message: err.Error(),
+		})
+	default:
+		writeJSON(w, http.StatusOK, NodeResponse{
+			RequestUrn: []string{reqURN}, Status: "Success", Service: svc, Version: version,
+		})
+	}
+}
+
+// writeURNList streams the URNs at the given corpus positions on page p as a URNResponse or a table.
+func (s *Server) writeURNList(w http.ResponseWriter, r *http.Request, format outputFormat, version, reqURN string, allURNs []string, idx []int, p page) {
+	var total int
+	var next string
+	if p.paged() {
+		total = len(idx)
+		next = s.nextPage(r, p, total, version)
+		setPageHeaders(w, total, next)
+		start, end := p.bounds(total)
+		idx = idx[start:end]
+	}
+	if isTableFormat(format) {
+		t := startTable(w, format, downloadName(chi.URLParam(r, "CEX"), urnNameParts([]string{reqURN})...), version, "urn", "sequence")
+		for _, i := range idx {
+			if t.row(allURNs[i], i+1) != nil {
+				return
+			}
+		}
+		t.close()
+		return
+	}
+	list, err := startJSONList(w, http.StatusOK, URNResponse{
+		RequestUrn: []string{reqURN}, Status: "Success", Service: "/texts/urns", Total: total, Next: next,
+	}, "urns")
+	if err != nil {
+		return
+	}
+	for _, i := range idx {
+		if list.item(allURNs[i]) != nil {
+			return
+		}
+	}
+	list.close(version)
+}
+
+// writeCatalogTable sends the catalog entries as a table.
+func writeCatalogTable(w http.ResponseWriter, r *http.Request, format outputFormat, version string, entries []CatalogEntry) {
+	t := startTable(w, format, downloadName(chi.URLParam(r, "CEX"), "catalog"), version,
+		"urn", "citationScheme", "groupName", "workTitle", "versionLabel", "exemplarLabel", "online", "lang")
+	for _, e := range entries {
+		if t.row(e.URN, e.CitationScheme, e.GroupName, e.WorkTitle, e.VersionLabel, e.ExemplarLabel, e.Online, e.Lang) != nil {
+			return
+		}
+	}
+	t.close()
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// TEI P5 rendering of /texts/{URN}. Each work becomes a <TEI> document whose header comes from its
+// #!ctscatalog entry and whose body nests one <div type="textpart"> per citation level, following
+// the CapiTainS conventions so eXist-db and TEI Publisher can resolve the references. Passages at a
+// "line" or "verse" level become <l>, others a <p> inside their div. Several works are wrapped in a
+// <teiCorpus>.
+
+const teiNS = "http://www.tei-c.org/ns/1.0"
+
+// renderTEI returns the TEI document (or corpus) for the resolved nodes of reqURN.
+func renderTEI(reqURN, version string, nodes []Node, catalog []CatalogEntry) []byte {
+	t := &teiBuilder{}
+	t.raw(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	works := groupWorks(nodes, catalog)
+	if len(works) == 1 {
+		t.work(works[0], version, true)
+		return t.Bytes()
+	}
+	t.open("teiCorpus", "xmlns", teiNS)
+	t.open("teiHeader")
+	t.open("fileDesc")
+	t.open("titleStmt")
+	t.leaf("title", "Texts for "+reqURN)
+	t.close()
+	t.open("publicationStmt")
+	t.leafAttr("idno", reqURN, "type", "CTS-URN")
+	if version != "" {
+		t.leafAttr("idno", version, "type", "corpus-version")
+	}
+	t.close()
+	t.open("sourceDesc")
+	t.leaf("p", "Converted from CEX.")
+	t.close()
+	t.close()
+	t.close()
+	for _, tw := range works {
+		t.work(tw, version, false)
+	}
+	t.close()
+	return t.Bytes()
+}
+
+func (t *teiBuilder) work(tw *workNodes, version string, root bool) {
+	scheme := tw.scheme
+	depth := len(scheme)
+	for _, n := range tw.nodes {
+		depth = max(depth, len(passageRef(n.URN[0])))
+	}
+
+	if root {
+		t.open("TEI", "xmlns", teiNS)
+	} else {
+		t.open("TEI")
+	}
+	t.open("teiHeader")
+	t.open("fileDesc")
+	t.open("titleStmt")
+	t.leaf("title", tw.title)
+	if tw.author != "" {
+		t.leaf("author", tw.author)
+	}
+	t.close()
+	if tw.edition != "" {
+		t.open("editionStmt")
+		t.leaf("edition", tw.edition)
+		t.close()
+	}
+	t.open("publicationStmt")
+	t.leafAttr("idno", tw.stem, "type", "CTS-URN")
+	if version != "" {
+		t.leafAttr("idno", version, "type", "corpus-version")
+	}
+	t.close()
+	t.open("sourceDesc")
+	t.leaf("p", "Converted from CEX.")
+	t.close()
+	t.close()
+	t.open("encodingDesc")
+	t.open("refsDecl", "n", "CTS")
+	for level := depth; level >= 1; level-- {
+		t.cRefPattern(tw.stem, scheme, level)
+	}
+	t.close()
+	t.close()
+	t.close()
+
+	t.open("text")
+	t.open("body")
+	t.open("div", "type", "edition", "n", tw.stem)
+	var open []string // ref components of the enclosing textpart divs
+	for _, n := range tw.nodes {
+		ref := passageRef(n.URN[0])
+		if len(ref) == 0 {
+			continue
+		}
+		parents := ref[:len(ref)-1]
+		common := 0
+		for common < len(open) && common < len(parents) && open[common] == parents[common] {
+			common++
+		}
+		for len(open) > common {
+			t.close()
+			open = open[:len(open)-1]
+		}
+		for len(open) < len(parents) {
+			k := len(open)
+			t.open("div", teiTextpart(scheme, k, parents[k])...)
+			open = append(open, parents[k])
+		}
+		text := strings.Join(n.Text, " ")
+		k := len(ref) - 1
+		if isVerseLevel(scheme, k) {
+			t.leafAttr("l", text, "n", ref[k])
+		} else {
+			t.open("div", teiTextpart(scheme, k, ref[k])...)
+			t.leaf("p", text)
+			t.close()
+		}
+	}
+	for range open {
+		t.close()
+	}
+	t.close()
+	t.close()
+	t.close()
+	t.close()
+}
+
+// cRefPattern writes the CapiTainS reference pattern for citations of the given depth.
+func (t *teiBuilder) cRefPattern(stem string, scheme []string, level int) {
+	groups := make([]string, level)
+	steps := make([]string, level)
+	for i := range level {
+		groups[i] = `([^.]+)`
+		steps[i] = fmt.Sprintf("tei:div[@n='$%d']", i+1)
+	}
+	if isVerseLevel(scheme, level-1) {
+		steps[level-1] = fmt.Sprintf("tei:l[@n='$%d']", level)
+	}
+	name := fmt.Sprintf("level%d", level)
+	if level <= len(scheme) {
+		name = scheme[level-1]
+	}
+	t.leafAttr("cRefPattern", "", "n", name,
+		"matchPattern", strings.Join(groups, `\.`),
+		"replacementPattern", "#xpath(/tei:TEI/tei:text/tei:body/tei:div[@n='"+stem+"']/"+strings.Join(steps, "/")+")")
+}
+
+func teiTextpart(scheme []string, level int, n string) []string {
+	attrs := []string{"type", "textpart"}
+	if level < len(scheme) && scheme[level] != "" {
+		attrs = append(attrs, "subtype", scheme[level])
+	}
+	return append(attrs, "n", n)
+}
+
+// teiBuilder writes indented XML with escaped text and attributes.
+type teiBuilder struct {
+	bytes.Buffer
+	stack []string
+}
+
+func (t *teiBuilder) raw(s string) { t.WriteString(s) }
+
+func (t *teiBuilder) indent() { t.WriteString(strings.Repeat("  ", len(t.stack))) }
+
+func (t *teiBuilder) attrs(kv []string) {
+	for i := 0; i+1 < len(kv); i += 2 {
+		t.WriteString(" " + kv[i] + `="` + xmlAttrEscaper.Replace(xmlClean(kv[i+1])) + `"`)
+	}
+}
+
+func (t *teiBuilder) open(name string, kv ...string) {
+	t.indent()
+	t.WriteString("<" + name)
+	t.attrs(kv)
+	t.WriteString(">\n")
+	t.stack = append(t.stack, name)
+}
+
+func (t *teiBuilder) close() {
+	name := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	t.indent()
+	t.WriteString("</" + name + ">\n")
+}
+
+func (t *teiBuilder) leaf(name, text string) { t.leafAttr(name, text) }
+
+// leafAttr writes <name attrs>text</name>, or an empty element when text is "".
+func (t *teiBuilder) leafAttr(name, text string, kv ...string) {
+	t.indent()
+	t.WriteString("<" + name)
+	t.attrs(kv)
+	if text == "" {
+		t.WriteString("/>\n")
+		return
+	}
+	t.WriteString(">" + xmlTextEscaper.Replace(xmlClean(text)) + "</" + name + ">\n")
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\n", "&#xA;", "\t", "&#x9;")
+)
+
+// xmlClean replaces characters XML 1.0 does not allow with U+FFFD.
+func xmlClean(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r < 0xD800 || r >= 0xE000 && r < 0xFFFE || r >= 0x10000 && r <= 0x10FFFF {
+			return r
+		}
+		return '\uFFFD'
+	}, s)
+}
@@ -1,74 +1,83 @@
 package server
 
-type Versions struct {
-	Texts          string `json:"texts"`
-	Textcatalog    string `json:"textcatalog,omitempty"`
-	Citedata       string `json:"citedata,omitempty"`
-	Citecatalog    string `json:"citecatalog,omitempty"`
-	Citerelations  string `json:"citerelations,omitempty"`
-	Citeextensions string `json:"citeextensions,omitempty"`
-	DSE            string `json:"dse,omitempty"`
-	ORCA           string `json:"orca,omitempty"`
-}
-
-type CITEResponse struct {
-	Status   string   `json:"status"`
-	Service  string   `json:"service"`
-	Versions Versions `json:"versions"`
-}
-
-type VersionResponse struct {
-	Status  string `json:"status"`
-	Service string `json:"service"`
-	Version string `json:"version"`
-}
+import "github.com/GhentCDH/annophis-text-service/api"
 
-type Node struct {
-	URN      []string `json:"urn"`
-	Text     []string `json:"text,omitempty"`
-	Previous []string `json:"previous,omitempty"`
-	Next     []string `json:"next,omitempty"`
-	Sequence int      `json:"sequence"`
-	Complete bool     `json:"complete"`
-}
+// The response types are public in package api, so clients share them.
+type (
+	Versions           = api.Versions
+	CITEResponse       = api.CITEResponse
+	VersionResponse    = api.VersionResponse
+	Node               = api.Node
+	NodeResponse       = api.NodeResponse
+	URNResponse        = api.URNResponse
+	BatchItem          = api.BatchItem
+	BatchResponse      = api.BatchResponse
+	CatalogEntry       = api.CatalogEntry
+	CatalogResponse    = api.CatalogResponse
+	SearchHit          = api.SearchHit
+	SearchResponse     = api.SearchResponse
+	IIIFImage          = api.IIIFImage
+	ImageResponse      = api.ImageResponse
+	ORCARecord         = api.ORCARecord
+	ORCAResponse       = api.ORCAResponse
+	Diagnostic         = api.Diagnostic
+	ValidationResponse = api.ValidationResponse
+	AdminResponse      = api.AdminResponse
+	WriteResponse      = api.WriteResponse
+	ChangeRecord       = api.ChangeRecord
+	HistoryResponse    = api.HistoryResponse
+	ErrorResponse      = api.ErrorResponse
+	Problem            = api.Problem
+	HealthResponse     = api.HealthResponse
+)
 
-type NodeResponse struct {
-	RequestUrn []string `json:"requestUrn"`
-	Status     string   `json:"status"`
-	Service    string   `json:"service"`
-	Message    string   `json:"message,omitempty"`
-	URN        []string `json:"urns,omitempty"`
-	Nodes      []Node   `json:"nodes,omitempty"`
+type DSERecord struct {
+	URN      string `json:"urn"`
+	Label    string `json:"label,omitempty"`
+	Passage  string `json:"passage"`
+	ImageROI string `json:"imageroi"`
+	Surface  string `json:"surface,omitempty"`
 }
 
-type URNResponse struct {
-	RequestUrn []string `json:"requestUrn"`
-	Status     string   `json:"status"`
-	Service    string   `json:"service"`
-	Message    string   `json:"message,omitempty"`
-	URN        []string `json:"urns,omitempty"`
+type IIIFConfig struct {
+	Images       map[string]string `json:"images"`        // CITE2 image collection URN → IIIF Image API service base
+	CanvasWidth  int               `json:"canvas_width"`  // used when info.json is unreachable
+	CanvasHeight int               `json:"canvas_height"` // used when info.json is unreachable
+	Profile      string            `json:"profile"`       // compliance level of the image servers, e.g. "level2" (default: from info.json, else level1)
 }
 
-type CatalogEntry struct {
-	URN            string `json:"urn"`
-	CitationScheme string `json:"citationScheme"`
-	GroupName      string `json:"groupName"`
-	WorkTitle      string `json:"workTitle"`
-	VersionLabel   string `json:"versionLabel,omitempty"`
-	ExemplarLabel  string `json:"exemplarLabel,omitempty"`
-	Online         bool   `json:"online"`
+// CorpusConfig holds per-corpus source settings; empty fields fall back to the global ones.
+type CorpusConfig struct {
+	Delimiter string `json:"delimiter,omitempty"` // "#", "|", "tab"; default: declared or detected
+	Encoding  string `json:"encoding,omitempty"`  // "utf-8" (default), "latin1", "windows-1252"
 }
 
-type CatalogResponse struct {
-	Status  string         `json:"status"`
-	Service string         `json:"service"`
-	Entries []CatalogEntry `json:"entries"`
-	Message string         `json:"message,omitempty"`
+type ServerConfig struct {
+	Host           string                  `json:"host"`
+	Port           string                  `json:"port"`
+	Source         string                  `json:"cex_source"`       // file, archive OR directory base
+	TestSource     string                  `json:"test_cex_source"`  // fallback for /texts without CEX
+	Extension      string                  `json:"cex_extension"`    // appended to {CEX} under a directory base (default ".cex")
+	PublicURL      string                  `json:"public_url"`       // external base URL for generated links (default: request host)
+	Delimiter      string                  `json:"delimiter"`        // default field delimiter when none is declared or detected
+	Encoding       string                  `json:"encoding"`         // default source encoding
+	Corpora        map[string]CorpusConfig `json:"corpora"`          // keyed by {CEX} name or full source
+	CacheTTL       string                  `json:"cache_ttl"`        // how long a parsed corpus is reused, e.g. "10m" (default 2m)
+	MaxSource      int64                   `json:"max_source_bytes"` // refuse sources larger than this (0 = unlimited)
+	IIIF           IIIFConfig              `json:"iiif"`
+	Git            GitConfig               `json:"git"`
+	AdminToken     string                  `json:"admin_token"`     // bearer token for /admin endpoints (unset = disabled)
+	Virtual        map[string][]string     `json:"virtual"`         // {CEX} name -> member {CEX} names or sources, merged in order
+	DataDir        string                  `json:"data_dir"`        // where corpora uploaded over HTTP are stored (unset = uploads disabled)
+	WriteTokens    map[string]string       `json:"write_tokens"`    // bearer token -> author name, for the write API
+	KeepVersions   int                     `json:"keep_versions"`   // previous corpus versions kept per source (default 3)
+	MaxPageSize    int                     `json:"max_page_size"`   // most passages or URNs per response; longer listings are paged (0 = unlimited)
+	LegacyErrors   bool                    `json:"legacy_errors"`   // answer errors with Exception envelopes instead of problem+json
+	CheckResponses bool                    `json:"check_responses"` // validate JSON responses against /openapi.json (development)
 }
 
-type ServerConfig struct {
-	Host       string `json:"host"`
-	Port       string `json:"port"`
-	Source     string `json:"cex_source"`      // file OR directory base
-	TestSource string `json:"test_cex_source"` // fallback for /texts without CEX
+// GitConfig points "git:" sources at a local repository (bare or working copy).
+type GitConfig struct {
+	Repo string `json:"repo"`
+	Ref  string `json:"ref"` // branch, tag or commit (default HEAD)
 }
@@ -0,0 +1,404 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/GhentCDH/annophis-text-service/internal/cex"
+	cite "github.com/ThomasK81/gocite"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// cexLine is one physical line of a CEX source split into fields, with the rune column each field starts at.
+type cexLine struct {
+	num    int
+	fields []string
+	cols   []int
+}
+
+func splitCEXLine(num int, line string, sep rune) cexLine {
+	l := cexLine{num: num}
+	col := 1
+	for {
+		i := strings.IndexRune(line, sep)
+		if i < 0 {
+			l.fields = append(l.fields, line)
+			l.cols = append(l.cols, col)
+			return l
+		}
+		l.fields = append(l.fields, line[:i])
+		l.cols = append(l.cols, col)
+		col += utf8.RuneCountInString(line[:i]) + 1
+		line = line[i+utf8.RuneLen(sep):]
+	}
+}
+
+// col returns the column of field i, or the column just past the last field.
+func (l cexLine) col(i int) int {
+	if i < len(l.cols) {
+		return l.cols[i]
+	}
+	last := len(l.fields) - 1
+	return l.cols[last] + utf8.RuneCountInString(l.fields[last])
+}
+
+// ValidateCEX reads a CEX source once and reports every problem it finds with its line and column.
+// Diagnostics are sorted by position; any diagnostic with severity "error" makes the source invalid.
+// The error is only for failures to read r.
+func ValidateCEX(r io.Reader, cc CorpusConfig) ([]Diagnostic, error) {
+	v := &cexValidator{
+		diags:     []Diagnostic{},
+		catalog:   map[string]catalogRow{},
+		seenURN:   map[string]int{},
+		workSeen:  map[string]bool{},
+		lastRef:   map[string][]string{},
+		closedRef: map[string]bool{},
+		members:   map[string]int{},
+	}
+
+	cr, err := cex.NewReader(r, cexOptions(cc))
+	if err != nil {
+		v.add(0, 0, severityError, "config", "%v", err)
+		return v.diags, nil
+	}
+	v.seen = cr.Seen()
+	for {
+		row, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if _, ok := v.members[row.Member]; !ok {
+			v.members[row.Member] = len(v.members)
+		}
+		v.member = row.Member
+		var le *cex.LineError
+		if errors.As(err, &le) {
+			v.add(le.Line, le.Col, severityError, le.Code, "%s", le.Msg)
+		} else if err != nil {
+			return nil, err
+		}
+		if row.Text != "" {
+			v.row(row, cr.Sep())
+		}
+	}
+	v.finish()
+
+	sort.SliceStable(v.diags, func(i, j int) bool {
+		if mi, mj := v.members[v.diags[i].Source], v.members[v.diags[j].Source]; mi != mj {
+			return mi < mj
+		}
+		if v.diags[i].Line != v.diags[j].Line {
+			return v.diags[i].Line < v.diags[j].Line
+		}
+		return v.diags[i].Column < v.diags[j].Column
+	})
+	return v.diags, nil
+}
+
+type catalogRow struct {
+	line   int
+	depth  int // components in the citation scheme
+	scheme string
+}
+
+// catalogCheck is a #!ctsdata row's lookup of its work in the catalog.
+type catalogCheck struct {
+	member    string
+	line, col int
+	urn, stem string
+	depth     int // citation levels of urn
+}
+
+type normLine struct {
+	member    string
+	line, col int
+	nfc       bool // composed (true) or decomposed (false)
+}
+
+type cexValidator struct {
+	diags   []Diagnostic
+	sep     rune
+	member  string         // archive member being read; diagnostics carry it as their source
+	members map[string]int // archive order of the members
+
+	seen         map[string]bool // blocks encountered so far (shared with the reader)
+	catalogWidth int
+	pending      []catalogCheck // catalog lookups of #!ctsdata rows seen before #!ctscatalog
+
+	catalog   map[string]catalogRow
+	seenURN   map[string]int
+	workSeen  map[string]bool
+	lastWork  string
+	lastRef   map[string][]string // per work, so resumed (interleaved) works are still order-checked
+	closedRef map[string]bool     // "<stem><ref prefix>" whose run of passages has ended
+
+	normLines []normLine
+}
+
+func (v *cexValidator) add(line, col int, sev, code, format string, args ...any) {
+	v.diags = append(v.diags, Diagnostic{
+		Source: v.member, Line: line, Column: col, Severity: sev, Code: code, Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *cexValidator) row(row cex.Row, sep rune) {
+	if sep == 0 {
+		sep = '#'
+	}
+	v.sep = sep
+	l := splitCEXLine(row.Num, row.Text, sep)
+	switch row.Section {
+	case "ctscatalog":
+		v.catalogLine(l, row.First)
+	case "ctsdata":
+		v.dataLine(l)
+	}
+}
+
+func (v *cexValidator) catalogLine(l cexLine, first bool) {
+	if first {
+		if strings.EqualFold(strings.TrimSpace(l.fields[0]), "urn") {
+			v.catalogWidth = len(l.fields)
+			return
+		}
+	}
+	want := v.catalogWidth
+	if want == 0 {
+		want = 8
+	}
+	if len(l.fields) != want {
+		v.add(l.num, l.col(min(len(l.fields), want)), severityError, "field-count",
+			"#!ctscatalog row has %d fields, want %d", len(l.fields), want)
+		if len(l.fields) < 4 {
+			return
+		}
+	}
+
+	urn := strings.TrimSpace(l.fields[0])
+	if !cite.IsCTSURN(urn) || !strings.HasSuffix(urn, ":") {
+		v.add(l.num, l.col(0), severityError, "invalid-urn", "%q is not a CTS work URN ending in ':'", urn)
+		return
+	}
+	if prev, ok := v.catalog[urn]; ok {
+		v.add(l.num, l.col(0), severityError, "duplicate-urn", "%s is already catalogued on line %d", urn, prev.line)
+		return
+	}
+	scheme := strings.TrimSpace(l.fields[1])
+	if scheme == "" {
+		v.add(l.num, l.col(1), severityError, "citation-scheme", "empty citation scheme for %s", urn)
+	}
+	v.catalog[urn] = catalogRow{line: l.num, depth: len(strings.Split(scheme, ".")), scheme: scheme}
+}
+
+func (v *cexValidator) dataLine(l cexLine) {
+	if len(l.fields) != 2 {
+		v.add(l.num, l.col(min(len(l.fields), 2)), severityError, "field-count",
+			"#!ctsdata row has %d fields, want 2 (urn%ctext)", len(l.fields), v.sep)
+		if len(l.fields) < 2 {
+			return
+		}
+	}
+	urn := strings.TrimSpace(l.fields[0])
+	text := l.fields[1]
+	if len(l.fields) > 2 {
+		// the parser keeps the rest of the line as the text
+		text = strings.Join(l.fields[1:], string(v.sep))
+	}
+
+	p := strings.Split(urn, ":")
+	if !cite.IsCTSURN(urn) || p[4] == "" || cite.IsRange(urn) || cite.WantSubstr(urn) {
+		v.add(l.num, l.col(0), severityError, "invalid-urn", "%q is not a CTS passage URN", urn)
+		return
+	}
+	if first, ok := v.seenURN[urn]; ok {
+		v.add(l.num, l.col(0), severityError, "duplicate-urn", "%s already appears on line %d", urn, first)
+	} else {
+		v.seenURN[urn] = l.num
+	}
+
+	stem := strings.Join(p[:4], ":") + ":"
+	ref := strings.Split(p[4], ".")
+	check := catalogCheck{member: v.member, line: l.num, col: l.col(0), urn: urn, stem: stem, depth: len(ref)}
+	if v.seen["ctscatalog"] {
+		v.catalogLookup(check)
+	} else {
+		v.pending = append(v.pending, check)
+	}
+
+	v.order(l, stem, ref)
+
+	v.normalisation(l.num, l.col(1), text)
+}
+
+func (v *cexValidator) catalogLookup(c catalogCheck) {
+	cat, ok := v.catalog[c.stem]
+	switch {
+	case !ok:
+		if v.seen["ctscatalog"] {
+			v.add(c.line, c.col, severityError, "uncatalogued-work", "work %s is missing from #!ctscatalog", c.stem)
+		}
+	case c.depth != cat.depth:
+		v.add(c.line, c.col, severityError, "citation-depth",
+			"%s has %d citation levels, but the scheme %q has %d", c.urn, c.depth, cat.scheme, cat.depth)
+	}
+}
+
+// order flags works that are split into several runs (interleaved) and citation prefixes that reappear after
+// their run ended or go backwards numerically (out of order).
+func (v *cexValidator) order(l cexLine, stem string, ref []string) {
+	if stem != v.lastWork {
+		if v.workSeen[stem] {
+			v.add(l.num, l.col(0), severityError, "interleaved-work",
+				"passages of %s resume after passages of %s", stem, v.lastWork)
+		}
+		v.workSeen[stem] = true
+		v.lastWork = stem
+	}
+
+	if last := v.lastRef[stem]; last != nil {
+		for k := 0; k < len(ref) && k < len(last); k++ {
+			if ref[k] == last[k] {
+				continue
+			}
+			// leaves need no bookkeeping: a repeated leaf is a duplicate URN
+			if k < len(last)-1 {
+				v.closedRef[stem+strings.Join(last[:k+1], ".")] = true
+			}
+			prefix := stem + strings.Join(ref[:k+1], ".")
+			if k < len(ref)-1 && v.closedRef[prefix] {
+				v.add(l.num, l.col(0), severityWarning, "out-of-order",
+					"%s reappears after other passages followed it", prefix)
+			} else if a, errA := strconv.Atoi(ref[k]); errA == nil {
+				if b, errB := strconv.Atoi(last[k]); errB == nil && a < b {
+					v.add(l.num, l.col(0), severityWarning, "out-of-order",
+						"%s follows %s", stem+strings.Join(ref, "."), stem+strings.Join(last, "."))
+				}
+			}
+			break
+		}
+	}
+	v.lastRef[stem] = ref
+}
+
+// normalisation records whether text is NFC or NFD; lines that are neither are reported immediately.
+func (v *cexValidator) normalisation(line, col int, text string) {
+	nfc := norm.NFC.IsNormalString(text)
+	nfd := norm.NFD.IsNormalString(text)
+	switch {
+	case nfc && nfd:
+		// nothing to compose or decompose
+	case nfc:
+		v.normLines = append(v.normLines, normLine{member: v.member, line: line, col: col, nfc: true})
+	case nfd:
+		v.normLines = append(v.normLines, normLine{member: v.member, line: line, col: col, nfc: false})
+	default:
+		v.add(line, col, severityWarning, "mixed-normalisation", "text mixes composed and decomposed characters")
+	}
+}
+
+func (v *cexValidator) finish() {
+	v.member = ""
+	if !v.seen["ctsdata"] {
+		v.add(0, 0, severityError, "missing-section", "missing #!ctsdata")
+	}
+	if !v.seen["ctscatalog"] {
+		v.add(0, 0, severityError, "missing-section", "missing #!ctscatalog")
+	}
+	for _, c := range v.pending {
+		v.member = c.member
+		v.catalogLookup(c)
+	}
+
+	// report the minority normalisation form, line by line
+	composed := 0
+	for _, n := range v.normLines {
+		if n.nfc {
+			composed++
+		}
+	}
+	decomposed := len(v.normLines) - composed
+	if composed == 0 || decomposed == 0 {
+		return
+	}
+	minorityNFC := composed < decomposed
+	form, other := "NFD", "NFC"
+	if minorityNFC {
+		form, other = "NFC", "NFD"
+	}
+	for _, n := range v.normLines {
+		if n.nfc == minorityNFC {
+			v.member = n.member
+			v.add(n.line, n.col, severityWarning, "mixed-normalisation",
+				"text is %s while most of the corpus is %s", form, other)
+		}
+	}
+}
+
+func summarise(diags []Diagnostic) (errs, warns int) {
+	for _, d := range diags {
+		if d.Severity == severityError {
+			errs++
+		} else {
+			warns++
+		}
+	}
+	return errs, warns
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cexName := chi.URLParam(r, "CEX")
+	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
+	svc := "/texts/validate"
+
+	var diags []Diagnostic
+	var err error
+	if strings.HasPrefix(source, virtualScheme) {
+		diags, err = s.validateVirtual(ctx, source)
+	} else {
+		diags, err = s.validateSource(ctx, source)
+	}
+	if err != nil {
+		status, code, msg := sourceFailure(err)
+		s.writeError(w, r, status, code, ValidationResponse{
+			Status: "Exception", Service: svc, Source: source, Message: msg,
+			Diagnostics: []Diagnostic{},
+		})
+		return
+	}
+	errs, warns := summarise(diags)
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	writeJSON(w, http.StatusOK, ValidationResponse{
+		Status:      "Success",
+		Service:     svc,
+		Source:      source,
+		Valid:       errs == 0,
+		Errors:      errs,
+		Warnings:    warns,
+		Diagnostics: diags,
+	})
+}
+
+func (s *Server) validateSource(ctx context.Context, source string) ([]Diagnostic, error) {
+	rc, err := s.openSource(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ValidateCEX(rc, s.corpusConfig(source))
+}
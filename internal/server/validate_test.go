@@ -0,0 +1,66 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testCatalog = "#!ctscatalog\n" +
+		"urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#lang\n" +
+		"urn:cts:demo:g.w.v:#book.line#G#W#V##true#grc\n" +
+		"urn:cts:demo:g.x.v:#book.line#G#X#V##true#grc\n"
+	workW = "urn:cts:demo:g.w.v:"
+	workX = "urn:cts:demo:g.x.v:"
+)
+
+// withData is the test catalog followed by a #!ctsdata block of rows, the first on line 7.
+func withData(rows ...string) string {
+	return testCatalog + "\n#!ctsdata\n" + strings.Join(rows, "\n") + "\n"
+}
+
+func TestValidateCEX(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		cex       string
+		code      string
+		line, col int
+	}{
+		{"clean", withData(workW+"1.1#a", workW+"1.2#b", workX+"1.1#c"), "", 0, 0},
+		{"encoding", withData(workW + "1.1#ab\xffc"), "encoding", 7, 26},
+		{"delimiter", "#!cexversion\n3.0\ndelimiter#;;\n\n" + withData(workW+"1.1#a"), "delimiter", 3, 1},
+		{"catalog field-count", strings.Replace(testCatalog, "##true#grc\n", "##true\n", 1) + "\n#!ctsdata\n" + workW + "1.1#a\n", "field-count", 3, 42},
+		{"data field-count", withData(workW + "1.1#a#b"), "field-count", 7, 26},
+		{"catalog invalid-urn", strings.Replace(testCatalog, "g.x.v:#", "g.x.v#", 1) + "\n#!ctsdata\n" + workW + "1.1#a\n", "invalid-urn", 4, 1},
+		{"data invalid-urn", withData(workW+"1.1#a", workW+"#b"), "invalid-urn", 8, 1},
+		{"catalog duplicate-urn", strings.Replace(testCatalog, "g.x.v:", "g.w.v:", 1) + "\n#!ctsdata\n" + workW + "1.1#a\n", "duplicate-urn", 4, 1},
+		{"data duplicate-urn", withData(workW+"1.1#a", workW+"1.1#b"), "duplicate-urn", 8, 1},
+		{"citation-scheme", strings.Replace(testCatalog, "g.x.v:#book.line#", "g.x.v:##", 1) + "\n#!ctsdata\n" + workW + "1.1#a\n", "citation-scheme", 4, 21},
+		{"uncatalogued-work", withData(workW+"1.1#a", "urn:cts:demo:g.y.v:1.1#b"), "uncatalogued-work", 8, 1},
+		{"citation-depth", withData(workW+"1.1#a", workW+"2#b"), "citation-depth", 8, 1},
+		{"interleaved-work", withData(workW+"1.1#a", workX+"1.1#b", workW+"1.2#c"), "interleaved-work", 9, 1},
+		{"out-of-order", withData(workW+"1.2#a", workW+"1.1#b"), "out-of-order", 8, 1},
+		{"reappearing out-of-order", withData(workW+"1.1#a", workW+"2.1#b", workW+"1.2#c"), "out-of-order", 9, 1},
+		{"mixed-normalisation", withData(workW + "1.1#café café"), "mixed-normalisation", 7, 24},
+		{"missing-section", testCatalog, "missing-section", 0, 0},
+
+		// rows before the catalog are order-checked where they stand; only their catalog lookups wait
+		{"data before catalog", "#!ctsdata\n" + workW + "1.1#a\n\n" + testCatalog + "\n#!ctsdata\n" + workW + "1.2#b\n", "", 0, 0},
+		{"out-of-order before catalog", "#!ctsdata\n" + workW + "1.2#a\n\n" + testCatalog + "\n#!ctsdata\n" + workW + "1.1#b\n", "out-of-order", 10, 1},
+		{"uncatalogued before catalog", "#!ctsdata\nurn:cts:demo:g.y.v:1.1#a\n\n" + testCatalog + "\n#!ctsdata\n" + workW + "1.1#b\n", "uncatalogued-work", 2, 1},
+	} {
+		diags, err := ValidateCEX(strings.NewReader(tc.cex), CorpusConfig{})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if tc.code == "" {
+			if len(diags) > 0 {
+				t.Errorf("%s: got %+v, want no diagnostics", tc.name, diags)
+			}
+			continue
+		}
+		if len(diags) != 1 || diags[0].Code != tc.code || diags[0].Line != tc.line || diags[0].Column != tc.col {
+			t.Errorf("%s: got %+v, want only %s at %d:%d", tc.name, diags, tc.code, tc.line, tc.col)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GhentCDH/annophis-text-service/cts"
+)
+
+// A virtual corpus is a {CEX} name configured under "virtual" whose texts come from several CEX files.
+// Members are {CEX} names (resolved like any other) or full sources. They are loaded and cached one
+// by one and merged in the configured order, each member keeping its own passage order.
+
+const virtualScheme = "virtual:"
+
+// virtualMembers returns the member sources of a "virtual:<name>" source, or nil.
+func virtualMembers(cfg ServerConfig, source string) []string {
+	name, ok := strings.CutPrefix(source, virtualScheme)
+	if !ok {
+		return nil
+	}
+	var out []string
+	for _, m := range cfg.Virtual[name] {
+		m = strings.TrimSpace(m)
+		if strings.Contains(m, "/") || strings.Contains(m, "://") || isGitSource(m) {
+			out = append(out, m)
+		} else {
+			out = append(out, pickSource(cfg, m, nil))
+		}
+	}
+	return out
+}
+
+// loadVirtual loads every member and returns their merge, re-merging only when a member has changed.
+func (s *Server) loadVirtual(ctx context.Context, source string) (*corpus, error) {
+	members := virtualMembers(s.cfg, source)
+	if len(members) == 0 {
+		return nil, fmt.Errorf("virtual corpus %s has no members", strings.TrimPrefix(source, virtualScheme))
+	}
+	parts := make([]*corpus, len(members))
+	h := sha256.New()
+	for i, m := range members {
+		if strings.HasPrefix(m, virtualScheme) {
+			return nil, fmt.Errorf("virtual corpus member %s is itself virtual", m)
+		}
+		c, err := s.loadCorpus(ctx, m)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		parts[i] = c
+		fmt.Fprintf(h, "%s %s\n", m, c.version)
+	}
+	version := hex.EncodeToString(h.Sum(nil))[:16]
+
+	cache := s.corpora
+	cache.mu.Lock()
+	e, ok := cache.entries[source]
+	cache.mu.Unlock()
+	if ok && e.c.version == version {
+		return e.c, nil
+	}
+	merged, err := mergeCorpora(members, parts)
+	if err != nil {
+		return nil, err
+	}
+	merged.version = version
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	merged = cache.remember(source, merged)
+	cache.entries[source] = corpusEntry{c: merged, at: time.Now()}
+	return merged, nil
+}
+
+// mergeCorpora concatenates the members' passages, catalogs and #!citedata blocks. A passage URN in
+// more than one member, or a work catalogued differently by two members, is an error.
+func mergeCorpora(names []string, parts []*corpus) (*corpus, error) {
+	merged := &corpus{Corpus: cts.Corpus{Sections: map[string]bool{}}}
+	owner := map[string]int{}
+	catOwner := map[string]int{}
+	var collisions []string
+	for i, c := range parts {
+		for j, u := range c.URNs {
+			if k, dup := owner[u]; dup {
+				collisions = append(collisions, fmt.Sprintf("%s (in %s and %s)", u, names[k], names[i]))
+				continue
+			}
+			owner[u] = i
+			merged.URNs = append(merged.URNs, u)
+			merged.Texts = append(merged.Texts, c.Texts[j])
+		}
+		for _, e := range c.Catalog {
+			if k, dup := catOwner[e.URN]; dup {
+				if idx := catalogIndex(merged.Catalog, e.URN); idx >= 0 && merged.Catalog[idx] != e {
+					collisions = append(collisions, fmt.Sprintf("%s catalogued differently (in %s and %s)", e.URN, names[k], names[i]))
+				}
+				continue
+			}
+			catOwner[e.URN] = i
+			merged.Catalog = append(merged.Catalog, e)
+		}
+		merged.CiteData = append(merged.CiteData, c.CiteData...)
+		for sec := range c.Sections {
+			merged.Sections[sec] = true
+		}
+	}
+	if len(collisions) > 0 {
+		more := ""
+		if len(collisions) > 5 {
+			more = fmt.Sprintf(" and %d more", len(collisions)-5)
+			collisions = collisions[:5]
+		}
+		return nil, fmt.Errorf("URN collision: %s%s", strings.Join(collisions, "; "), more)
+	}
+	return merged, nil
+}
+
+func catalogIndex(entries []CatalogEntry, urn string) int {
+	for i, e := range entries {
+		if e.URN == urn {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateVirtual validates each member on its own and reports URNs claimed by more than one member.
+func (s *Server) validateVirtual(ctx context.Context, source string) ([]Diagnostic, error) {
+	diags := []Diagnostic{}
+	owner := map[string]string{}
+	for _, m := range virtualMembers(s.cfg, source) {
+		rc, err := s.openSource(ctx, m)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		ds, err := ValidateCEX(rc, s.corpusConfig(m))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		for _, d := range ds {
+			if d.Source == "" {
+				d.Source = m // archive members name themselves
+			}
+			diags = append(diags, d)
+		}
+
+		c, err := s.loadCorpus(ctx, m)
+		if err != nil {
+			continue // already reported by ValidateCEX
+		}
+		for _, u := range c.URNs {
+			if first, dup := owner[u]; dup && first != m {
+				diags = append(diags, Diagnostic{
+					Source: m, Severity: severityError, Code: "collision",
+					Message: fmt.Sprintf("%s is also in %s", u, first),
+				})
+				continue
+			}
+			owner[u] = m
+		}
+	}
+	return diags, nil
+}
+
+// checkVirtualReachable reports the first unreachable member.
+func (s *Server) checkVirtualReachable(ctx context.Context, source string) error {
+	members := virtualMembers(s.cfg, source)
+	if len(members) == 0 {
+		return fmt.Errorf("virtual corpus %s has no members", strings.TrimPrefix(source, virtualScheme))
+	}
+	for _, m := range members {
+		if err := s.checkSourceReachable(ctx, m); err != nil {
+			return fmt.Errorf("%s: %w", m, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/GhentCDH/annophis-text-service/internal/cex"
+	"github.com/go-chi/chi/v5"
+)
+
+// Corpora uploaded over HTTP are stored as <data_dir>/<name>.cex and take precedence over cex_source.
+// Every change is validated before it is written, and the new corpus is served as soon as it is.
+
+var corpusNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+const defaultMaxUpload = 256 << 20
+
+// storedSource returns the data_dir file for corpus name, or "" if name can't be stored.
+func storedSource(cfg ServerConfig, name string) string {
+	if cfg.DataDir == "" || !corpusNameRe.MatchString(name) || strings.Contains(name, "..") {
+		return ""
+	}
+	return filepath.Join(cfg.DataDir, name+".cex")
+}
+
+func isStoredSource(cfg ServerConfig, source string) bool {
+	return cfg.DataDir != "" && filepath.Dir(source) == filepath.Clean(cfg.DataDir) && strings.HasSuffix(source, ".cex")
+}
+
+// requireWriter authenticates a bearer token from write_tokens (or admin_token) and returns its author.
+func (s *Server) requireWriter(w http.ResponseWriter, r *http.Request, svc string) (author string, ok bool) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for t, name := range s.cfg.WriteTokens {
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return name, true
+		}
+	}
+	if token != "" && s.cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) == 1 {
+		return "admin", true
+	}
+	if len(s.cfg.WriteTokens) == 0 && s.cfg.AdminToken == "" {
+		s.writeError(w, r, http.StatusForbidden, "", WriteResponse{Status: "Exception", Service: svc, Message: "Writes are disabled (no write_tokens configured)."})
+		return "", false
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="write"`)
+	s.writeError(w, r, http.StatusUnauthorized, "", WriteResponse{Status: "Exception", Service: svc, Message: "Missing or invalid write token."})
+	return "", false
+}
+
+// handlePutCorpus uploads or replaces a whole corpus: PUT /corpora/{CEX} with the CEX as the body.
+func (s *Server) handlePutCorpus(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "CEX")
+	svc := "/corpora"
+	author, ok := s.requireWriter(w, r, svc)
+	if !ok {
+		return
+	}
+	path := storedSource(s.cfg, name)
+	if path == "" {
+		s.writeUnavailable(w, r, svc, name)
+		return
+	}
+
+	limit := s.cfg.MaxSource
+	if limit <= 0 {
+		limit = defaultMaxUpload
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		status, code := http.StatusBadRequest, codeInvalidBody // the client went away or sent a malformed body
+		if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+			status, code = http.StatusRequestEntityTooLarge, ""
+		}
+		s.writeError(w, r, status, code, WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: "Couldn't read upload: " + err.Error()})
+		return
+	}
+
+	if bytes.HasPrefix(body, []byte{0xFF, 0xFE}) || bytes.HasPrefix(body, []byte{0xFE, 0xFF}) {
+		s.writeError(w, r, http.StatusUnsupportedMediaType, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: "Uploads must be UTF-8."})
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	old, err := s.readStored(path)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: "Couldn't read current corpus: " + err.Error()})
+		return
+	}
+	h, err := s.historyFor(path)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: "Couldn't read history: " + err.Error()})
+		return
+	}
+	c, ok := s.commitCorpus(w, r, svc, name, path, body, func(c *corpus) error {
+		return h.append(stamp(diffCorpora(old, c), name, author, changeReason(r, ""), c.version))
+	})
+	if !ok {
+		return
+	}
+	status := http.StatusOK
+	if old == nil {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, WriteResponse{Status: "Success", Service: svc, Corpus: name, Version: c.version})
+}
+
+// handleDeleteCorpus removes an uploaded corpus; the name falls back to cex_source afterwards.
+func (s *Server) handleDeleteCorpus(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "CEX")
+	svc := "/corpora"
+	author, ok := s.requireWriter(w, r, svc)
+	if !ok {
+		return
+	}
+	path := storedSource(s.cfg, name)
+	if path == "" {
+		s.writeUnavailable(w, r, svc, name)
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	old, err := s.readStored(path)
+	var h *historyLog
+	if err == nil {
+		h, err = s.historyFor(path)
+	}
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(path)
+	}
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		s.writeError(w, r, status, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: fmt.Sprintf("Couldn't delete %s.", name)})
+		return
+	}
+	if old != nil {
+		if err := h.append(stamp(diffCorpora(old, &corpus{}), name, author, changeReason(r, ""), "")); err != nil {
+			_ = writeFileAtomic(path, data) // keep the corpus rather than lose an unrecorded deletion
+			s.writeError(w, r, http.StatusInternalServerError, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: "Couldn't record deletion: " + err.Error()})
+			return
+		}
+	}
+	s.corpora.forget(path)
+	writeJSON(w, http.StatusOK, WriteResponse{Status: "Success", Service: svc, Corpus: name, Message: "Deleted."})
+}
+
+// handlePutPassage replaces the text of one passage of an uploaded corpus:
+// PUT /{CEX}/texts/{URN} with {"text": "..."} or a text/plain body.
+func (s *Server) handlePutPassage(w http.ResponseWriter, r *http.Request) {
+	cexName := chi.URLParam(r, "CEX")
+	source := pickSourceFromReq(s.cfg, cexName, r.URL.Query())
+	reqURN := pathURN(r)
+	svc := "/texts"
+	author, ok := s.requireWriter(w, r, svc)
+	if !ok {
+		return
+	}
+	if !isStoredSource(s.cfg, source) {
+		s.writeError(w, r, http.StatusConflict, "", WriteResponse{
+			Status: "Exception", Service: svc, URN: reqURN,
+			Message: fmt.Sprintf("%s is read-only; upload it with PUT /corpora/{CEX} to edit it here.", source),
+		})
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(source), ".cex")
+
+	text, reason, err := passageBody(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, codeInvalidBody, WriteResponse{Status: "Exception", Service: svc, Corpus: name, URN: reqURN, Message: err.Error()})
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	old, err := os.ReadFile(source)
+	if err != nil {
+		s.writeError(w, r, http.StatusNotFound, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, URN: reqURN, Message: "Couldn't read " + name + "."})
+		return
+	}
+	updated, prev, err := replacePassage(old, reqURN, text, s.corpusConfig(source))
+	if err != nil {
+		status, code := resolveFailure(err)
+		s.writeError(w, r, status, code, WriteResponse{Status: "Exception", Service: svc, Corpus: name, URN: reqURN, Message: err.Error()})
+		return
+	}
+	h, err := s.historyFor(source)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, URN: reqURN, Message: "Couldn't read history: " + err.Error()})
+		return
+	}
+	c, ok := s.commitCorpus(w, r, svc, name, source, updated, func(c *corpus) error {
+		if prev == text {
+			return nil
+		}
+		return h.append(stamp([]ChangeRecord{{URN: reqURN, Action: "edit", Previous: &prev, Text: &text}}, name, author, reason, c.version))
+	})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, WriteResponse{Status: "Success", Service: svc, Corpus: name, URN: reqURN, Version: c.version})
+}
+
+func (s *Server) writeUnavailable(w http.ResponseWriter, r *http.Request, svc, name string) {
+	msg := fmt.Sprintf("%q is not a valid corpus name.", name)
+	status := http.StatusBadRequest
+	if s.cfg.DataDir == "" {
+		msg, status = "Uploads are disabled (no data_dir configured).", http.StatusForbidden
+	}
+	s.writeError(w, r, status, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: msg})
+}
+
+// commitCorpus validates data, writes it to path atomically, records the change and serves it. On
+// failure the response has been written and the stored corpus is unchanged. Callers hold s.writeMu.
+func (s *Server) commitCorpus(w http.ResponseWriter, r *http.Request, svc, name, path string, data []byte, record func(*corpus) error) (*corpus, bool) {
+	cc := s.corpusConfig(path)
+	diags, err := ValidateCEX(bytes.NewReader(data), cc)
+	if err == nil {
+		if errs, _ := summarise(diags); errs > 0 {
+			s.writeError(w, r, http.StatusUnprocessableEntity, "", WriteResponse{
+				Status: "Exception", Service: svc, Corpus: name, Message: fmt.Sprintf("Rejected: %d validation error(s).", errs), Diagnostics: diags,
+			})
+			return nil, false
+		}
+	}
+	var c *corpus
+	if err == nil {
+		c, err = readVersionedCorpus(bytes.NewReader(data), cc)
+	}
+	if err == nil {
+		err = storeRecorded(path, data, func() error { return record(c) })
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "", WriteResponse{Status: "Exception", Service: svc, Corpus: name, Message: "Couldn't store corpus: " + err.Error()})
+		return nil, false
+	}
+	s.corpora.replace(path, c)
+	return c, true
+}
+
+// storeRecorded writes data to path and then records the change; if that fails, the previous
+// content of path is put back, so nothing is stored that the history doesn't show.
+func storeRecorded(path string, data []byte, record func() error) error {
+	prev, readErr := os.ReadFile(path)
+	if readErr != nil && !errors.Is(readErr, os.ErrNotExist) {
+		return readErr
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	err := record()
+	if err == nil {
+		return nil
+	}
+	if readErr == nil {
+		_ = writeFileAtomic(path, prev)
+	} else {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// passageBody reads the new text (and reason) from a JSON {"text": ..., "reason": ...} or a plain-text body.
+func passageBody(r *http.Request) (text, reason string, err error) {
+	raw, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return "", "", err
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+		var body struct {
+			Text   *string `json:"text"`
+			Reason string  `json:"reason"`
+		}
+		if err := json.Unmarshal(raw, &body); err != nil || body.Text == nil {
+			return "", "", errors.New(`Body must be {"text": "...", "reason": "..."}.`)
+		}
+		text, reason = *body.Text, body.Reason
+	} else {
+		text = strings.TrimRight(string(raw), "\r\n")
+	}
+	if strings.ContainsAny(text, "\r\n") {
+		return "", "", errors.New("Passage text must be a single line.")
+	}
+	return text, changeReason(r, reason), nil
+}
+
+// changeReason is the reason given in the body, else ?reason= or the X-Change-Reason header.
+func changeReason(r *http.Request, fromBody string) string {
+	if fromBody != "" {
+		return fromBody
+	}
+	if v := r.URL.Query().Get("reason"); v != "" {
+		return v
+	}
+	return r.Header.Get("X-Change-Reason")
+}
+
+// readStored parses the stored corpus at path, or returns nil if there is none yet.
+func (s *Server) readStored(path string) (*corpus, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readCorpus(f, s.corpusConfig(path))
+}
+
+// replacePassage rewrites the #!ctsdata row of urn in a CEX file, leaving every other byte in place.
+// It returns the new file and the previous text.
+func replacePassage(data []byte, urn, text string, cc CorpusConfig) ([]byte, string, error) {
+	cr, err := cex.NewReader(bytes.NewReader(data), cexOptions(cc))
+	if err != nil {
+		return nil, "", err
+	}
+	line, prev := 0, ""
+	for {
+		row, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if row.Section != "ctsdata" {
+			continue
+		}
+		if f := cr.Split(row.Text); len(f) == 2 && strings.TrimSpace(f[0]) == urn {
+			line, prev = row.Num, f[1]
+			break
+		}
+	}
+	if line == 0 {
+		return nil, "", errPassage(http.StatusNotFound, "Could not find node to %s in source.", urn)
+	}
+	sep := cr.Sep()
+	if sep == 0 {
+		sep = '#'
+	}
+	if strings.ContainsRune(text, sep) {
+		return nil, "", errPassage(http.StatusBadRequest, "Passage text may not contain the delimiter %q.", sep)
+	}
+
+	var out bytes.Buffer
+	br := bufio.NewReader(bytes.NewReader(data))
+	for n := 1; ; n++ {
+		raw, err := br.ReadString('\n')
+		if n == line {
+			eol := raw[len(strings.TrimRight(raw, "\r\n")):]
+			out.WriteString(urn + string(sep) + text + eol)
+		} else {
+			out.WriteString(raw)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return out.Bytes(), prev, nil
+}